@@ -1,19 +1,35 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"file-sharing-backend/internal/database"
 	"file-sharing-backend/internal/handlers"
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/metrics"
 	"file-sharing-backend/internal/middleware"
 	"file-sharing-backend/internal/services"
-
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
+	"file-sharing-backend/internal/storage"
 )
 
 func main() {
+	// Structured JSON logs; the log package is routed through the same logger
+	logger := logging.New(os.Stdout)
+	slog.SetDefault(logger)
+
+	// Refuse to sign tokens with a missing or weak key
+	if err := middleware.LoadJWTSecret(); err != nil {
+		log.Fatal("Invalid JWT_SECRET: ", err)
+	}
+
 	// Initialize database
 	db, err := database.New()
 	if err != nil {
@@ -21,59 +37,130 @@ func main() {
 	}
 	defer db.Close()
 
+	// Initialize event bus for live admin monitoring
+	eventBacklog, _ := strconv.Atoi(os.Getenv("EVENT_BACKLOG_SIZE"))
+	events := services.NewEventBus(eventBacklog)
+
+	// Initialize file storage: the local disk or an S3-compatible bucket
+	var store storage.Storage
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		uploadPath := os.Getenv("UPLOAD_PATH")
+		if uploadPath == "" {
+			uploadPath = "./uploads"
+		}
+		store, err = storage.NewLocal(uploadPath)
+	case "s3":
+		store, err = storage.NewS3FromEnv()
+	default:
+		log.Fatal("Unknown STORAGE_BACKEND: ", backend)
+	}
+	if err != nil {
+		log.Fatal("Failed to initialize storage:", err)
+	}
+
+	// Initialize background job tracking
+	jobs := services.NewJobRegistry()
+
+	// Initialize optional image variant generation
+	imageService := services.NewImageVariantService(db, jobs, store, logger)
+	imageService.StartWorker()
+
+	// Initialize optional malware scanning of uploads
+	scanService := services.NewScanService(db, events, jobs, store, logger)
+	scanService.StartWorker()
+
+	// Initialize background thumbnail generation of uploaded images
+	thumbnailService := services.NewThumbnailService(db, jobs, store, logger)
+	thumbnailService.StartWorker()
+
+	// Initialize admin-managed settings
+	settingsService := services.NewSettingsService(db)
+
+	// Initialize share view counting
+	viewCounter := services.NewViewCounter(db)
+
+	// Initialize download history retention
+	history := services.NewDownloadHistory(db)
+
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db)
-	fileHandler := handlers.NewFileHandler(db)
-	adminHandler := handlers.NewAdminHandler(db)
+	mailer := services.NewMailerFromEnv(logger)
+	authHandler := handlers.NewAuthHandler(db, history, mailer, settingsService, store)
+	fileHandler := handlers.NewFileHandler(db, events, history, imageService, scanService, settingsService, store, thumbnailService, viewCounter)
+	integrity := services.NewIntegrityService(db, history, jobs, store)
+	adminHandler := handlers.NewAdminHandler(db, events, history, jobs, integrity, store)
+	settingsHandler := handlers.NewSettingsHandler(settingsService)
+
+	// SIGINT and SIGTERM start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Initialize cleanup service
-	cleanupService := services.NewCleanupService(db)
-	cleanupService.StartCleanupRoutine()
-
-	// Initialize Gin
-	r := gin.Default()
-
-	// CORS middleware
-	r.Use(cors.New(cors.Config{
-		AllowOrigins: []string{"*"},
-		AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders: []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"},
-	}))
-
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
-
-	// Auth routes
-	r.POST("/api/auth/register", authHandler.Register)
-	r.POST("/api/auth/login", authHandler.Login)
-
-	// Public file access
-	r.GET("/share/:uuid", fileHandler.GetFile)
-	r.GET("/api/files/info/:uuid", fileHandler.GetFileInfo)
-	r.GET("/api/files/info/:uuid", fileHandler.GetFileInfo)
-
-	// Protected routes
-	api := r.Group("/api")
-	api.Use(middleware.AuthMiddleware())
-	{
-		// File routes
-		api.POST("/files/upload", fileHandler.UploadFiles)
-		api.GET("/files", fileHandler.GetUserFiles)
-		api.DELETE("/files/:uuid", fileHandler.DeleteFile)
-
-		// Admin routes
-		admin := api.Group("/admin")
-		admin.Use(middleware.AdminMiddleware())
-		{
-			admin.GET("/stats", adminHandler.GetStats)
-			admin.GET("/users", adminHandler.GetAllUsers)
-			admin.GET("/files", adminHandler.GetAllFiles)
-			admin.DELETE("/files/:id", adminHandler.DeleteFileAdmin)
+	cleanupService := services.NewCleanupService(db, events, history, jobs, store, logger)
+	cleanupService.StartCleanupRoutine(ctx)
+
+	// The number of active files is exposed with the other metrics
+	metrics.RegisterActiveFiles(db)
+	r, err := newRouter(logger, authHandler, fileHandler, adminHandler, settingsHandler)
+	if err != nil {
+		log.Fatal("Failed to set up routes: ", err)
+	}
+
+	server := &http.Server{
+		Addr:              ":8080",
+		Handler:           r,
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", time.Minute),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    envInt("HTTP_MAX_HEADER_BYTES", 1<<20),
+	}
+	// Live event streams never end on their own
+	server.RegisterOnShutdown(events.Close)
+
+	go func() {
+		log.Println("Server starting on :8080...")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server failed: ", err)
 		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	// In-flight requests and background jobs get the grace period to
+	// finish (0 waits for them indefinitely); the database is closed only
+	// after they have
+	grace := envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	log.Printf("Shutting down, waiting up to %s for in-flight requests...", grace)
+	shutdownCtx := context.Background()
+	if grace > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, grace)
+		defer cancel()
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Requests still in flight at shutdown were cut off: %v", err)
+	}
+	if err := jobs.Wait(shutdownCtx); err != nil {
+		log.Printf("Background jobs still running at shutdown were cut off: %v", err)
 	}
+	log.Println("Server stopped")
+}
 
-	log.Println("Server starting on :8080...")
-	log.Fatal(r.Run(":8080"))
-}
\ No newline at end of file
+// envDuration reads a duration such as "30s" from the environment; "0"
+// disables the corresponding timeout.
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d < 0 {
+		return def
+	}
+	return d
+}
+
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"file-sharing-backend/internal/handlers"
+	"file-sharing-backend/internal/metrics"
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newRouter builds the HTTP router: the global middleware, every route with
+// its rate limits and the JSON answers for unknown paths and methods. Limits
+// and request settings are read from the environment.
+func newRouter(logger *slog.Logger, authHandler *handlers.AuthHandler, fileHandler *handlers.FileHandler, adminHandler *handlers.AdminHandler, settingsHandler *handlers.SettingsHandler) (*gin.Engine, error) {
+	// Email availability checks per client IP and minute
+	availabilityLimit := envInt("AVAILABILITY_RATE_LIMIT", 10)
+
+	// Share code lookups per client IP and minute
+	shareCodeLimit := envInt("SHARE_CODE_RATE_LIMIT", 10)
+
+	// Share gate lookups per client IP and minute
+	gateLimit := envInt("GATE_RATE_LIMIT", 10)
+
+	// Share password checks per client IP and minute
+	passwordCheckLimit := envInt("PASSWORD_CHECK_RATE_LIMIT", 10)
+
+	// Data exports per user and hour
+	exportLimit := envInt("EXPORT_RATE_LIMIT", 3)
+
+	// Verification email resends per user and hour
+	verificationResendLimit := envInt("VERIFICATION_RESEND_RATE_LIMIT", 3)
+
+	// Password reset requests per client IP and hour
+	passwordResetLimit := envInt("PASSWORD_RESET_RATE_LIMIT", 5)
+
+	// Token buckets: public requests per client IP, authenticated requests
+	// and uploads per user, and a stricter bucket for login attempts per IP
+	publicLimit := middleware.TokenBucketMiddleware(envInt("PUBLIC_RATE_LIMIT", 120))
+	userLimit := middleware.UserTokenBucketMiddleware(envInt("USER_RATE_LIMIT", 300))
+	uploadLimit := middleware.UserTokenBucketMiddleware(envInt("UPLOAD_RATE_LIMIT", 30))
+	loginLimit := middleware.TokenBucketMiddleware(envInt("LOGIN_RATE_LIMIT", 5))
+
+	// Initialize Gin; requests with a known path but another method get 405
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
+	r.Use(gin.Recovery())
+
+	// Request IDs and one structured log line per request
+	r.Use(middleware.RequestIDMiddleware(logger))
+
+	// Request latency and status per route, exposed with the other metrics
+	r.Use(metrics.Middleware())
+
+	// Multipart parts beyond this many bytes are buffered in temporary files
+	r.MaxMultipartMemory = int64(envInt("MAX_MULTIPART_MEMORY", 32<<20))
+	if proxies := middleware.TrustedProxies(); len(proxies) > 0 {
+		if err := r.SetTrustedProxies(proxies); err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+	}
+
+	// External scheme detection and optional HTTPS enforcement
+	r.Use(middleware.HTTPSMiddleware())
+
+	// Security headers
+	r.Use(middleware.SecurityHeadersMiddleware())
+
+	// Overall request body limit in bytes (MAX_REQUEST_SIZE, 0 = unlimited)
+	r.Use(middleware.BodyLimitMiddleware(int64(envInt("MAX_REQUEST_SIZE", 0))))
+
+	// CORS for the origins in ALLOWED_ORIGINS
+	corsMiddleware, err := middleware.CORSMiddleware()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALLOWED_ORIGINS: %w", err)
+	}
+	r.Use(corsMiddleware)
+
+	// Health check
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// Prometheus metrics, behind a bearer token if METRICS_TOKEN is set
+	r.GET("/metrics", metrics.Handler(os.Getenv("METRICS_TOKEN")))
+
+	// Unauthenticated routes share the per-IP bucket. Routes are grouped by
+	// area; keep each path registered once (gin panics on duplicates)
+	public := r.Group("/", publicLimit)
+	longRunning := middleware.LongRunningMiddleware()
+
+	// Public configuration
+	public.GET("/api/config", settingsHandler.GetConfig)
+
+	// Auth routes
+	public.POST("/api/auth/register", authHandler.Register)
+	public.POST("/api/auth/login", loginLimit, authHandler.Login)
+	public.POST("/api/auth/refresh", authHandler.RefreshToken)
+	public.POST("/api/auth/logout", authHandler.Logout)
+	public.GET("/api/auth/available", middleware.RateLimitMiddleware(availabilityLimit, time.Minute), authHandler.CheckEmailAvailable)
+	public.GET("/api/auth/verify", authHandler.VerifyEmail)
+	public.POST("/api/auth/forgot-password", middleware.RateLimitMiddleware(passwordResetLimit, time.Hour), authHandler.ForgotPassword)
+	public.POST("/api/auth/reset-password", middleware.RateLimitMiddleware(passwordResetLimit, time.Hour), authHandler.ResetPassword)
+
+	// Share downloads
+	public.GET("/share/:uuid", longRunning, fileHandler.GetFile)
+	public.POST("/share/:uuid/unlock", fileHandler.UnlockShare)
+	public.GET("/download/:uuid", longRunning, fileHandler.GetSignedDownload)
+	public.GET("/p/:code", middleware.RateLimitMiddleware(shareCodeLimit, time.Minute), longRunning, fileHandler.GetFileByCode)
+
+	// Share details and previews
+	public.GET("/api/files/info/:uuid", fileHandler.GetFileInfo)
+	public.GET("/api/files/info/:uuid/digest", longRunning, fileHandler.GetFileDigest)
+	public.GET("/api/files/info/:uuid/preview", fileHandler.GetSharePreview)
+	public.GET("/api/files/info/:uuid/thumbnail", fileHandler.GetShareThumbnail)
+	public.POST("/api/files/info/:uuid/password", middleware.RateLimitMiddleware(passwordCheckLimit, time.Minute), fileHandler.VerifySharePassword)
+	public.GET("/api/files/:uuid/gate", middleware.RateLimitMiddleware(gateLimit, time.Minute), fileHandler.GetFileGate)
+	public.GET("/api/oembed", fileHandler.GetOEmbed)
+
+	// Folders, albums and public profiles
+	public.GET("/api/folders/:uuid", fileHandler.GetFolder)
+	public.GET("/folder/:uuid/*path", longRunning, fileHandler.GetFolderEntry)
+	public.GET("/album/:uuid", fileHandler.GetAlbum)
+	public.GET("/album/:uuid/zip", longRunning, fileHandler.DownloadAlbum)
+	public.GET("/api/users/:id/public-files", fileHandler.GetPublicFiles)
+
+	// Protected routes
+	api := r.Group("/api")
+	api.Use(middleware.AuthMiddleware(authHandler.SessionValid, authHandler.ResolveAPIKey), userLimit)
+	{
+		// Account
+		api.GET("/auth/profile", authHandler.GetProfile)
+		api.PUT("/auth/password", authHandler.ChangePassword)
+		api.DELETE("/auth/account", authHandler.DeleteAccount)
+		api.POST("/auth/verify/resend", middleware.UserRateLimitMiddleware(verificationResendLimit, time.Hour), authHandler.ResendVerificationEmail)
+		api.GET("/auth/export", middleware.UserRateLimitMiddleware(exportLimit, time.Hour), longRunning, authHandler.ExportData)
+		api.POST("/auth/apikeys", authHandler.CreateAPIKey)
+		api.GET("/auth/apikeys", authHandler.GetAPIKeys)
+		api.DELETE("/auth/apikeys/:id", authHandler.DeleteAPIKey)
+		api.GET("/auth/credentials", authHandler.GetCredentials)
+		api.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
+
+		// Uploads
+		api.POST("/files/upload", uploadLimit, authHandler.RequireVerifiedEmail, longRunning, fileHandler.UploadFiles)
+		api.POST("/uploads", uploadLimit, authHandler.RequireVerifiedEmail, fileHandler.CreateResumableUpload)
+		api.HEAD("/uploads/:id", fileHandler.GetResumableUpload)
+		api.GET("/uploads/:id", fileHandler.GetResumableUpload)
+		api.PATCH("/uploads/:id", longRunning, fileHandler.AppendResumableUpload)
+		api.DELETE("/uploads/:id", fileHandler.AbortResumableUpload)
+		api.POST("/uploads/:id/complete", longRunning, fileHandler.CompleteResumableUpload)
+
+		// File lists and bulk operations
+		api.GET("/files", fileHandler.GetUserFiles)
+		api.POST("/files/delete", fileHandler.DeleteFiles)
+		api.GET("/files/zip", longRunning, fileHandler.DownloadFiles)
+		api.POST("/files/extend-all", fileHandler.ExtendAllFiles)
+		api.POST("/files/tags/add", fileHandler.AddTagToFiles)
+		api.POST("/files/tags/remove", fileHandler.RemoveTagFromFiles)
+		api.GET("/tags", fileHandler.GetUserTags)
+
+		// Single files
+		api.PATCH("/files/:uuid", fileHandler.UpdateFile)
+		api.DELETE("/files/:uuid", fileHandler.DeleteFile)
+		api.POST("/files/:uuid/restore", fileHandler.RestoreFile)
+		api.GET("/files/:uuid/metadata", fileHandler.GetFileMetadata)
+		api.PUT("/files/:uuid/metadata/:key", fileHandler.SetFileMetadata)
+		api.DELETE("/files/:uuid/metadata/:key", fileHandler.DeleteFileMetadata)
+		api.GET("/files/:uuid/exif", fileHandler.GetFileExif)
+		api.GET("/files/:uuid/thumbnail", fileHandler.GetFileThumbnail)
+
+		// Share settings
+		api.PUT("/files/:uuid/password", fileHandler.SetSharePassword)
+		api.POST("/files/:uuid/code", fileHandler.CreateShareCode)
+		api.DELETE("/files/:uuid/code", fileHandler.DeleteShareCode)
+		api.GET("/files/:uuid/link", fileHandler.CreateSignedLink)
+		api.PUT("/files/:uuid/download-window", fileHandler.UpdateDownloadWindow)
+		api.POST("/files/:uuid/disable", fileHandler.DisableShare)
+		api.POST("/files/:uuid/enable", fileHandler.EnableShare)
+		api.PUT("/files/:uuid/public-listed", fileHandler.SetPublicListed)
+		api.PUT("/files/:uuid/max-concurrent-downloads", fileHandler.UpdateMaxConcurrentDownloads)
+
+		// Statistics
+		api.GET("/files/downloads/daily", fileHandler.GetDailyDownloads)
+		api.GET("/files/:uuid/stats", fileHandler.GetFileStats)
+		api.GET("/files/:uuid/downloads", fileHandler.GetFileDownloads)
+
+		// Admin routes, each gated by the least role allowed to use it;
+		// moderators can find and delete files but not see users
+		admin := api.Group("/admin")
+		requireModerator := middleware.RequireRole(middleware.RoleModerator)
+		requireAdmin := middleware.RequireRole(middleware.RoleAdmin)
+		{
+			// Statistics and events
+			admin.GET("/stats", requireAdmin, adminHandler.GetStats)
+			admin.GET("/stats/downloads", requireAdmin, adminHandler.GetDailyDownloads)
+			admin.GET("/events", requireAdmin, longRunning, adminHandler.StreamEvents)
+
+			// Users
+			admin.GET("/users", requireAdmin, adminHandler.GetAllUsers)
+			admin.PUT("/users/:id/role", requireAdmin, adminHandler.SetUserRole)
+			admin.PUT("/users/:id/max-active-files", requireAdmin, adminHandler.SetUserMaxActiveFiles)
+			admin.GET("/export/users", requireAdmin, longRunning, adminHandler.ExportUsers)
+
+			// Files
+			admin.GET("/files", requireModerator, adminHandler.GetAllFiles)
+			admin.GET("/export/files", requireAdmin, longRunning, adminHandler.ExportFiles)
+			admin.DELETE("/files/:id", requireModerator, adminHandler.DeleteFileAdmin)
+			admin.PUT("/files/:id/rate-limit", requireAdmin, adminHandler.SetFileRateLimit)
+			admin.GET("/files/:id/downloads", requireAdmin, adminHandler.GetFileDownloads)
+			admin.POST("/files/reconcile-counts", requireAdmin, adminHandler.ReconcileDownloadCounts)
+
+			// Jobs and maintenance
+			admin.GET("/jobs", requireAdmin, adminHandler.GetJobs)
+			admin.POST("/jobs/:name/run", requireAdmin, adminHandler.RunJob)
+			admin.POST("/cleanup", requireAdmin, adminHandler.RunCleanup)
+			admin.POST("/maintenance/integrity", requireAdmin, adminHandler.RunIntegrityCheck)
+			admin.GET("/maintenance/integrity", requireAdmin, adminHandler.GetIntegrityReport)
+			admin.GET("/orphans", requireAdmin, longRunning, adminHandler.GetOrphans)
+
+			// Settings
+			admin.GET("/settings/password-policy", requireAdmin, settingsHandler.GetPasswordPolicy)
+			admin.PUT("/settings/password-policy", requireAdmin, settingsHandler.UpdatePasswordPolicy)
+			admin.GET("/settings/require-share-password", requireAdmin, settingsHandler.GetRequireSharePassword)
+			admin.PUT("/settings/require-share-password", requireAdmin, settingsHandler.UpdateRequireSharePassword)
+		}
+	}
+
+	// Unknown paths and methods get JSON errors like every other route
+	r.NoRoute(handlers.NotFound)
+	r.NoMethod(handlers.MethodNotAllowed(r))
+	return r, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"file-sharing-backend/internal/database/dbtest"
+	"file-sharing-backend/internal/handlers"
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testRouter builds the router with handlers on a database that has no
+// rows.
+func testRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := dbtest.Open(t, func(string, []driver.Value) (*dbtest.Result, error) { return nil, nil })
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := services.NewEventBus(0)
+	jobs := services.NewJobRegistry()
+	history := services.NewDownloadHistory(db)
+	settings := services.NewSettingsService(db)
+	r, err := newRouter(logger,
+		handlers.NewAuthHandler(db, history, services.NewMailerFromEnv(logger), settings, store),
+		handlers.NewFileHandler(db, events, history, services.NewImageVariantService(db, jobs, store, logger), services.NewScanService(db, events, jobs, store, logger), settings, store, services.NewThumbnailService(db, jobs, store, logger), services.NewViewCounter(db)),
+		handlers.NewAdminHandler(db, events, history, jobs, services.NewIntegrityService(db, history, jobs, store), store),
+		handlers.NewSettingsHandler(settings),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// routes is every method and path the server serves, so adding, removing or
+// moving a route is a deliberate change to this list.
+var routes = []string{
+	// Health check and metrics
+	"GET /health",
+	"GET /metrics",
+
+	// Public configuration
+	"GET /api/config",
+
+	// Auth routes
+	"POST /api/auth/register",
+	"POST /api/auth/login",
+	"POST /api/auth/refresh",
+	"POST /api/auth/logout",
+	"GET /api/auth/available",
+	"GET /api/auth/verify",
+	"POST /api/auth/forgot-password",
+	"POST /api/auth/reset-password",
+
+	// Share downloads
+	"GET /share/:uuid",
+	"POST /share/:uuid/unlock",
+	"GET /download/:uuid",
+	"GET /p/:code",
+
+	// Share details and previews
+	"GET /api/files/info/:uuid",
+	"GET /api/files/info/:uuid/digest",
+	"GET /api/files/info/:uuid/preview",
+	"GET /api/files/info/:uuid/thumbnail",
+	"POST /api/files/info/:uuid/password",
+	"GET /api/files/:uuid/gate",
+	"GET /api/oembed",
+
+	// Folders, albums and public profiles
+	"GET /api/folders/:uuid",
+	"GET /folder/:uuid/*path",
+	"GET /album/:uuid",
+	"GET /album/:uuid/zip",
+	"GET /api/users/:id/public-files",
+
+	// Account
+	"GET /api/auth/profile",
+	"PUT /api/auth/password",
+	"DELETE /api/auth/account",
+	"POST /api/auth/verify/resend",
+	"GET /api/auth/export",
+	"POST /api/auth/apikeys",
+	"GET /api/auth/apikeys",
+	"DELETE /api/auth/apikeys/:id",
+	"GET /api/auth/credentials",
+	"DELETE /api/auth/sessions/:id",
+
+	// Uploads
+	"POST /api/files/upload",
+	"POST /api/uploads",
+	"HEAD /api/uploads/:id",
+	"GET /api/uploads/:id",
+	"PATCH /api/uploads/:id",
+	"DELETE /api/uploads/:id",
+	"POST /api/uploads/:id/complete",
+
+	// File lists and bulk operations
+	"GET /api/files",
+	"POST /api/files/delete",
+	"GET /api/files/zip",
+	"POST /api/files/extend-all",
+	"POST /api/files/tags/add",
+	"POST /api/files/tags/remove",
+	"GET /api/tags",
+
+	// Single files
+	"PATCH /api/files/:uuid",
+	"DELETE /api/files/:uuid",
+	"POST /api/files/:uuid/restore",
+	"GET /api/files/:uuid/metadata",
+	"PUT /api/files/:uuid/metadata/:key",
+	"DELETE /api/files/:uuid/metadata/:key",
+	"GET /api/files/:uuid/exif",
+	"GET /api/files/:uuid/thumbnail",
+
+	// Share settings
+	"PUT /api/files/:uuid/password",
+	"POST /api/files/:uuid/code",
+	"DELETE /api/files/:uuid/code",
+	"GET /api/files/:uuid/link",
+	"PUT /api/files/:uuid/download-window",
+	"POST /api/files/:uuid/disable",
+	"POST /api/files/:uuid/enable",
+	"PUT /api/files/:uuid/public-listed",
+	"PUT /api/files/:uuid/max-concurrent-downloads",
+
+	// File statistics
+	"GET /api/files/downloads/daily",
+	"GET /api/files/:uuid/stats",
+	"GET /api/files/:uuid/downloads",
+
+	// Admin statistics and events
+	"GET /api/admin/stats",
+	"GET /api/admin/stats/downloads",
+	"GET /api/admin/events",
+
+	// Admin users
+	"GET /api/admin/users",
+	"PUT /api/admin/users/:id/role",
+	"PUT /api/admin/users/:id/max-active-files",
+	"GET /api/admin/export/users",
+
+	// Admin files
+	"GET /api/admin/files",
+	"GET /api/admin/export/files",
+	"DELETE /api/admin/files/:id",
+	"PUT /api/admin/files/:id/rate-limit",
+	"GET /api/admin/files/:id/downloads",
+	"POST /api/admin/files/reconcile-counts",
+
+	// Admin jobs and maintenance
+	"GET /api/admin/jobs",
+	"POST /api/admin/jobs/:name/run",
+	"POST /api/admin/cleanup",
+	"POST /api/admin/maintenance/integrity",
+	"GET /api/admin/maintenance/integrity",
+	"GET /api/admin/orphans",
+
+	// Admin settings
+	"GET /api/admin/settings/password-policy",
+	"PUT /api/admin/settings/password-policy",
+	"GET /api/admin/settings/require-share-password",
+	"PUT /api/admin/settings/require-share-password",
+}
+
+func TestRouterRoutes(t *testing.T) {
+	var got []string
+	for _, route := range testRouter(t).Routes() {
+		got = append(got, route.Method+" "+route.Path)
+	}
+	want := append([]string(nil), routes...)
+	sort.Strings(got)
+	sort.Strings(want)
+
+	registered := make(map[string]bool)
+	for _, route := range got {
+		registered[route] = true
+	}
+	expected := make(map[string]bool)
+	for _, route := range want {
+		if expected[route] {
+			t.Errorf("%s is listed twice", route)
+		}
+		expected[route] = true
+		if !registered[route] {
+			t.Errorf("%s is not registered", route)
+		}
+	}
+	for _, route := range got {
+		if !expected[route] {
+			t.Errorf("%s is registered but not expected", route)
+		}
+	}
+}
+
+// jsonError sends a request through the router and decodes its JSON body.
+func jsonError(t *testing.T, r *gin.Engine, method, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: body %q is not JSON: %v", method, path, w.Body, err)
+	}
+	return w, body
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := testRouter(t)
+	for _, path := range []string{"/nope", "/api/nope", "/api/files/info/abc/nope"} {
+		w, body := jsonError(t, r, http.MethodGet, path)
+		if w.Code != http.StatusNotFound || body["error"] != "Not found" {
+			t.Errorf("GET %s: %d %v, want 404 Not found", path, w.Code, body)
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	r := testRouter(t)
+	tests := []struct {
+		method, path string
+		allow        string
+	}{
+		{method: http.MethodDelete, path: "/health", allow: "GET"},
+		{method: http.MethodPut, path: "/share/abc", allow: "GET"},
+		{method: http.MethodPost, path: "/api/uploads/abc", allow: "DELETE, GET, HEAD, PATCH"},
+	}
+	for _, tt := range tests {
+		w, body := jsonError(t, r, tt.method, tt.path)
+		if w.Code != http.StatusMethodNotAllowed || body["error"] != "Method not allowed" {
+			t.Errorf("%s %s: %d %v, want 405 Method not allowed", tt.method, tt.path, w.Code, body)
+		}
+		if allow := w.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+		if _, ok := body["allowed_methods"]; !ok {
+			t.Errorf("%s %s: body %v lacks allowed_methods", tt.method, tt.path, body)
+		}
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 type DB struct {
 	*sql.DB
+	retryAttempts int
+	retryBackoff  time.Duration
+	replica       *replica
 }
 
 func New() (*DB, error) {
@@ -38,5 +43,19 @@ func New() (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &DB{db}, nil
-}
\ No newline at end of file
+	retryAttempts := 3
+	if v, err := strconv.Atoi(os.Getenv("DB_RETRY_ATTEMPTS")); err == nil && v > 0 {
+		retryAttempts = v
+	}
+	retryBackoff := 100 * time.Millisecond
+	if v, err := time.ParseDuration(os.Getenv("DB_RETRY_BACKOFF")); err == nil && v > 0 {
+		retryBackoff = v
+	}
+
+	return &DB{
+		DB:            db,
+		retryAttempts: retryAttempts,
+		retryBackoff:  retryBackoff,
+		replica:       openReplica(retryAttempts, retryBackoff),
+	}, nil
+}
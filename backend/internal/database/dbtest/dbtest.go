@@ -0,0 +1,243 @@
+// Package dbtest is a scripted stand-in for PostgreSQL in tests. Every
+// statement is passed to a handler, which answers it from whatever state the
+// test keeps, so code running SQL can be tested without a server.
+//
+// Statements are not isolated in transactions: they take effect as the
+// handler applies them. BEGIN, COMMIT and ROLLBACK are passed to the handler
+// like any other statement, so tests can observe or fail them.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"file-sharing-backend/internal/database"
+)
+
+// Result answers a statement: Rows for queries, RowsAffected for others.
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	// Next, when set, produces the rows instead of Rows, one per call, and
+	// returns io.EOF after the last. It runs when the code under test reads
+	// the row.
+	Next func() ([]driver.Value, error)
+}
+
+// Rows answers a query with rows of values; the columns are named c1, c2...
+func Rows(rows ...[]driver.Value) *Result {
+	r := &Result{Rows: rows}
+	if len(rows) > 0 {
+		for i := range rows[0] {
+			r.Columns = append(r.Columns, fmt.Sprintf("c%d", i+1))
+		}
+	}
+	return r
+}
+
+// Row answers a query with a single row.
+func Row(values ...driver.Value) *Result {
+	return Rows(values)
+}
+
+// Affected answers a statement that changed n rows.
+func Affected(n int64) *Result {
+	return &Result{RowsAffected: n}
+}
+
+// Handler answers a statement. query has its whitespace collapsed to single
+// spaces. A nil Result is a query without rows or a statement changing
+// none. Handlers are never called concurrently.
+type Handler func(query string, args []driver.Value) (*Result, error)
+
+var (
+	registerOnce sync.Once
+	mu           sync.Mutex
+	handlers     = make(map[string]Handler)
+	opened       int
+)
+
+// Open returns a database whose statements are answered by handler. It is
+// closed when the test ends.
+func Open(t testing.TB, handler Handler) *database.DB {
+	t.Helper()
+	registerOnce.Do(func() { sql.Register("dbtest", fakeDriver{}) })
+
+	mu.Lock()
+	opened++
+	name := fmt.Sprintf("%s#%d", t.Name(), opened)
+	handlers[name] = handler
+	mu.Unlock()
+
+	pool, err := sql.Open("dbtest", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Close()
+		mu.Lock()
+		delete(handlers, name)
+		mu.Unlock()
+	})
+	// Without retry settings every statement runs once, so failures a
+	// handler injects surface right away
+	return &database.DB{DB: pool}
+}
+
+// Match reports whether query contains every one of parts, which keeps
+// handlers readable without repeating whole statements.
+func Match(query string, parts ...string) bool {
+	for _, part := range parts {
+		if !strings.Contains(query, part) {
+			return false
+		}
+	}
+	return true
+}
+
+func run(name, query string, args []driver.Value) (*Result, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	handler, ok := handlers[name]
+	if !ok {
+		return nil, fmt.Errorf("dbtest: database %s is closed", name)
+	}
+	res, err := handler(strings.Join(strings.Fields(query), " "), args)
+	if res == nil && err == nil {
+		res = &Result{}
+	}
+	return res, err
+}
+
+func values(named []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(named))
+	for i, v := range named {
+		args[i] = v.Value
+	}
+	return args
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return &conn{name: name}, nil
+}
+
+type conn struct {
+	name string
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if _, err := run(c.name, "BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return tx{conn: c}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := run(c.name, query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &rows{name: c.name, result: res}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := run(c.name, query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t tx) Commit() error {
+	_, err := run(t.conn.name, "COMMIT", nil)
+	return err
+}
+
+func (t tx) Rollback() error {
+	_, err := run(t.conn.name, "ROLLBACK", nil)
+	return err
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	res, err := run(s.conn.name, s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, err := run(s.conn.name, s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{name: s.conn.name, result: res}, nil
+}
+
+type rows struct {
+	name   string
+	result *Result
+	next   int
+}
+
+func (r *rows) Columns() []string {
+	return r.result.Columns
+}
+
+func (r *rows) Close() error { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	var row []driver.Value
+	if r.result.Next != nil {
+		// Producers may inspect the test's state, so they run like handlers
+		mu.Lock()
+		var err error
+		row, err = r.result.Next()
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+	} else {
+		if r.next >= len(r.result.Rows) {
+			return io.EOF
+		}
+		row = r.result.Rows[r.next]
+		r.next++
+	}
+	if len(row) != len(dest) {
+		return fmt.Errorf("dbtest: row has %d values for %d columns", len(row), len(dest))
+	}
+	copy(dest, row)
+	return nil
+}
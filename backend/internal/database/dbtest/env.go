@@ -0,0 +1,266 @@
+package dbtest
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/storage"
+)
+
+// Skip, returned by an Answer, passes the statement on to the rules added
+// after it, and from there to the files table.
+var Skip = errors.New("dbtest: skip rule")
+
+// Answer produces the result of a statement matched by a rule.
+type Answer func(args []driver.Value) (*Result, error)
+
+// Rule answers the statements containing all of its query parts.
+type Rule struct {
+	parts  []string
+	answer Answer
+	calls  int
+}
+
+// Return makes the rule answer with res.
+func (r *Rule) Return(res *Result) {
+	r.answer = func([]driver.Value) (*Result, error) { return res, nil }
+}
+
+// Do makes the rule answer with whatever fn returns.
+func (r *Rule) Do(fn Answer) {
+	r.answer = fn
+}
+
+// Fail makes the rule fail the statements it matches with err.
+func (r *Rule) Fail(err error) {
+	r.answer = func([]driver.Value) (*Result, error) { return nil, err }
+}
+
+// Clear makes the rule pass every statement on, as if it had not been added.
+func (r *Rule) Clear() {
+	r.answer = func([]driver.Value) (*Result, error) { return nil, Skip }
+}
+
+// Calls returns how many statements the rule has answered. Answers run
+// while the database is locked, so they must not call it.
+func (r *Rule) Calls() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return r.calls
+}
+
+// File is a row of the files table an Env keeps.
+type File struct {
+	ID   int64
+	UUID string
+	Path string
+	Name string
+	// Expired files are picked up by the cleanup job
+	Expired bool
+	// Trashed files have deleted_at set; the cleanup job purges them once
+	// the trash retention has passed
+	Trashed bool
+}
+
+// Env is a database answered by rules over a files table kept in memory,
+// and a local store in a temporary directory. Tests script only the
+// statements they are about; the files table answers the bookkeeping that
+// uploads, deletes and the cleanup job share.
+//
+// Rules are tried in the order they were added, then the files table.
+// Statements neither knows get no rows, which covers locks, transaction
+// control and the like.
+type Env struct {
+	DB     *database.DB
+	Store  *storage.Local
+	Dir    string
+	Logger *slog.Logger
+	Files  map[int64]*File
+
+	rules  []*Rule
+	nextID int64
+}
+
+// NewEnv returns an Env without rules or files.
+func NewEnv(t testing.TB) *Env {
+	t.Helper()
+	env := &Env{
+		Dir:    t.TempDir(),
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Files:  make(map[int64]*File),
+	}
+	store, err := storage.NewLocal(env.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Store = store
+	env.DB = Open(t, env.handle)
+	return env
+}
+
+// On adds a rule for statements containing every one of parts. It answers
+// with no rows until told otherwise.
+func (e *Env) On(parts ...string) *Rule {
+	r := &Rule{parts: parts}
+	r.Return(nil)
+	mu.Lock()
+	e.rules = append(e.rules, r)
+	mu.Unlock()
+	return r
+}
+
+// AddFile stores a blob holding content and adds a file row named name for
+// it.
+func (e *Env) AddFile(t testing.TB, name, content string) *File {
+	t.Helper()
+	path, _, err := e.Store.Save(name, strings.NewReader(content), storage.CollisionSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.AddRow(name, path)
+}
+
+// AddRow adds a file row named name whose blob is at path.
+func (e *Env) AddRow(name, path string) *File {
+	mu.Lock()
+	defer mu.Unlock()
+	return e.insert(name, path)
+}
+
+func (e *Env) insert(name, path string) *File {
+	e.nextID++
+	f := &File{ID: e.nextID, UUID: "uuid-" + strconv.FormatInt(e.nextID, 10), Path: path, Name: name}
+	e.Files[f.ID] = f
+	return f
+}
+
+// HasBlob reports whether the store has a blob at path.
+func (e *Env) HasBlob(path string) bool {
+	_, err := e.Store.Stat(path)
+	return err == nil
+}
+
+// Blobs lists the names in the storage directory, staged uploads included.
+func (e *Env) Blobs(t testing.TB) []string {
+	t.Helper()
+	entries, err := os.ReadDir(e.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+func (e *Env) handle(query string, args []driver.Value) (*Result, error) {
+	for _, r := range e.rules {
+		if !Match(query, r.parts...) {
+			continue
+		}
+		res, err := r.answer(args)
+		if err == Skip {
+			continue
+		}
+		r.calls++
+		return res, err
+	}
+	return e.files(query, args)
+}
+
+// files answers the statements on the files table that uploads, deletes
+// and the cleanup job have in common.
+func (e *Env) files(query string, args []driver.Value) (*Result, error) {
+	switch {
+	case Match(query, "INSERT INTO files", "RETURNING id"):
+		name, _ := args[2].(string)
+		path, _ := args[3].(string)
+		return Row(e.insert(name, path).ID), nil
+
+	case Match(query, "SELECT file_path FROM files WHERE id = $1"):
+		if f, ok := e.Files[args[0].(int64)]; ok {
+			return Row(f.Path), nil
+		}
+		return nil, nil
+
+	case Match(query, "UPDATE files SET deleted_at = NOW()", "WHERE id = ANY($1) AND deleted_at IS NULL"):
+		var n int64
+		for _, id := range Ints(args[0]) {
+			if f, ok := e.Files[id]; ok && !f.Trashed {
+				f.Trashed = true
+				n++
+			}
+		}
+		return Affected(n), nil
+
+	case Match(query, "SELECT id, uuid, file_path, original_name FROM files WHERE expires_at < NOW()"):
+		// Only a trash retention of zero makes trashed files due at once
+		purge := args[0] == int64(0)
+		var rows [][]driver.Value
+		for _, f := range e.sorted() {
+			if f.Expired || (f.Trashed && purge) {
+				rows = append(rows, []driver.Value{f.ID, f.UUID, f.Path, f.Name})
+			}
+		}
+		return Rows(rows...), nil
+
+	case Match(query, "SELECT EXISTS (SELECT 1 FROM files WHERE file_path = $1 AND id <> ALL($2))"):
+		excluded := make(map[int64]bool)
+		for _, id := range Ints(args[1]) {
+			excluded[id] = true
+		}
+		for _, f := range e.Files {
+			if f.Path == args[0] && !excluded[f.ID] {
+				return Row(true), nil
+			}
+		}
+		return Row(false), nil
+
+	case Match(query, "DELETE FROM files WHERE id = ANY($1)"):
+		var n int64
+		for _, id := range Ints(args[0]) {
+			if _, ok := e.Files[id]; ok {
+				delete(e.Files, id)
+				n++
+			}
+		}
+		return Affected(n), nil
+	}
+	return nil, nil
+}
+
+func (e *Env) sorted() []*File {
+	files := make([]*File, 0, len(e.Files))
+	for _, f := range e.Files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	return files
+}
+
+// Ints reads an integer array sent as pq.Array.
+func Ints(v driver.Value) []int64 {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	}
+	var ids []int64
+	for _, part := range strings.Split(strings.Trim(s, "{}"), ",") {
+		if id, err := strconv.ParseInt(part, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
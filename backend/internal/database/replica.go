@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const replicaCheckInterval = 10 * time.Second
+
+// replica is an optional read-only pool. Reads are sent to it only while
+// its last health check succeeded.
+type replica struct {
+	db      *DB
+	healthy atomic.Bool
+	// stop ends the health checks when the pools are closed
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// openReplica connects to DB_READ_DSN if set. A replica that is down at
+// startup is not fatal: reads use the primary until it comes up.
+func openReplica(retryAttempts int, retryBackoff time.Duration) *replica {
+	dsn := os.Getenv("DB_READ_DSN")
+	if dsn == "" {
+		return nil
+	}
+	pool, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Printf("Ignoring invalid DB_READ_DSN: %v", err)
+		return nil
+	}
+
+	r := &replica{
+		db:   &DB{DB: pool, retryAttempts: retryAttempts, retryBackoff: retryBackoff},
+		stop: make(chan struct{}),
+	}
+	r.check()
+	go r.watch()
+	return r
+}
+
+// watch checks the replica's health periodically until it is closed.
+func (r *replica) watch() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+func (r *replica) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	healthy := r.db.PingContext(ctx) == nil
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			log.Println("Read replica is available, sending reads to it")
+		} else {
+			log.Println("Read replica is unavailable, sending reads to the primary")
+		}
+	}
+}
+
+// Reader returns the pool for read-only queries that tolerate replication
+// lag: the replica when one is configured and healthy, else the primary.
+func (db *DB) Reader() *DB {
+	if db.replica != nil && db.replica.healthy.Load() {
+		return db.replica.db
+	}
+	return db
+}
+
+// RetryRead runs fn with Retry against Reader(). A row missing on the
+// replica may just not have replicated yet, so sql.ErrNoRows is retried on
+// the primary.
+func (db *DB) RetryRead(fn func(*DB) error) error {
+	reader := db.Reader()
+	err := reader.Retry(func() error { return fn(reader) })
+	if err == sql.ErrNoRows && reader != db {
+		err = db.Retry(func() error { return fn(db) })
+	}
+	return err
+}
+
+// Close closes the primary and replica pools and stops the replica's
+// health checks.
+func (db *DB) Close() error {
+	if db.replica != nil {
+		db.replica.stopOnce.Do(func() { close(db.replica.stop) })
+		db.replica.db.DB.Close()
+	}
+	return db.DB.Close()
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// IsTransient reports whether err is a database error that may succeed if
+// retried: dropped connections, serialization failures, deadlocks and
+// server restarts. Everything else is treated as permanent.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08": // connection_exception
+			return true
+		case "40": // transaction_rollback (serialization_failure, deadlock_detected)
+			return true
+		case "57": // operator_intervention (admin_shutdown, cannot_connect_now)
+			return pqErr.Code != "57014" // query_canceled is deliberate
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Retry runs fn, retrying with exponential backoff while it fails with a
+// transient error. Only use it for idempotent work such as reads.
+func (db *DB) Retry(fn func() error) error {
+	backoff := db.retryBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if !IsTransient(err) || attempt >= db.retryAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// QueryRetry is Query with transient failures retried. Errors surfacing
+// while iterating the returned rows are not retried.
+func (db *DB) QueryRetry(query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := db.Retry(func() error {
+		var err error
+		rows, err = db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/middleware"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionValid is the middleware.SessionCheck of the auth middleware. Tokens
+// of deleted users are rejected, as are tokens issued before the user's
+// last password change. Changes are tracked to the second, so a token issued
+// in the same second as a change is still accepted.
+func (h *AuthHandler) SessionValid(userID int, issuedAt int64) (bool, error) {
+	var valid bool
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(
+			"SELECT tokens_valid_after IS NULL OR tokens_valid_after <= to_timestamp($2) FROM users WHERE id = $1",
+			userID, issuedAt,
+		).Scan(&valid)
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return valid, err
+}
+
+// verifyCurrentPassword checks the password of the authenticated user. On
+// failure the response has been written and ok is false.
+func (h *AuthHandler) verifyCurrentPassword(c *gin.Context, userID int, password string) (role string, ok bool) {
+	var passwordHash string
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT password_hash, role FROM users WHERE id = $1", userID).
+			Scan(&passwordHash, &role)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return "", false
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return "", false
+	}
+	return role, true
+}
+
+// ChangePassword replaces the caller's login password after checking the
+// current one. Every existing session ends: refresh tokens are revoked and
+// older access tokens rejected. The response carries fresh tokens for the
+// caller.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, ok := h.verifyCurrentPassword(c, userID, req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	policy, err := h.settings.PasswordPolicy()
+	if err != nil {
+		respondDBError(c, err, "Failed to load password policy")
+		return
+	}
+	if err := policy.Validate(req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondDBError(c, err, "Failed to change password")
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		UPDATE users
+		SET password_hash = $1, tokens_valid_after = date_trunc('second', NOW()), updated_at = NOW()
+		WHERE id = $2`,
+		string(hashedPwd), userID,
+	)
+	if err == nil {
+		_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to change password")
+		return
+	}
+
+	access, refresh, err := h.issueTokens(userID, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.tokenResponse(gin.H{"message": "Password changed"}, access, refresh))
+}
+
+// DeleteAccount deletes the caller's account after checking their password,
+// along with all their files and the files' download history (subject to
+// RETAIN_DOWNLOAD_HISTORY). The rows go in one transaction so nothing is
+// orphaned; blobs are removed once it has committed.
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := h.verifyCurrentPassword(c, userID, req.Password); !ok {
+		return
+	}
+
+	files, err := h.history.DeleteUser(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to delete account")
+		return
+	}
+
+	// Log errors but keep going with the other files
+	logger := logging.FromContext(c)
+	for _, f := range files {
+		if err := services.ReleaseBlob(h.db, h.storage, f.ID, f.Path); err != nil {
+			logger.Warn("deleting blob failed", "event", "blob_delete_failed", "path", f.Path, "error", err)
+		}
+		for _, path := range f.Variants {
+			if err := h.storage.Delete(path); err != nil && !os.IsNotExist(err) {
+				logger.Warn("deleting blob failed", "event", "blob_delete_failed", "path", path, "error", err)
+			}
+		}
+	}
+	logger.Info("account deleted", "event", "account_deleted", "files_deleted", len(files))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted", "files_deleted": len(files)})
+}
@@ -1,61 +1,164 @@
 package handlers
 
 import (
+	"database/sql"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/middleware"
 	"file-sharing-backend/internal/models"
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AdminHandler struct {
-	db *database.DB
+	db        *database.DB
+	events    *services.EventBus
+	history   *services.DownloadHistory
+	jobs      *services.JobRegistry
+	integrity *services.IntegrityService
+	storage   storage.Storage
+	// showFullIPs shows admins the full IP addresses of downloads instead
+	// of truncated ones (ADMIN_SHOW_FULL_IPS)
+	showFullIPs bool
 }
 
-func NewAdminHandler(db *database.DB) *AdminHandler {
-	return &AdminHandler{db: db}
+func NewAdminHandler(db *database.DB, events *services.EventBus, history *services.DownloadHistory, jobs *services.JobRegistry, integrity *services.IntegrityService, store storage.Storage) *AdminHandler {
+	return &AdminHandler{db: db, events: events, history: history, jobs: jobs, integrity: integrity, storage: store, showFullIPs: os.Getenv("ADMIN_SHOW_FULL_IPS") == "true"}
 }
 
 func (h *AdminHandler) GetStats(c *gin.Context) {
 	var stats models.Stats
 
+	// scan runs a single-value stats query, stopping at the first failure
+	var err error
+	reader := h.db.Reader()
+	scan := func(query string, dest interface{}) {
+		if err != nil {
+			return
+		}
+		err = reader.Retry(func() error {
+			return reader.QueryRow(query).Scan(dest)
+		})
+	}
+
 	// Total users
-	h.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&stats.TotalUsers)
+	scan("SELECT COUNT(*) FROM users", &stats.TotalUsers)
 
 	// Total files
-	h.db.QueryRow("SELECT COUNT(*) FROM files").Scan(&stats.TotalFiles)
+	scan("SELECT COUNT(*) FROM files", &stats.TotalFiles)
 
 	// Active files (not expired)
-	h.db.QueryRow("SELECT COUNT(*) FROM files WHERE expires_at > NOW()").Scan(&stats.ActiveFiles)
+	scan("SELECT COUNT(*) FROM files WHERE expires_at > NOW() AND deleted_at IS NULL", &stats.ActiveFiles)
 
-	// Total downloads
-	h.db.QueryRow("SELECT COUNT(*) FROM downloads").Scan(&stats.TotalDownloads)
+	// Total downloads, including those of deleted files when history is
+	// retained and those whose rows were pruned into daily rollups
+	scan(`
+		SELECT (SELECT COUNT(*) FROM downloads) +
+		       (SELECT COALESCE(SUM(count), 0) FROM download_rollups)
+	`, &stats.TotalDownloads)
 
 	// Today's downloads
-	h.db.QueryRow(`
+	scan(`
 		SELECT COUNT(*) FROM downloads 
 		WHERE downloaded_at >= DATE_TRUNC('day', NOW())
-	`).Scan(&stats.TodayDownloads)
+	`, &stats.TodayDownloads)
 
 	// Total file size
-	h.db.QueryRow("SELECT COALESCE(SUM(file_size), 0) FROM files").Scan(&stats.TotalSize)
+	scan("SELECT COALESCE(SUM(file_size), 0) FROM files", &stats.TotalSize)
+
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch stats")
+		return
+	}
+
+	// Activity within from/to, day by day, when a range is given
+	if c.Query("from") != "" || c.Query("to") != "" {
+		from, to, ok := parseDayRange(c)
+		if !ok {
+			return
+		}
+		stats.Range, err = rangeStats(reader, from, to)
+		if err != nil {
+			respondDBError(c, err, "Failed to fetch stats")
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, stats)
 }
 
+// rangeStats counts uploads, uploaded bytes and downloads per day between
+// from and to inclusive, with zero-count days included. Downloads whose rows
+// were pruned are counted from download_rollups.
+func rangeStats(db *database.DB, from, to time.Time) (*models.StatsRange, error) {
+	rows, err := db.QueryRetry(`
+		SELECT TO_CHAR(d.day, 'YYYY-MM-DD'), COALESCE(u.uploads, 0), COALESCE(u.bytes, 0), COALESCE(dl.downloads, 0)
+		FROM generate_series($1::date, $2::date, INTERVAL '1 day') AS d(day)
+		LEFT JOIN (
+			SELECT created_at::date AS day, COUNT(*) AS uploads, SUM(file_size) AS bytes
+			FROM files
+			WHERE created_at >= $1::date AND created_at < $2::date + 1
+			GROUP BY created_at::date
+		) u ON u.day = d.day
+		LEFT JOIN (
+			SELECT day, SUM(n) AS downloads
+			FROM (
+				SELECT downloaded_at::date AS day, 1 AS n
+				FROM downloads
+				WHERE downloaded_at >= $1::date AND downloaded_at < $2::date + 1
+				UNION ALL
+				SELECT day, count
+				FROM download_rollups
+				WHERE day BETWEEN $1::date AND $2::date
+			) all_downloads
+			GROUP BY day
+		) dl ON dl.day = d.day
+		ORDER BY d.day`,
+		from.Format(dayLayout), to.Format(dayLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &models.StatsRange{
+		From:   from.Format(dayLayout),
+		To:     to.Format(dayLayout),
+		Series: []models.DailyStats{},
+	}
+	for rows.Next() {
+		var day models.DailyStats
+		if err := rows.Scan(&day.Day, &day.Uploads, &day.UploadedBytes, &day.Downloads); err != nil {
+			return nil, err
+		}
+		result.Uploads += day.Uploads
+		result.UploadedBytes += day.UploadedBytes
+		result.Downloads += day.Downloads
+		result.Series = append(result.Series, day)
+	}
+	return result, rows.Err()
+}
+
 func (h *AdminHandler) GetAllUsers(c *gin.Context) {
-	rows, err := h.db.Query(`
-		SELECT u.id, u.email, u.is_admin, u.created_at, COUNT(f.id) as file_count
+	rows, err := h.db.QueryRetry(`
+		SELECT u.id, u.email, u.role, u.email_verified, u.created_at, COUNT(f.id) as file_count
 		FROM users u
 		LEFT JOIN files f ON u.id = f.user_id
-		GROUP BY u.id, u.email, u.is_admin, u.created_at
+		GROUP BY u.id, u.email, u.role, u.email_verified, u.created_at
 		ORDER BY u.created_at DESC
 	`)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		respondDBError(c, err, "Failed to fetch users")
 		return
 	}
 	defer rows.Close()
@@ -64,19 +167,21 @@ func (h *AdminHandler) GetAllUsers(c *gin.Context) {
 	for rows.Next() {
 		var user gin.H = make(gin.H)
 		var id int
-		var email string
-		var isAdmin bool
+		var email, role string
+		var emailVerified bool
 		var createdAt time.Time
 		var fileCount int
 
-		err := rows.Scan(&id, &email, &isAdmin, &createdAt, &fileCount)
+		err := rows.Scan(&id, &email, &role, &emailVerified, &createdAt, &fileCount)
 		if err != nil {
 			continue
 		}
 
 		user["id"] = id
 		user["email"] = email
-		user["is_admin"] = isAdmin
+		user["role"] = role
+		user["is_admin"] = role == middleware.RoleAdmin
+		user["email_verified"] = emailVerified
 		user["created_at"] = createdAt
 		user["file_count"] = fileCount
 
@@ -86,17 +191,85 @@ func (h *AdminHandler) GetAllUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"users": users})
 }
 
+// likeEscaper escapes the LIKE wildcards in user input so it is matched
+// literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// GetAllFiles lists every user's files, newest first. search matches the
+// file name or owner email case-insensitively; expired and has_password
+// filter, trashed lists the trash instead, and limit and offset select the
+// page.
 func (h *AdminHandler) GetAllFiles(c *gin.Context) {
-	rows, err := h.db.Query(`
+	limit, offset, ok := parseFileListPage(c)
+	if !ok {
+		return
+	}
+
+	conditions := []string{"f.deleted_at IS NULL"}
+	var args []interface{}
+	if v := c.Query("trashed"); v != "" {
+		trashed, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trashed filter"})
+			return
+		}
+		if trashed {
+			conditions[0] = "f.deleted_at IS NOT NULL"
+		}
+	}
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		// Backed by trigram indexes on both columns
+		args = append(args, "%"+likeEscaper.Replace(search)+"%")
+		conditions = append(conditions, fmt.Sprintf("(f.original_name ILIKE $%d OR u.email ILIKE $%d)", len(args), len(args)))
+	}
+	if v := c.Query("expired"); v != "" {
+		expired, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expired filter"})
+			return
+		}
+		if expired {
+			conditions = append(conditions, "f.expires_at <= NOW()")
+		} else {
+			conditions = append(conditions, "f.expires_at > NOW()")
+		}
+	}
+	if v := c.Query("has_password"); v != "" {
+		hasPassword, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid has_password filter"})
+			return
+		}
+		if hasPassword {
+			conditions = append(conditions, "f.password_hash IS NOT NULL")
+		} else {
+			conditions = append(conditions, "f.password_hash IS NULL")
+		}
+	}
+	where := "WHERE " + strings.Join(conditions, " AND ")
+
+	var total int
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT COUNT(*) FROM files f JOIN users u ON f.user_id = u.id "+where, args...).Scan(&total)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch files")
+		return
+	}
+
+	rows, err := h.db.QueryRetry(`
 		SELECT f.id, f.uuid, f.original_name, f.file_size, f.mime_type,
 		       f.password_hash IS NOT NULL as has_password, f.download_count,
-		       f.expires_at, f.created_at, u.email
+		       f.expires_at, f.created_at, f.deleted_at, f.disabled_at, u.email
 		FROM files f
 		JOIN users u ON f.user_id = u.id
-		ORDER BY f.created_at DESC
-	`)
+		`+where+`
+		ORDER BY f.created_at DESC, f.id DESC`+fmt.Sprintf(`
+		LIMIT %d OFFSET %d`, limit, offset),
+		args...,
+	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		respondDBError(c, err, "Failed to fetch files")
 		return
 	}
 	defer rows.Close()
@@ -110,9 +283,10 @@ func (h *AdminHandler) GetAllFiles(c *gin.Context) {
 		var hasPassword bool
 		var downloadCount int
 		var expiresAt, createdAt time.Time
+		var deletedAt, disabledAt *time.Time
 
 		err := rows.Scan(&id, &uuid, &originalName, &fileSize, &mimeType,
-			&hasPassword, &downloadCount, &expiresAt, &createdAt, &userEmail)
+			&hasPassword, &downloadCount, &expiresAt, &createdAt, &deletedAt, &disabledAt, &userEmail)
 		if err != nil {
 			continue
 		}
@@ -128,11 +302,21 @@ func (h *AdminHandler) GetAllFiles(c *gin.Context) {
 		file["created_at"] = createdAt
 		file["user_email"] = userEmail
 		file["is_expired"] = time.Now().After(expiresAt)
+		file["disabled"] = disabledAt != nil
+		file["disabled_at"] = disabledAt
+		if deletedAt != nil {
+			file["deleted_at"] = *deletedAt
+		}
 
 		files = append(files, file)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"files": files})
+	c.JSON(http.StatusOK, gin.H{
+		"files":  files,
+		"limit":  limit,
+		"offset": offset,
+		"total":  total,
+	})
 }
 
 func (h *AdminHandler) DeleteFileAdmin(c *gin.Context) {
@@ -143,22 +327,253 @@ func (h *AdminHandler) DeleteFileAdmin(c *gin.Context) {
 		return
 	}
 
+	// Like an owner's delete this moves the file to the trash, unless an
+	// admin asks for it to be purged right away, trashed or not; moderators
+	// can only trash files
+	permanent := c.Query("permanent") == "true"
+	if permanent && middleware.GetRole(c) != middleware.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "required_role": middleware.RoleAdmin})
+		return
+	}
+	if !permanent {
+		moved, err := services.TrashFiles(h.db, []int{fileID})
+		if err != nil {
+			respondDBError(c, err, "Failed to delete file")
+			return
+		}
+		if moved == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		h.events.Publish("delete", "File deleted by admin", map[string]interface{}{
+			"file_id": fileID,
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "File moved to trash"})
+		return
+	}
+
 	var filePath string
-	err = h.db.QueryRow("SELECT file_path FROM files WHERE id = $1", fileID).Scan(&filePath)
-	if err != nil {
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT file_path FROM files WHERE id = $1", fileID).Scan(&filePath)
+	})
+	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
 
-	// Delete file from filesystem (ignore errors)
-	// os.Remove(filePath)
+	// Delete file from filesystem unless another file shares the blob. A
+	// blob or variant that cannot be removed keeps the record, so the purge
+	// can be retried instead of orphaning it; one already gone is fine
+	if err := services.ReleaseBlob(h.db, h.storage, fileID, filePath); err != nil {
+		logging.FromContext(c).Error("deleting blob failed", "event", "blob_delete_failed", "file_id", fileID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
+	if err := services.RemoveImageVariants(h.db, h.storage, fileID); err != nil {
+		logging.FromContext(c).Error("deleting image variants failed", "event", "blob_delete_failed", "file_id", fileID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
 
 	// Delete file record from database
-	_, err = h.db.Exec("DELETE FROM files WHERE id = $1", fileID)
+	err = h.history.DeleteFileRecord(fileID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
 		return
 	}
 
+	h.events.Publish("delete", "File deleted by admin", map[string]interface{}{
+		"file_id": fileID,
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
-}
\ No newline at end of file
+}
+
+// ReconcileDownloadCounts recomputes every file's download_count from its
+// rows in the downloads table (plus pruned rollups) and reports how many
+// counts were corrected.
+func (h *AdminHandler) ReconcileDownloadCounts(c *gin.Context) {
+	res, err := h.db.Exec(`
+		UPDATE files f SET download_count = d.actual, updated_at = NOW()
+		FROM (
+			SELECT f2.id,
+			       (SELECT COUNT(*) FROM downloads WHERE file_id = f2.id) +
+			       (SELECT COALESCE(SUM(count), 0) FROM download_rollups WHERE file_id = f2.id) AS actual
+			FROM files f2
+		) d
+		WHERE f.id = d.id AND f.download_count <> d.actual
+	`)
+	if err != nil {
+		respondDBError(c, err, "Failed to reconcile download counts")
+		return
+	}
+	corrected, _ := res.RowsAffected()
+
+	h.events.Publish("reconcile", "Download counts reconciled", map[string]interface{}{
+		"corrected": corrected,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"corrected": corrected})
+}
+
+// SetFileRateLimit overrides the download speed limit of a single file.
+// null restores the global DOWNLOAD_RATE_LIMIT and 0 lifts the limit.
+func (h *AdminHandler) SetFileRateLimit(c *gin.Context) {
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var req struct {
+		BytesPerSecond *int64 `json:"bytes_per_second"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.BytesPerSecond != nil && *req.BytesPerSecond < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bytes_per_second must not be negative"})
+		return
+	}
+
+	res, err := h.db.Exec("UPDATE files SET download_rate_limit = $1, updated_at = NOW() WHERE id = $2", req.BytesPerSecond, fileID)
+	if err != nil {
+		respondDBError(c, err, "Failed to update rate limit")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": fileID, "bytes_per_second": req.BytesPerSecond})
+}
+
+// SetUserMaxActiveFiles overrides how many unexpired files a user may have.
+// null restores the global MAX_ACTIVE_FILES and 0 lifts the cap.
+func (h *AdminHandler) SetUserMaxActiveFiles(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		MaxActiveFiles *int `json:"max_active_files"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxActiveFiles != nil && *req.MaxActiveFiles < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_active_files must not be negative"})
+		return
+	}
+
+	res, err := h.db.Exec("UPDATE users SET max_active_files = $1, updated_at = NOW() WHERE id = $2", req.MaxActiveFiles, userID)
+	if err != nil {
+		respondDBError(c, err, "Failed to update file limit")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": userID, "max_active_files": req.MaxActiveFiles})
+}
+
+// SetUserRole makes a user a user, moderator or admin. The user's access
+// tokens stop working so the new role applies right away; their refresh
+// tokens get new ones carrying it. Admins cannot change their own role, so
+// an instance is never left without one by accident.
+func (h *AdminHandler) SetUserRole(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !middleware.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be user, moderator or admin"})
+		return
+	}
+	if callerID, err := middleware.GetUserID(c); err == nil && callerID == userID {
+		c.JSON(http.StatusConflict, gin.H{"error": "You cannot change your own role"})
+		return
+	}
+
+	res, err := h.db.Exec(`
+		UPDATE users SET role = $1, tokens_valid_after = date_trunc('second', NOW()), updated_at = NOW()
+		WHERE id = $2 AND role <> $1`,
+		req.Role, userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to update role")
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		logging.FromContext(c).Info("user role changed", "event", "role_changed", "target_user_id", userID, "role", req.Role)
+	} else {
+		var exists bool
+		err := h.db.Retry(func() error {
+			return h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists)
+		})
+		if err != nil {
+			respondDBError(c, err, "Failed to update role")
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": userID, "role": req.Role, "is_admin": req.Role == middleware.RoleAdmin})
+}
+
+// StreamEvents streams live server events to an admin as Server-Sent Events,
+// starting with the backlog of recent events.
+func (h *AdminHandler) StreamEvents(c *gin.Context) {
+	backlog, events, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	for _, event := range backlog {
+		c.SSEvent(event.Type, event)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", gin.H{"time": time.Now()})
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"file-sharing-backend/internal/database/dbtest"
+	"file-sharing-backend/internal/middleware"
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newShareEnv holds report.pdf as file 1, shared as testShareUUID until it
+// is trashed or deleted.
+func newShareEnv(t *testing.T) (*dbtest.Env, *dbtest.File) {
+	env := dbtest.NewEnv(t)
+	file := env.AddFile(t, "report.pdf", "quarterly numbers")
+	env.On(downloadableFileQuery...).Do(func([]driver.Value) (*dbtest.Result, error) {
+		if f, ok := env.Files[file.ID]; !ok || f.Trashed {
+			return nil, nil
+		}
+		return downloadableFile(true), nil
+	})
+	return env, file
+}
+
+// shareStatus returns the status a download of the share gets.
+func shareStatus(files *FileHandler) int {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/share/"+testShareUUID, nil)
+	if _, ok := files.loadDownloadableFile(c, testShareUUID); ok {
+		return http.StatusOK
+	}
+	return w.Code
+}
+
+func deleteFileAdmin(admin *AdminHandler, role, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/api/admin/files/1"+query, nil)
+	return serve(req, "/api/admin/files/:id", asRole(role), admin.DeleteFileAdmin)
+}
+
+// A plain delete trashes the file and takes the share down at once; only
+// admins can purge files right away.
+func TestDeleteFileAdmin(t *testing.T) {
+	tests := []struct {
+		name        string
+		role        string
+		query       string
+		wantStatus  int
+		wantRow     bool
+		wantTrashed bool
+		wantBlob    bool
+		wantShare   int
+	}{
+		{name: "admin purges", role: middleware.RoleAdmin, query: "?permanent=true",
+			wantStatus: http.StatusOK, wantShare: http.StatusNotFound},
+		{name: "admin trashes", role: middleware.RoleAdmin,
+			wantStatus: http.StatusOK, wantRow: true, wantTrashed: true, wantBlob: true, wantShare: http.StatusNotFound},
+		{name: "moderator trashes", role: middleware.RoleModerator,
+			wantStatus: http.StatusOK, wantRow: true, wantTrashed: true, wantBlob: true, wantShare: http.StatusNotFound},
+		{name: "moderator cannot purge", role: middleware.RoleModerator, query: "?permanent=true",
+			wantStatus: http.StatusForbidden, wantRow: true, wantBlob: true, wantShare: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, file := newShareEnv(t)
+			admin, files := newAdminHandler(env), newFileHandler(env)
+
+			if w := deleteFileAdmin(admin, tt.role, tt.query); w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			row, ok := env.Files[file.ID]
+			if ok != tt.wantRow || (ok && row.Trashed != tt.wantTrashed) {
+				t.Errorf("row kept %v, trashed %v; want %v, %v", ok, ok && row.Trashed, tt.wantRow, tt.wantTrashed)
+			}
+			if blob := env.HasBlob(file.Path); blob != tt.wantBlob {
+				t.Errorf("blob kept %v, want %v", blob, tt.wantBlob)
+			}
+			if status := shareStatus(files); status != tt.wantShare {
+				t.Errorf("share status %d, want %d", status, tt.wantShare)
+			}
+			if w := deleteFileAdmin(admin, middleware.RoleAdmin, "?permanent=true"); tt.wantRow == (w.Code == http.StatusNotFound) {
+				t.Errorf("purging afterwards: status %d", w.Code)
+			}
+		})
+	}
+}
+
+// The blob of a trashed file stays so it can be restored, and goes when the
+// trash is purged.
+func TestDeleteFileAdminTrashPurged(t *testing.T) {
+	t.Setenv("TRASH_RETENTION_DAYS", "0")
+	env, file := newShareEnv(t)
+
+	if w := deleteFileAdmin(newAdminHandler(env), middleware.RoleAdmin, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete status %d: %s", w.Code, w.Body)
+	}
+	cleanup := services.NewCleanupService(env.DB, services.NewEventBus(0), services.NewDownloadHistory(env.DB), services.NewJobRegistry(), env.Store, env.Logger)
+	if removed, err := cleanup.CleanupExpiredFiles(); err != nil || removed != 1 {
+		t.Fatalf("purging the trash = %d, %v; want 1 removed", removed, err)
+	}
+	if _, ok := env.Files[file.ID]; ok || env.HasBlob(file.Path) {
+		t.Error("blob or row of the trashed file survived the purge")
+	}
+}
+
+// failingStore fails deleting every blob, like a storage outage would.
+type failingStore struct {
+	storage.Storage
+}
+
+func (failingStore) Delete(string) error {
+	return errors.New("input/output error")
+}
+
+// A purge that cannot remove the blob or the image variants keeps the row,
+// so it can be retried instead of leaving the blobs orphaned; a blob that is
+// already gone does not stop it.
+func TestDeleteFileAdminPermanentBlobs(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(env *dbtest.Env, admin *AdminHandler)
+		wantStatus int
+		wantRow    bool
+	}{
+		{name: "blob cannot be deleted", setup: func(env *dbtest.Env, admin *AdminHandler) {
+			admin.storage = failingStore{env.Store}
+		}, wantStatus: http.StatusInternalServerError, wantRow: true},
+		{name: "variants cannot be listed", setup: func(env *dbtest.Env, admin *AdminHandler) {
+			env.On("SELECT file_path FROM file_variants WHERE file_id = $1").Fail(errors.New("server closed the connection unexpectedly"))
+		}, wantStatus: http.StatusInternalServerError, wantRow: true},
+		{name: "blob already gone", setup: func(env *dbtest.Env, admin *AdminHandler) {
+			env.Store.Delete(env.Files[1].Path)
+		}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, file := newShareEnv(t)
+			admin := newAdminHandler(env)
+			tt.setup(env, admin)
+
+			if w := deleteFileAdmin(admin, middleware.RoleAdmin, "?permanent=true"); w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if _, ok := env.Files[file.ID]; ok != tt.wantRow {
+				t.Errorf("row kept %v, want %v", ok, tt.wantRow)
+			}
+		})
+	}
+}
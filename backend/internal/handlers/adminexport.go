@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportBatchSize is how many rows are fetched from the export cursor at a
+// time, bounding memory use regardless of table size.
+const exportBatchSize = 1000
+
+const adminFilesExportQuery = `
+	SELECT f.id, f.uuid, f.original_name, f.file_size, f.mime_type,
+	       f.password_hash IS NOT NULL AS has_password, f.download_count,
+	       f.expires_at, f.created_at, u.email AS user_email
+	FROM files f
+	JOIN users u ON f.user_id = u.id
+	ORDER BY f.id`
+
+const adminUsersExportQuery = `
+	SELECT u.id, u.email, u.role, u.is_admin, u.created_at,
+	       (SELECT COUNT(*) FROM files WHERE user_id = u.id) AS file_count
+	FROM users u
+	ORDER BY u.id`
+
+// ExportFiles streams every file as CSV or, with format=ndjson, one JSON
+// object per line.
+func (h *AdminHandler) ExportFiles(c *gin.Context) {
+	h.streamExport(c, "files", adminFilesExportQuery)
+}
+
+// ExportUsers streams every user with their file count, like ExportFiles.
+func (h *AdminHandler) ExportUsers(c *gin.Context) {
+	h.streamExport(c, "users", adminUsersExportQuery)
+}
+
+// streamExport runs query through a server-side cursor and writes each batch
+// to the response as soon as it is fetched, so only one batch is ever held
+// in memory. Column names come from the query.
+func (h *AdminHandler) streamExport(c *gin.Context, name, query string) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+
+	// The cursor lives in a read-only transaction that is rolled back when
+	// the export ends or the client goes away
+	tx, err := h.db.BeginTx(c.Request.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		respondDBError(c, err, "Failed to start export")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DECLARE export_cursor NO SCROLL CURSOR FOR " + query); err != nil {
+		respondDBError(c, err, "Failed to start export")
+		return
+	}
+
+	rows, err := tx.Query("FETCH FORWARD " + strconv.Itoa(exportBatchSize) + " FROM export_cursor")
+	if err != nil {
+		respondDBError(c, err, "Failed to start export")
+		return
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		respondDBError(c, err, "Failed to start export")
+		return
+	}
+
+	// From here on the status is sent; failures can only cut the export short
+	filename := name + "-" + time.Now().UTC().Format("20060102") + "." + format
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	csvWriter := csv.NewWriter(c.Writer)
+	jsonEncoder := json.NewEncoder(c.Writer)
+	if format == "csv" {
+		csvWriter.Write(columns)
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	object := make(map[string]interface{}, len(columns))
+
+	for {
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			if err = rows.Scan(pointers...); err != nil {
+				break
+			}
+			if format == "csv" {
+				for i, v := range values {
+					record[i] = exportCSVValue(v)
+				}
+				err = csvWriter.Write(record)
+			} else {
+				for i, column := range columns {
+					object[column] = values[i]
+				}
+				err = jsonEncoder.Encode(object)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		csvWriter.Flush()
+		if err == nil {
+			err = csvWriter.Error()
+		}
+		if err != nil || fetched < exportBatchSize {
+			break
+		}
+		c.Writer.Flush()
+
+		rows, err = tx.Query("FETCH FORWARD " + strconv.Itoa(exportBatchSize) + " FROM export_cursor")
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		logging.FromContext(c).Error("writing export failed", "event", "export_failed", "export", name, "error", err)
+	}
+}
+
+// exportCSVValue renders a value scanned from the database as a CSV field.
+func exportCSVValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
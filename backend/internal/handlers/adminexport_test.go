@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+
+	"github.com/gin-gonic/gin"
+)
+
+var exportFileColumns = []string{
+	"id", "uuid", "original_name", "file_size", "mime_type", "has_password",
+	"download_count", "expires_at", "created_at", "user_email",
+}
+
+// exportRun is what an export of total rows did with the cursor.
+type exportRun struct {
+	w        *httptest.ResponseRecorder
+	declared bool
+	fetches  int
+	// writtenAtFetch is the number of lines in the response when each
+	// fetch began
+	writtenAtFetch []int
+	// largestBatch is the most rows a single fetch returned
+	largestBatch int
+}
+
+func runExport(t *testing.T, total int, format string) (*exportRun, *httptest.ResponseRecorder) {
+	t.Helper()
+	run := &exportRun{w: httptest.NewRecorder()}
+	env := dbtest.NewEnv(t)
+	env.On("DECLARE export_cursor NO SCROLL CURSOR FOR", "FROM files f").Do(func([]driver.Value) (*dbtest.Result, error) {
+		run.declared = true
+		return nil, nil
+	})
+	served := 0
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	env.On("FETCH FORWARD", "FROM export_cursor").Do(func([]driver.Value) (*dbtest.Result, error) {
+		if !run.declared {
+			return nil, fmt.Errorf("cursor export_cursor does not exist")
+		}
+		run.fetches++
+		run.writtenAtFetch = append(run.writtenAtFetch, bytes.Count(run.w.Body.Bytes(), []byte("\n")))
+		batch := 0
+		return &dbtest.Result{
+			Columns: exportFileColumns,
+			Next: func() ([]driver.Value, error) {
+				if batch == exportBatchSize || served == total {
+					return nil, io.EOF
+				}
+				batch++
+				served++
+				run.largestBatch = max(run.largestBatch, batch)
+				id := int64(served)
+				return []driver.Value{
+					id, fmt.Sprintf("uuid-%d", id), fmt.Sprintf("file %d.txt", id), id * 100, "text/plain", id%2 == 0,
+					int64(0), created.Add(time.Hour), created, "owner@example.com",
+				}, nil
+			},
+		}, nil
+	})
+
+	r := gin.New()
+	r.GET("/api/admin/export/files", newAdminHandler(env).ExportFiles)
+	r.ServeHTTP(run.w, httptest.NewRequest(http.MethodGet, "/api/admin/export/files?format="+format, nil))
+	return run, run.w
+}
+
+// A large export is written batch by batch as the cursor is read, so the
+// server holds at most one batch of rows however many there are.
+func TestExportFilesStreamsLargeTables(t *testing.T) {
+	for _, format := range []string{"csv", "ndjson"} {
+		t.Run(format, func(t *testing.T) {
+			const total = 25*exportBatchSize + 500
+			run, w := runExport(t, total, format)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+			}
+			header := 0
+			if format == "csv" {
+				header = 1
+			}
+			if lines := bytes.Count(w.Body.Bytes(), []byte("\n")); lines != total+header {
+				t.Errorf("export has %d lines, want %d", lines, total+header)
+			}
+			if run.largestBatch > exportBatchSize {
+				t.Errorf("a fetch returned %d rows, more than the batch size %d", run.largestBatch, exportBatchSize)
+			}
+			if want := total/exportBatchSize + 1; run.fetches != want {
+				t.Errorf("%d fetches, want %d", run.fetches, want)
+			}
+			// Before each fetch, every row fetched so far has been written out
+			for i, written := range run.writtenAtFetch {
+				if i > 0 && written < i*exportBatchSize+header {
+					t.Fatalf("fetch %d began with %d lines written, want all %d rows of the earlier fetches", i+1, written, i*exportBatchSize)
+				}
+			}
+		})
+	}
+}
+
+func TestExportFilesEmptyTable(t *testing.T) {
+	run, w := runExport(t, 0, "csv")
+	if w.Code != http.StatusOK || run.fetches != 1 {
+		t.Fatalf("status %d after %d fetches, want 200 after 1", w.Code, run.fetches)
+	}
+	want := "id,uuid,original_name,file_size,mime_type,has_password,download_count,expires_at,created_at,user_email\n"
+	if w.Body.String() != want {
+		t.Errorf("body %q, want just the header %q", w.Body, want)
+	}
+}
+
+func TestExportRejectsUnknownFormat(t *testing.T) {
+	run, w := runExport(t, 10, "xml")
+	if w.Code != http.StatusBadRequest || run.declared {
+		t.Errorf("status %d, cursor declared %v; want 400 without touching the database", w.Code, run.declared)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxAlbumTitleLength = 200
+
+type albumEntry struct {
+	UUID         string    `json:"uuid"`
+	ShareURL     string    `json:"share_url"`
+	OriginalName string    `json:"original_name"`
+	FileSize     int64     `json:"file_size"`
+	MimeType     string    `json:"mime_type"`
+	HasPassword  bool      `json:"has_password"`
+	IsEncrypted  bool      `json:"is_encrypted"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// createAlbum stores an album for the files of an upload and returns its ID
+// and UUID.
+func (h *FileHandler) createAlbum(userID int, title string) (int, string, error) {
+	var titleArg *string
+	if title != "" {
+		titleArg = &title
+	}
+	albumUUID := uuid.New().String()
+	var albumID int
+	err := h.db.QueryRow(
+		"INSERT INTO albums (uuid, user_id, title) VALUES ($1, $2, $3) RETURNING id",
+		albumUUID, userID, titleArg,
+	).Scan(&albumID)
+	return albumID, albumUUID, err
+}
+
+func validAlbumTitle(title string) bool {
+	return utf8.RuneCountInString(title) <= maxAlbumTitleLength
+}
+
+// GetAlbum publicly lists the unexpired files of an album, leaving out those
+// of unverified owners when their downloads are blocked. Like folders, an
+// album whose files have all expired answers 410.
+func (h *FileHandler) GetAlbum(c *gin.Context) {
+	albumUUID := c.Param("uuid")
+
+	var albumID int
+	var title *string
+	var createdAt time.Time
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT id, title, created_at FROM albums WHERE uuid = $1", albumUUID).
+			Scan(&albumID, &title, &createdAt)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+
+	rows, err := h.db.QueryRetry(`
+		SELECT uuid, original_name, file_size, mime_type, password_hash IS NOT NULL, is_encrypted, expires_at
+		FROM files
+		WHERE album_id = $1 AND expires_at > NOW() AND deleted_at IS NULL AND disabled_at IS NULL
+		  AND (NOT $2 OR COALESCE((SELECT email_verified FROM users WHERE id = files.user_id), TRUE))
+		ORDER BY upload_index, id`,
+		albumID, h.blockUnverifiedOwners,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch album")
+		return
+	}
+	defer rows.Close()
+
+	entries := []albumEntry{}
+	for rows.Next() {
+		var entry albumEntry
+		if err := rows.Scan(&entry.UUID, &entry.OriginalName, &entry.FileSize, &entry.MimeType,
+			&entry.HasPassword, &entry.IsEncrypted, &entry.ExpiresAt); err != nil {
+			continue
+		}
+		entry.ShareURL = middleware.ExternalURL(c, "/share/"+entry.UUID)
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "Album has expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"album_uuid": albumUUID,
+		"title":      title,
+		"created_at": createdAt,
+		"files":      entries,
+	})
+}
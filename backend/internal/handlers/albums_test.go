@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+)
+
+// albumFile is a file of the album in newAlbumEnv.
+type albumFile struct {
+	name          string
+	ownerVerified bool
+	oneTime       bool
+}
+
+// newAlbumEnv holds an album of files, answering the album listing and the
+// archive query by leaving out the files of unverified owners when the
+// query is asked to. The archive query leaves out one-time files as well.
+func newAlbumEnv(t *testing.T, files ...albumFile) *dbtest.Env {
+	env := dbtest.NewEnv(t)
+	env.On("SELECT id, title, created_at FROM albums WHERE uuid = $1").Return(dbtest.Row(int64(1), nil, time.Now()))
+
+	var listed, archived [][]driver.Value
+	for _, f := range files {
+		row := env.AddFile(t, f.name, "contents of "+f.name)
+		listed = append(listed, []driver.Value{row.UUID, f.name, int64(len(f.name)), "text/plain", false, false, time.Now().Add(time.Hour)})
+		archived = append(archived, []driver.Value{row.ID, f.name, row.Path, "text/plain"})
+	}
+	visible := func(rows [][]driver.Value, block driver.Value, archive bool) [][]driver.Value {
+		var kept [][]driver.Value
+		for i, row := range rows {
+			if (block != true || files[i].ownerVerified) && !(archive && files[i].oneTime) {
+				kept = append(kept, row)
+			}
+		}
+		return kept
+	}
+	env.On("FROM files WHERE album_id = $1", "COALESCE((SELECT email_verified FROM users WHERE id = files.user_id), TRUE)").
+		Do(func(args []driver.Value) (*dbtest.Result, error) {
+			return dbtest.Rows(visible(listed, args[1], false)...), nil
+		})
+	env.On("JOIN albums a ON a.id = f.album_id", "AND NOT f.one_time", "COALESCE((SELECT email_verified FROM users WHERE id = f.user_id), TRUE)").
+		Do(func(args []driver.Value) (*dbtest.Result, error) {
+			return dbtest.Rows(visible(archived, args[1], true)...), nil
+		})
+	return env
+}
+
+// albumListing returns the names GetAlbum lists.
+func albumListing(t *testing.T, h *FileHandler) []string {
+	t.Helper()
+	w := serve(httptest.NewRequest(http.MethodGet, "/album/album-uuid", nil), "/album/:uuid", h.GetAlbum)
+	if w.Code != http.StatusOK {
+		return nil
+	}
+	var body struct {
+		Files []struct {
+			OriginalName string `json:"original_name"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range body.Files {
+		names = append(names, f.OriginalName)
+	}
+	return names
+}
+
+// albumArchive returns the names in the ZIP DownloadAlbum sends.
+func albumArchive(t *testing.T, h *FileHandler) []string {
+	t.Helper()
+	w := serve(httptest.NewRequest(http.MethodGet, "/album/album-uuid/zip", nil), "/album/:uuid/zip", h.DownloadAlbum)
+	if w.Code != http.StatusOK {
+		return nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalNames(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Albums hide the files of unverified owners like their shares do.
+func TestAlbumUnverifiedOwners(t *testing.T) {
+	tests := []struct {
+		name  string
+		block bool
+		want  []string
+	}{
+		{name: "allowed", want: []string{"unverified.txt", "verified.txt"}},
+		{name: "blocked", block: true, want: []string{"verified.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.block {
+				t.Setenv("UNVERIFIED_OWNER_DOWNLOADS", "block")
+			}
+			env := newAlbumEnv(t,
+				albumFile{name: "unverified.txt"},
+				albumFile{name: "verified.txt", ownerVerified: true},
+			)
+			h := newFileHandler(env)
+
+			listed := albumListing(t, h)
+			sort.Strings(listed)
+			if !equalNames(listed, tt.want...) {
+				t.Errorf("album lists %v, want %v", listed, tt.want)
+			}
+			if archived := albumArchive(t, h); !equalNames(archived, tt.want...) {
+				t.Errorf("archive holds %v, want %v", archived, tt.want)
+			}
+		})
+	}
+}
+
+// One-time files are listed, but only their own download may burn them, so
+// the archive leaves them out.
+func TestAlbumArchiveSkipsOneTimeFiles(t *testing.T) {
+	env := newAlbumEnv(t,
+		albumFile{name: "once.txt", ownerVerified: true, oneTime: true},
+		albumFile{name: "report.txt", ownerVerified: true},
+	)
+	h := newFileHandler(env)
+
+	listed := albumListing(t, h)
+	sort.Strings(listed)
+	if !equalNames(listed, "once.txt", "report.txt") {
+		t.Errorf("album lists %v, want both files", listed)
+	}
+	if archived := albumArchive(t, h); !equalNames(archived, "report.txt") {
+		t.Errorf("archive holds %v, want only report.txt", archived)
+	}
+	if len(env.Files) != 2 {
+		t.Errorf("%d files left after the archive download, want 2", len(env.Files))
+	}
+}
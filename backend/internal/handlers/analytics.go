@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dayLayout         = "2006-01-02"
+	defaultSeriesDays = 30
+	maxSeriesDays     = 366
+)
+
+// dayCount is one point of a daily time series.
+type dayCount struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// parseDayRange reads the from/to query parameters (YYYY-MM-DD, inclusive),
+// defaulting to the last 30 days. On invalid input the response has been
+// written and ok is false.
+func parseDayRange(c *gin.Context) (from, to time.Time, ok bool) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to = today
+	from = today.AddDate(0, 0, -(defaultSeriesDays - 1))
+
+	var err error
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(dayLayout, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, use YYYY-MM-DD"})
+			return from, to, false
+		}
+		if c.Query("from") == "" {
+			from = to.AddDate(0, 0, -(defaultSeriesDays - 1))
+		}
+	}
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(dayLayout, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, use YYYY-MM-DD"})
+			return from, to, false
+		}
+	}
+
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return from, to, false
+	}
+	if to.Sub(from) >= maxSeriesDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Date range must not exceed %d days", maxSeriesDays)})
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// dailyDownloads counts downloads per day between from and to inclusive,
+// including zero-count days so the series is continuous. Days whose download
+// rows were pruned are counted from download_rollups. filter is an extra
+// join condition on the alias "dl" (with a file_id column) using
+// placeholders from $3 on.
+func dailyDownloads(db *database.DB, from, to time.Time, filter string, args ...interface{}) ([]dayCount, error) {
+	query := `
+		SELECT TO_CHAR(d.day, 'YYYY-MM-DD'), COALESCE(SUM(dl.n), 0)
+		FROM generate_series($1::date, $2::date, INTERVAL '1 day') AS d(day)
+		LEFT JOIN (
+			SELECT downloaded_at::date AS day, file_id, 1 AS n
+			FROM downloads
+			WHERE downloaded_at >= $1::date AND downloaded_at < $2::date + 1
+			UNION ALL
+			SELECT day, file_id, count
+			FROM download_rollups
+			WHERE day BETWEEN $1::date AND $2::date
+		) dl ON dl.day = d.day`
+	if filter != "" {
+		query += " AND " + filter
+	}
+	query += `
+		GROUP BY d.day
+		ORDER BY d.day`
+
+	rows, err := db.QueryRetry(query, append([]interface{}{from.Format(dayLayout), to.Format(dayLayout)}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []dayCount{}
+	for rows.Next() {
+		var point dayCount
+		if err := rows.Scan(&point.Day, &point.Count); err != nil {
+			return nil, err
+		}
+		series = append(series, point)
+	}
+	return series, rows.Err()
+}
+
+// GetFileStats returns view and download totals of an owned file, showing
+// how many people saw the share versus downloaded it.
+func (h *FileHandler) GetFileStats(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	var viewCount, downloadCount, uniqueDownloaders int
+	var lastDownloadedAt *time.Time
+	reader := h.db.Reader()
+	err := reader.Retry(func() error {
+		return reader.QueryRow(`
+			SELECT f.view_count, f.download_count,
+			       COUNT(DISTINCT dl.ip_address), MAX(dl.downloaded_at)
+			FROM files f
+			LEFT JOIN downloads dl ON dl.file_id = f.id
+			WHERE f.id = $1
+			GROUP BY f.id`,
+			fileID,
+		).Scan(&viewCount, &downloadCount, &uniqueDownloaders, &lastDownloadedAt)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch file statistics")
+		return
+	}
+
+	stats := gin.H{
+		"view_count":         viewCount,
+		"download_count":     downloadCount,
+		"unique_downloaders": uniqueDownloaders,
+		"last_downloaded_at": lastDownloadedAt,
+	}
+	if viewCount > 0 {
+		stats["download_rate"] = float64(downloadCount) / float64(viewCount)
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetDailyDownloads returns the caller's downloads per day, across all of
+// their files or for a single owned file via ?uuid=.
+func (h *FileHandler) GetDailyDownloads(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	from, to, ok := parseDayRange(c)
+	if !ok {
+		return
+	}
+
+	filter := "dl.file_id IN (SELECT id FROM files WHERE user_id = $3)"
+	args := []interface{}{userID}
+	if fileUUID := c.Query("uuid"); fileUUID != "" {
+		var ownerID int
+		err := h.db.QueryRow("SELECT user_id FROM files WHERE uuid = $1", fileUUID).Scan(&ownerID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		if err != nil {
+			respondDBError(c, err, "Database error")
+			return
+		}
+		if ownerID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+		filter = "dl.file_id = (SELECT id FROM files WHERE uuid = $3)"
+		args = []interface{}{fileUUID}
+	}
+
+	series, err := dailyDownloads(h.db.Reader(), from, to, filter, args...)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch download statistics")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from.Format(dayLayout),
+		"to":     to.Format(dayLayout),
+		"series": series,
+	})
+}
+
+// GetDailyDownloads returns downloads per day across the whole system, or
+// for a single file via ?file_id=.
+func (h *AdminHandler) GetDailyDownloads(c *gin.Context) {
+	from, to, ok := parseDayRange(c)
+	if !ok {
+		return
+	}
+
+	var filter string
+	var args []interface{}
+	if fileID := c.Query("file_id"); fileID != "" {
+		filter = "dl.file_id = $3"
+		args = append(args, fileID)
+	}
+
+	series, err := dailyDownloads(h.db.Reader(), from, to, filter, args...)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch download statistics")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from.Format(dayLayout),
+		"to":     to.Format(dayLayout),
+		"series": series,
+	})
+}
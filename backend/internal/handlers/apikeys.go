@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyPrefix      = "fsk_"
+	maxAPIKeysPerUser = 25
+	maxAPIKeyName     = 100
+)
+
+// apiKey is an API key as listed to its owner. Only the first characters
+// of the key are kept in the clear, enough to tell keys apart.
+type apiKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ResolveAPIKey is the middleware.APIKeyResolver of the auth middleware.
+// Use is recorded at most once a minute per key to keep requests read-only.
+func (h *AuthHandler) ResolveAPIKey(key string) (userID int, role string, ok bool, err error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return 0, "", false, nil
+	}
+	var keyID int
+	var recent bool
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT k.id, k.user_id, u.role, COALESCE(k.last_used_at > NOW() - INTERVAL '1 minute', FALSE)
+			FROM api_keys k
+			JOIN users u ON u.id = k.user_id
+			WHERE k.key_hash = $1`,
+			hashToken(key),
+		).Scan(&keyID, &userID, &role, &recent)
+	})
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	if !recent {
+		// Best effort; the key is valid either way
+		h.db.Exec("UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", keyID)
+	}
+	return userID, role, true, nil
+}
+
+// CreateAPIKey generates a long-lived API key for programmatic access,
+// sent as an X-API-Key header instead of a bearer token. The key is only
+// ever shown in this response; the server keeps a hash of it.
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxAPIKeyName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be between 1 and " + strconv.Itoa(maxAPIKeyName) + " characters"})
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+
+	// The count check is part of the insert so concurrent requests cannot
+	// exceed the cap
+	created := apiKey{Name: name, Prefix: key[:len(apiKeyPrefix)+8]}
+	err = h.db.QueryRow(`
+		INSERT INTO api_keys (user_id, name, prefix, key_hash)
+		SELECT $1, $2, $3, $4
+		WHERE (SELECT COUNT(*) FROM api_keys WHERE user_id = $1) < $5
+		RETURNING id, created_at`,
+		userID, created.Name, created.Prefix, hashToken(key), maxAPIKeysPerUser,
+	).Scan(&created.ID, &created.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key limit reached; revoke an unused key first", "max_api_keys": maxAPIKeysPerUser})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to create API key")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"api_key": created, "key": key})
+}
+
+// GetAPIKeys lists the caller's API keys without the keys themselves.
+func (h *AuthHandler) GetAPIKeys(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	rows, err := h.db.QueryRetry(
+		"SELECT id, name, prefix, created_at, last_used_at FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id DESC",
+		userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch API keys")
+		return
+	}
+	defer rows.Close()
+
+	keys := []apiKey{}
+	for rows.Next() {
+		var k apiKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// DeleteAPIKey revokes one of the caller's API keys immediately.
+func (h *AuthHandler) DeleteAPIKey(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	res, err := h.db.Exec("DELETE FROM api_keys WHERE id = $1 AND user_id = $2", keyID, userID)
+	if err != nil {
+		respondDBError(c, err, "Failed to revoke API key")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
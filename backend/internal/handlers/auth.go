@@ -1,13 +1,19 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
 	"os"
-	"time"
 	"strings"
+	"time"
 
 	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/metrics"
+	"file-sharing-backend/internal/middleware"
 	"file-sharing-backend/internal/models"
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
@@ -15,11 +21,27 @@ import (
 )
 
 type AuthHandler struct {
-	db *database.DB
+	db             *database.DB
+	history        *services.DownloadHistory
+	mailer         services.Mailer
+	settings       *services.SettingsService
+	storage        storage.Storage
+	maxActiveFiles int
+	tokens         tokenLifetimes
+	lockout        loginLockout
+	verification   emailVerification
+	resetTTL       time.Duration
 }
 
-func NewAuthHandler(db *database.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+func NewAuthHandler(db *database.DB, history *services.DownloadHistory, mailer services.Mailer, settings *services.SettingsService, store storage.Storage) *AuthHandler {
+	return &AuthHandler{db: db, history: history, mailer: mailer, settings: settings, storage: store, maxActiveFiles: loadMaxActiveFiles(), tokens: loadTokenLifetimes(), lockout: loadLoginLockout(), verification: loadEmailVerification(), resetTTL: loadPasswordResetTTL()}
+}
+
+// normalizeEmail is applied to every email before it is stored or looked
+// up. Lookups compare against LOWER(email) so accounts created before
+// normalization keep working.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -28,10 +50,22 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	req.Email = normalizeEmail(req.Email)
+
+	// Enforce the password policy
+	policy, err := h.settings.PasswordPolicy()
+	if err != nil {
+		respondDBError(c, err, "Failed to load password policy")
+		return
+	}
+	if err := policy.Validate(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Check if user already exists
 	var existingID int
-	err := h.db.QueryRow("SELECT id FROM users WHERE email = $1", req.Email).Scan(&existingID)
+	err = h.db.QueryRow("SELECT id FROM users WHERE LOWER(email) = $1", req.Email).Scan(&existingID)
 	if err == nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
 		return
@@ -55,22 +89,82 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := h.generateToken(userID, false)
+	role := middleware.RoleUser
+	isAdmin, err := h.bootstrapAdmin(userID, req.Email)
+	if isAdmin {
+		role = middleware.RoleAdmin
+		logging.FromContext(c).Warn("no admin existed, so the newly registered user was made admin",
+			"event", "admin_bootstrapped", "user_id", userID, "email", req.Email)
+	}
+	if err != nil {
+		logging.FromContext(c).Error("checking admin bootstrap failed", "event", "admin_bootstrap_failed", "user_id", userID, "error", err)
+	}
+
+	// The account works right away; a failed email can be resent
+	emailSent := true
+	if err := h.sendVerificationEmail(c, userID, req.Email); err != nil {
+		logging.FromContext(c).Error("sending verification email failed", "event", "email_failed", "user_id", userID, "error", err)
+		emailSent = false
+	}
+
+	// Generate access and refresh tokens
+	token, refreshToken, err := h.issueTokens(userID, role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	c.JSON(http.StatusCreated, h.tokenResponse(gin.H{
 		"message": "User created successfully",
-		"token":   token,
 		"user": gin.H{
-			"id":       userID,
-			"email":    req.Email,
-			"is_admin": false,
+			"id":             userID,
+			"email":          req.Email,
+			"role":           role,
+			"is_admin":       isAdmin,
+			"email_verified": false,
 		},
-	})
+		"verification_email_sent": emailSent,
+	}, token, refreshToken))
+}
+
+// adminBootstrapLock serializes admin bootstrapping across instances so
+// concurrent registrations cannot both become the first admin.
+const adminBootstrapLock = 7226001
+
+// bootstrapAdmin promotes a newly registered user to admin while the
+// instance has no admin at all, if ADMIN_BOOTSTRAP=first_user or the email
+// matches ADMIN_EMAIL. It reports whether the user was promoted.
+func (h *AuthHandler) bootstrapAdmin(userID int, email string) (bool, error) {
+	adminEmail := normalizeEmail(os.Getenv("ADMIN_EMAIL"))
+	if os.Getenv("ADMIN_BOOTSTRAP") != "first_user" && (adminEmail == "" || adminEmail != email) {
+		return false, nil
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", adminBootstrapLock); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(`
+		UPDATE users SET role = 'admin', updated_at = NOW()
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')`,
+		userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, nil
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -80,49 +174,135 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	err := h.db.QueryRow(
-		"SELECT id, email, password_hash, is_admin FROM users WHERE email = $1",
-		req.Email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.IsAdmin)
-	
+	email, clientIP := normalizeEmail(req.Email), c.ClientIP()
+
+	// Refuse repeated failures before looking at the account at all
+	retryAfter, err := h.loginRetryAfter(email, clientIP)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		respondDBError(c, err, "Failed to log in")
+		return
+	}
+	if retryAfter > 0 {
+		metrics.FailedLogins.Inc()
+		respondLockedOut(c, retryAfter)
 		return
 	}
 
-	// Check password
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+	var user models.User
+	err = h.db.QueryRow(
+		"SELECT id, email, password_hash, role, email_verified FROM users WHERE LOWER(email) = $1",
+		email,
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Role, &user.EmailVerified)
+	
+	if err == nil {
+		// Check password
+		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+	}
 	if err != nil {
+		metrics.FailedLogins.Inc()
+		if err := h.recordLoginFailure(email, clientIP); err != nil {
+			logging.FromContext(c).Warn("recording failed login failed", "event", "login_failure_record_failed", "error", err)
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if err := h.clearLoginFailures(email, clientIP); err != nil {
+		logging.FromContext(c).Warn("clearing failed logins failed", "event", "login_failure_clear_failed", "error", err)
+	}
 
-	// Generate JWT token
-	token, err := h.generateToken(user.ID, user.IsAdmin)
+	// Generate access and refresh tokens
+	token, refreshToken, err := h.issueTokens(user.ID, user.Role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, h.tokenResponse(gin.H{
 		"message": "Login successful",
-		"token":   token,
 		"user": gin.H{
-			"id":       user.ID,
-			"email":    user.Email,
-			"is_admin": user.IsAdmin,
+			"id":             user.ID,
+			"email":          user.Email,
+			"role":           user.Role,
+			"is_admin":       user.Role == middleware.RoleAdmin,
+			"email_verified": user.EmailVerified,
 		},
+	}, token, refreshToken))
+}
+
+// CheckEmailAvailable reports whether an email can still be used to
+// register. The route is rate limited to slow down account enumeration.
+func (h *AuthHandler) CheckEmailAvailable(c *gin.Context) {
+	var req struct {
+		Email string `form:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		return
+	}
+	email := normalizeEmail(req.Email)
+
+	var existingID int
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT id FROM users WHERE LOWER(email) = $1", email).Scan(&existingID)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		respondDBError(c, err, "Failed to check email")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"email": email, "available": err == sql.ErrNoRows})
+}
+
+// GetProfile returns the authenticated user's account and how many of
+// their allowed active files are in use (max_active_files 0 is unlimited).
+func (h *AuthHandler) GetProfile(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT id, email, role, email_verified, created_at FROM users WHERE id = $1", userID).
+			Scan(&user.ID, &user.Email, &user.Role, &user.EmailVerified, &user.CreatedAt)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch profile")
+		return
+	}
+
+	activeFiles, maxActiveFiles, err := activeFileUsage(h.db, userID, h.maxActiveFiles)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch profile")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":               user.ID,
+		"email":            user.Email,
+		"role":             user.Role,
+		"is_admin":         user.Role == middleware.RoleAdmin,
+		"email_verified":   user.EmailVerified,
+		"created_at":       user.CreatedAt,
+		"active_files":     activeFiles,
+		"max_active_files": maxActiveFiles,
 	})
 }
 
-func (h *AuthHandler) generateToken(userID int, isAdmin bool) (string, error) {
+func (h *AuthHandler) generateToken(userID int, role string) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id":  userID,
-		"is_admin": isAdmin,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"role":     role,
+		"is_admin": role == middleware.RoleAdmin,
+		"iat":      time.Now().Unix(),
+		"exp":      time.Now().Add(h.tokens.access).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return token.SignedString(middleware.JWTSecret())
 }
\ No newline at end of file
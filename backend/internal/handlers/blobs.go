@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPresignExpiry = 15 * time.Minute
+	// maxPresignExpiry is the longest S3 accepts
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// loadPresignExpiry returns how long presigned download URLs stay valid when
+// S3_PRESIGN_DOWNLOADS is true (S3_PRESIGN_EXPIRY, default 15 minutes), or
+// zero when downloads are streamed through the server.
+func loadPresignExpiry() time.Duration {
+	if os.Getenv("S3_PRESIGN_DOWNLOADS") != "true" {
+		return 0
+	}
+	expiry, err := time.ParseDuration(os.Getenv("S3_PRESIGN_EXPIRY"))
+	if err != nil || expiry <= 0 {
+		return defaultPresignExpiry
+	}
+	return expiry
+}
+
+// serveBlob streams a blob with range and conditional request handling, at
+// no more than rate bytes per second unless rate is zero.
+func (h *FileHandler) serveBlob(c *gin.Context, path string, rate int64) {
+	blob, err := h.storage.Stat(path)
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	f, err := h.storage.Open(path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer f.Close()
+
+	var content io.ReadSeeker = f
+	if rate > 0 {
+		logging.FromContext(c).Debug("serving throttled download", "event", "download_throttled", "path", path, "rate", rate)
+		content = &throttledReader{ReadSeeker: f, rate: rate}
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(path), blob.ModifiedAt, content)
+}
+
+// redirectToBlob sends the client to a presigned URL of the blob, keeping
+// the Content-Disposition and Content-Type already set, and reports whether
+// it did. It does nothing unless the backend supports presigning and either
+// presigned downloads are enabled or the request came through a signed link,
+// whose URL then expires with the link. If signing fails the caller streams
+// the blob instead.
+func (h *FileHandler) redirectToBlob(c *gin.Context, path string) bool {
+	presigner, ok := h.storage.(storage.Presigner)
+	if !ok {
+		return false
+	}
+	expiry := h.presignExpiry
+	if expires, ok := c.Get(signedLinkExpiresKey); ok {
+		expiry = min(time.Until(expires.(time.Time)), maxPresignExpiry)
+	}
+	if expiry <= 0 {
+		return false
+	}
+
+	header := c.Writer.Header()
+	url, err := presigner.PresignGet(path, expiry, header.Get("Content-Disposition"), header.Get("Content-Type"))
+	if err != nil {
+		logging.FromContext(c).Warn("presigning download failed", "event", "presign_failed", "path", path, "error", err)
+		return false
+	}
+	for _, name := range []string{"Content-Disposition", "Content-Type", "Content-Length"} {
+		header.Del(name)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, url)
+	return true
+}
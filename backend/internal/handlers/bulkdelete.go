@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"file-sharing-backend/internal/middleware"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const maxBulkDeleteFiles = 500
+
+type bulkDeleteRequest struct {
+	UUIDs []string `json:"uuids" binding:"required"`
+}
+
+// DeleteFiles moves many of the caller's files to the trash at once and
+// reports the outcome per file: deleted, not_found or forbidden. Files
+// already in the trash are not_found, as with a single delete.
+func (h *FileHandler) DeleteFiles(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req bulkDeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.UUIDs) == 0 || len(req.UUIDs) > maxBulkDeleteFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("uuids must list between 1 and %d files", maxBulkDeleteFiles)})
+		return
+	}
+
+	owned := make(map[string]int)
+	foreign := make(map[string]bool)
+	err = h.db.Retry(func() error {
+		rows, err := h.db.Query("SELECT uuid, id, user_id FROM files WHERE uuid = ANY($1) AND deleted_at IS NULL", pq.Array(req.UUIDs))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var fileUUID string
+			var fileID, ownerID int
+			if err := rows.Scan(&fileUUID, &fileID, &ownerID); err != nil {
+				return err
+			}
+			if ownerID == userID {
+				owned[fileUUID] = fileID
+			} else {
+				foreign[fileUUID] = true
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to delete files")
+		return
+	}
+
+	results := make([]bulkFileResult, 0, len(req.UUIDs))
+	var ids []int
+	queued := make(map[string]bool)
+	for _, fileUUID := range req.UUIDs {
+		status := "not_found"
+		if fileID, ok := owned[fileUUID]; ok {
+			status = "deleted"
+			if !queued[fileUUID] {
+				queued[fileUUID] = true
+				ids = append(ids, fileID)
+			}
+		} else if foreign[fileUUID] {
+			status = "forbidden"
+		}
+		results = append(results, bulkFileResult{UUID: fileUUID, Status: status})
+	}
+
+	if len(ids) > 0 {
+		if _, err := services.TrashFiles(h.db, ids); err != nil {
+			respondDBError(c, err, "Failed to delete files")
+			return
+		}
+
+		h.events.Publish("delete", "Files deleted by owner", map[string]interface{}{
+			"count":   len(ids),
+			"user_id": userID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"archive/zip"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/metrics"
+	"file-sharing-backend/internal/middleware"
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// compressedTypes are formats that are already compressed, so deflating
+// them again costs CPU for no gain. Prefixes end in "/".
+var compressedTypes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif", "image/heic",
+	"video/", "audio/mpeg", "audio/aac", "audio/ogg", "audio/mp4", "audio/webm", "audio/flac",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+	"application/x-rar-compressed", "application/vnd.rar", "application/x-bzip2", "application/x-xz",
+	"application/zstd", "application/pdf", "application/epub+zip",
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+}
+
+// zipCompression is how a ZIP bundle compresses its entries: "store",
+// "deflate" or "auto", which picks per entry by MIME type.
+type zipCompression string
+
+// parseZipCompression reads the compression query parameter, answering the
+// request itself if it is invalid.
+func parseZipCompression(c *gin.Context) (zipCompression, bool) {
+	switch value := c.DefaultQuery("compression", "auto"); value {
+	case "auto", "store", "deflate":
+		return zipCompression(value), true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "compression must be auto, store or deflate"})
+		return "", false
+	}
+}
+
+// method returns the ZIP method for an entry of the given MIME type.
+func (z zipCompression) method(mimeType string) uint16 {
+	switch z {
+	case "store":
+		return zip.Store
+	case "deflate":
+		return zip.Deflate
+	}
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	for _, t := range compressedTypes {
+		prefix := strings.HasSuffix(t, "/") || strings.HasSuffix(t, ".")
+		if mediaType == t || (prefix && strings.HasPrefix(mediaType, t)) {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
+
+// uniqueEntryNames hands out archive entry names, numbering repeats as
+// "name (2).ext".
+type uniqueEntryNames map[string]bool
+
+func (u uniqueEntryNames) next(name string) string {
+	name = storage.SanitizeName(name)
+	candidate := name
+	ext := filepath.Ext(name)
+	for n := 2; u[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+	}
+	u[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// DownloadAlbum streams the downloadable files of an album as one ZIP,
+// written entry by entry without buffering. Password-protected, encrypted
+// and closed files are left out as they need per-file credentials, as are
+// files of unverified owners when those are blocked. One-time files are
+// left out too: they can only be burned by their own download. Every
+// included file counts as downloaded.
+func (h *FileHandler) DownloadAlbum(c *gin.Context) {
+	compression, ok := parseZipCompression(c)
+	if !ok {
+		return
+	}
+
+	albumUUID := c.Param("uuid")
+	rows, err := h.db.QueryRetry(`
+		SELECT f.id, f.original_name, f.file_path, f.mime_type
+		FROM files f
+		JOIN albums a ON a.id = f.album_id
+		WHERE a.uuid = $1 AND f.expires_at > NOW() AND f.deleted_at IS NULL AND f.disabled_at IS NULL AND f.scan_status = 'clean'
+		  AND f.password_hash IS NULL AND NOT f.is_encrypted AND NOT f.one_time
+		  AND (f.download_enabled_until IS NULL OR f.download_enabled_until > NOW())
+		  AND (f.max_downloads IS NULL OR f.download_count < f.max_downloads)
+		  AND (NOT $2 OR COALESCE((SELECT email_verified FROM users WHERE id = f.user_id), TRUE))
+		ORDER BY f.upload_index, f.id`,
+		albumUUID, h.blockUnverifiedOwners,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch album")
+		return
+	}
+	type entry struct {
+		id                       int
+		name, filePath, mimeType string
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.name, &e.filePath, &e.mimeType); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No downloadable files in this album"})
+		return
+	}
+
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = int64(e.id)
+	}
+	clientIP := c.ClientIP()
+	_, err = h.db.Exec(`
+		WITH counted AS (
+			UPDATE files SET download_count = download_count + 1
+			WHERE id = ANY($1) AND (max_downloads IS NULL OR download_count < max_downloads)
+			RETURNING id
+		)
+		INSERT INTO downloads (file_id, ip_address, user_agent)
+		SELECT id, $2, $3 FROM counted`,
+		pq.Array(ids), clientIP, c.GetHeader("User-Agent"),
+	)
+	if err != nil {
+		logging.FromContext(c).Warn("recording album download failed", "event", "download_log_failed", "album_uuid", albumUUID, "error", err)
+	}
+	metrics.Downloads.Add(float64(len(entries)))
+	h.events.Publish("download", "Album downloaded", map[string]interface{}{
+		"album_uuid": albumUUID,
+		"files":      len(entries),
+		"ip_address": clientIP,
+	})
+
+	// From here on the status is sent; failures can only cut the archive short
+	defer metrics.ObserveDownload(c.Writer)
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="album-`+albumUUID+`.zip"`)
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	names := uniqueEntryNames{}
+	for _, e := range entries {
+		if err = writeZipFile(zw, h.storage, names.next(e.name), e.filePath, compression.method(e.mimeType)); err != nil {
+			break
+		}
+		c.Writer.Flush()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		logging.FromContext(c).Error("writing album archive failed", "event", "archive_failed", "album_uuid", albumUUID, "error", err)
+	}
+}
+
+const maxZipFiles = 500
+
+// DownloadFiles streams a selection of the caller's own files as one ZIP,
+// named by their original names. Expired files are skipped; as the owner is
+// fetching their own files, nothing counts as a download.
+func (h *FileHandler) DownloadFiles(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	compression, ok := parseZipCompression(c)
+	if !ok {
+		return
+	}
+
+	var uuids []string
+	for _, u := range strings.Split(c.Query("uuids"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			uuids = append(uuids, u)
+		}
+	}
+	if len(uuids) == 0 || len(uuids) > maxZipFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("uuids must list between 1 and %d files", maxZipFiles)})
+		return
+	}
+
+	rows, err := h.db.QueryRetry(`
+		SELECT uuid, original_name, file_path, mime_type, expires_at > NOW() AND scan_status = 'clean'
+		FROM files
+		WHERE uuid = ANY($1) AND user_id = $2 AND deleted_at IS NULL`,
+		pq.Array(uuids), userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch files")
+		return
+	}
+	type entry struct {
+		name, filePath, mimeType string
+		active                   bool
+	}
+	found := make(map[string]entry)
+	for rows.Next() {
+		var fileUUID string
+		var e entry
+		if err := rows.Scan(&fileUUID, &e.name, &e.filePath, &e.mimeType, &e.active); err != nil {
+			continue
+		}
+		found[fileUUID] = e
+	}
+	rows.Close()
+
+	// Keep the order the files were asked for
+	var entries []entry
+	seen := make(map[string]bool)
+	for _, u := range uuids {
+		e, ok := found[u]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found", "uuid": u})
+			return
+		}
+		if e.active && !seen[u] {
+			entries = append(entries, e)
+		}
+		seen[u] = true
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "All requested files have expired or are not cleared by the malware scanner"})
+		return
+	}
+
+	// From here on the status is sent; failures can only cut the archive short
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="files-`+time.Now().UTC().Format("20060102-150405")+`.zip"`)
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	names := uniqueEntryNames{}
+	for _, e := range entries {
+		if err = writeZipFile(zw, h.storage, names.next(e.name), e.filePath, compression.method(e.mimeType)); err != nil {
+			break
+		}
+		c.Writer.Flush()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		logging.FromContext(c).Error("writing file archive failed", "event", "archive_failed", "files", len(entries), "error", err)
+	}
+}
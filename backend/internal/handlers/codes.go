@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	numericAlphabet = "0123456789"
+	// alphanumericAlphabet leaves out characters that are easily confused
+	// when read aloud or handwritten (0/O, 1/I/L)
+	alphanumericAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+	defaultShareCodeLength = 6
+	minShareCodeLength     = 4
+	maxShareCodeLength     = 16
+	shareCodeAttempts      = 10
+
+	// Six digits carry just under 20 bits; anything less is raised to it
+	defaultShareCodeMinEntropy = 19
+	// User-chosen codes tend to be words, so they must look much stronger
+	defaultCustomCodeMinEntropy = 40
+)
+
+var customCodePattern = regexp.MustCompile(`^[A-Za-z0-9-]{4,32}$`)
+
+// shareCodeSettings configures generated and user-chosen share codes.
+type shareCodeSettings struct {
+	length           int
+	alphabet         string
+	customMinEntropy float64
+	// secret, when set, makes codes be stored as HMAC digests so a leaked
+	// database does not reveal working codes (SHARE_CODE_HASH)
+	secret []byte
+}
+
+// loadShareCodeSettings reads SHARE_CODE_LENGTH, SHARE_CODE_ALPHABET
+// (numeric or alphanumeric), SHARE_CODE_MIN_ENTROPY,
+// SHARE_CODE_CUSTOM_MIN_ENTROPY and SHARE_CODE_HASH with its secret
+// SHARE_CODE_SECRET (defaulting to JWT_SECRET). Generated codes are
+// lengthened as needed to reach the minimum entropy.
+func loadShareCodeSettings() shareCodeSettings {
+	s := shareCodeSettings{alphabet: numericAlphabet}
+	if os.Getenv("SHARE_CODE_ALPHABET") == "alphanumeric" {
+		s.alphabet = alphanumericAlphabet
+	}
+
+	s.length, _ = strconv.Atoi(os.Getenv("SHARE_CODE_LENGTH"))
+	if s.length < minShareCodeLength || s.length > maxShareCodeLength {
+		s.length = defaultShareCodeLength
+	}
+	minEntropy := envFloat("SHARE_CODE_MIN_ENTROPY", defaultShareCodeMinEntropy)
+	bitsPerChar := math.Log2(float64(len(s.alphabet)))
+	if need := int(math.Ceil(minEntropy / bitsPerChar)); need > s.length {
+		s.length = min(need, maxShareCodeLength)
+	}
+	s.customMinEntropy = envFloat("SHARE_CODE_CUSTOM_MIN_ENTROPY", defaultCustomCodeMinEntropy)
+
+	if os.Getenv("SHARE_CODE_HASH") == "true" {
+		secret := os.Getenv("SHARE_CODE_SECRET")
+		if secret == "" {
+			secret = string(middleware.JWTSecret())
+		}
+		s.secret = []byte(secret)
+	}
+	return s
+}
+
+func envFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+// stored returns the form of code kept in files.share_code.
+func (s shareCodeSettings) stored(code string) string {
+	if s.secret == nil {
+		return code
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// codeEntropy estimates the bits of a user-chosen code from its length and
+// the character classes it uses. Real entropy is lower for dictionary words,
+// which is why the required minimum is high.
+func codeEntropy(code string) float64 {
+	var lower, upper, digit, dash bool
+	for _, r := range code {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		default:
+			dash = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {dash, 1}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool < 2 {
+		return 0
+	}
+	return float64(len(code)) * math.Log2(float64(pool))
+}
+
+func randomCode(length int, alphabet string) (string, error) {
+	code := make([]byte, length)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// CreateShareCode assigns a new short code to an owned file, replacing any
+// previous one. The code stops working when the file expires. An optional
+// body {"code": "..."} picks the code instead of generating one; it must
+// meet SHARE_CODE_CUSTOM_MIN_ENTROPY.
+func (h *FileHandler) CreateShareCode(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code != "" {
+		if !customCodePattern.MatchString(req.Code) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code must be 4-32 letters, digits or dashes"})
+			return
+		}
+		if codeEntropy(req.Code) < h.codes.customMinEntropy {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("code is too easy to guess; use at least %.0f bits, e.g. a longer mix of upper- and lowercase letters and digits", h.codes.customMinEntropy)})
+			return
+		}
+	}
+
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	for attempt := 0; attempt < shareCodeAttempts; attempt++ {
+		code := req.Code
+		if code == "" {
+			var err error
+			code, err = randomCode(h.codes.length, h.codes.alphabet)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate code"})
+				return
+			}
+		}
+		stored := h.codes.stored(code)
+
+		// Codes of expired files are free for reuse
+		if _, err := h.db.Exec("UPDATE files SET share_code = NULL WHERE share_code = $1 AND expires_at <= NOW()", stored); err != nil {
+			respondDBError(c, err, "Failed to create code")
+			return
+		}
+
+		var expiresAt time.Time
+		err := h.db.QueryRow(
+			"UPDATE files SET share_code = $1, updated_at = NOW() WHERE id = $2 RETURNING expires_at",
+			stored, fileID,
+		).Scan(&expiresAt)
+		if isUniqueViolation(err) {
+			if req.Code != "" {
+				c.JSON(http.StatusConflict, gin.H{"error": "Code is already taken"})
+				return
+			}
+			continue
+		}
+		if err != nil {
+			respondDBError(c, err, "Failed to create code")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":       code,
+			"url":        middleware.ExternalURL(c, "/p/"+code),
+			"expires_at": expiresAt,
+		})
+		return
+	}
+
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not find a free code, try again"})
+}
+
+// DeleteShareCode removes the short code of an owned file.
+func (h *FileHandler) DeleteShareCode(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.db.Exec("UPDATE files SET share_code = NULL, updated_at = NOW() WHERE id = $1", fileID); err != nil {
+		respondDBError(c, err, "Failed to delete code")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Code deleted successfully"})
+}
+
+// GetFileByCode resolves a short code and then behaves like /share/:uuid,
+// including the password check. Malformed, unknown and expired codes all
+// get the same answer. The route is rate limited since the code space is
+// small.
+func (h *FileHandler) GetFileByCode(c *gin.Context) {
+	code := c.Param("code")
+	if !customCodePattern.MatchString(code) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
+		return
+	}
+
+	var fileUUID string
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(
+			"SELECT uuid FROM files WHERE share_code = $1 AND expires_at > NOW() AND deleted_at IS NULL", h.codes.stored(code),
+		).Scan(&fileUUID)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+
+	h.serveShare(c, fileUUID)
+}
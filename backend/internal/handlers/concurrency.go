@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// downloadRetryAfter is the Retry-After sent when all download slots of a
+// file are taken.
+const downloadRetryAfter = 10
+
+// downloadSlots counts in-progress downloads per file so files with a
+// max_concurrent_downloads limit can turn extra clients away. Counts are
+// per process.
+type downloadSlots struct {
+	mu     sync.Mutex
+	active map[int]int
+}
+
+func newDownloadSlots() *downloadSlots {
+	return &downloadSlots{active: make(map[int]int)}
+}
+
+// acquire takes a slot for a download of the file if fewer than limit are
+// in progress. Every successful acquire must be paired with a release.
+func (s *downloadSlots) acquire(fileID, limit int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[fileID] >= limit {
+		return false
+	}
+	s.active[fileID]++
+	return true
+}
+
+func (s *downloadSlots) release(fileID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[fileID] <= 1 {
+		delete(s.active, fileID)
+		return
+	}
+	s.active[fileID]--
+}
+
+// parseMaxConcurrentDownloads reads an optional positive limit; an empty
+// value means unlimited.
+func parseMaxConcurrentDownloads(value string) (*int, bool) {
+	if value == "" {
+		return nil, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return nil, false
+	}
+	return &n, true
+}
+
+// UpdateMaxConcurrentDownloads sets how many downloads of an owned file may
+// run at once: {"max_concurrent_downloads": 5}, or null for unlimited.
+func (h *FileHandler) UpdateMaxConcurrentDownloads(c *gin.Context) {
+	var req struct {
+		MaxConcurrentDownloads *int `json:"max_concurrent_downloads"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxConcurrentDownloads != nil && *req.MaxConcurrentDownloads <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_concurrent_downloads must be positive or null"})
+		return
+	}
+
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.db.Exec("UPDATE files SET max_concurrent_downloads = $1, updated_at = NOW() WHERE id = $2", req.MaxConcurrentDownloads, fileID); err != nil {
+		respondDBError(c, err, "Failed to update download limit")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"max_concurrent_downloads": req.MaxConcurrentDownloads})
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultInlineTypes are the MIME types (or type prefixes ending in "/")
+// served inline unless INLINE_MIME_TYPES overrides them.
+var defaultInlineTypes = []string{"image/", "application/pdf", "text/plain"}
+
+// inlineSafeTypes are the only MIME types (or type prefixes ending in "/")
+// ever served inline: browsers render them without running script. Other
+// types are attachments whatever INLINE_MIME_TYPES or the request say.
+var inlineSafeTypes = []string{"image/", "application/pdf", "text/plain", "audio/", "video/"}
+
+// attachmentOnlyTypes can execute script in the browser and are therefore
+// always served as attachments, regardless of configuration or request.
+var attachmentOnlyTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/xml":               true,
+	"application/xml":        true,
+	"text/javascript":        true,
+	"application/javascript": true,
+}
+
+func loadInlineTypes() []string {
+	value := os.Getenv("INLINE_MIME_TYPES")
+	if value == "" {
+		return defaultInlineTypes
+	}
+
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// defaultInlineCSP lets inline files render media but nothing that runs
+// script, submits forms or frames other content.
+const defaultInlineCSP = "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'none'; sandbox"
+
+// inlineContent controls how inline-served files reach the browser.
+type inlineContent struct {
+	// csp replaces the global Content-Security-Policy on inline responses
+	// (INLINE_CONTENT_SECURITY_POLICY, "off" keeps the global one)
+	csp string
+	// origin is a separate sandbox origin inline views are redirected to,
+	// keeping them away from the app's tokens and cookies (INLINE_ORIGIN)
+	origin *url.URL
+}
+
+func loadInlineContent() inlineContent {
+	inline := inlineContent{csp: defaultInlineCSP}
+	if value := strings.TrimSpace(os.Getenv("INLINE_CONTENT_SECURITY_POLICY")); value != "" {
+		inline.csp = value
+		if strings.EqualFold(value, "off") {
+			inline.csp = ""
+		}
+	}
+	if value := os.Getenv("INLINE_ORIGIN"); value != "" {
+		origin, err := url.Parse(value)
+		if err != nil || origin.Host == "" || (origin.Scheme != "http" && origin.Scheme != "https") {
+			slog.Warn("ignoring invalid INLINE_ORIGIN", "value", value)
+		} else {
+			inline.origin = origin
+		}
+	}
+	return inline
+}
+
+// redirect sends an inline view requested on any other host to the sandbox
+// origin, reporting whether it did. The request URI is kept so passwords
+// and the inline override carry over.
+func (i inlineContent) redirect(c *gin.Context) bool {
+	if i.origin == nil || strings.EqualFold(c.Request.Host, i.origin.Host) {
+		return false
+	}
+	c.Redirect(http.StatusTemporaryRedirect, i.origin.Scheme+"://"+i.origin.Host+c.Request.URL.RequestURI())
+	return true
+}
+
+// executableType reports whether a MIME type can run script when rendered
+// by a browser.
+func executableType(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	return err != nil || attachmentOnlyTypes[mediaType]
+}
+
+// contentDisposition formats a Content-Disposition header for a file name.
+// Control characters are dropped and the quoted filename is limited to
+// ASCII; names with other characters additionally get the RFC 5987
+// filename* form, which clients prefer, so no name can break the header.
+func contentDisposition(disposition, filename string) string {
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+
+	var fallback, encoded strings.Builder
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r > unicode.MaxASCII:
+			ascii = false
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	value := disposition + `; filename="` + fallback.String() + `"`
+	if ascii {
+		return value
+	}
+
+	for _, b := range []byte(filename) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return value + "; filename*=UTF-8''" + encoded.String()
+}
+
+// isAttrChar reports whether b may appear unencoded in an RFC 5987 value.
+func isAttrChar(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+		strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// matchesType reports whether mediaType is one of types, where a type ending
+// in "/" matches its whole family.
+func matchesType(mediaType string, types []string) bool {
+	for _, t := range types {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveInline decides whether a file of the given MIME type is served inline.
+// Only inlineSafeTypes can be; override is the request's "inline" query
+// parameter and, when set, takes precedence over the configured defaults
+// for them.
+func (h *FileHandler) serveInline(mimeType, override string) bool {
+	if executableType(mimeType) {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	if !matchesType(mediaType, inlineSafeTypes) {
+		return false
+	}
+
+	switch override {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+
+	return matchesType(mediaType, h.inlineTypes)
+}
+
+// variantPreference orders image variant types from most to least preferred.
+var variantPreference = []string{"image/avif", "image/webp"}
+
+// acceptsMediaType reports whether the Accept header explicitly lists
+// mediaType with a non-zero quality. Wildcards are ignored on purpose:
+// clients sending */* may not be able to decode newer image formats.
+func acceptsMediaType(accept, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mediaType) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && key == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
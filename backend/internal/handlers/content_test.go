@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		// fallback is the quoted filename= value, encoded the filename*=
+		// value or "" when there is none
+		fallback string
+		encoded  string
+		// parsed is the name a client reads from the header
+		parsed string
+	}{
+		{name: "plain", filename: "report.pdf", fallback: `"report.pdf"`, parsed: "report.pdf"},
+		{name: "spaces", filename: "annual report 2024.pdf", fallback: `"annual report 2024.pdf"`, parsed: "annual report 2024.pdf"},
+		{name: "quotes", filename: `the "final" one.txt`, fallback: `"the \"final\" one.txt"`, parsed: `the "final" one.txt`},
+		{name: "backslash", filename: `a\b.txt`, fallback: `"a\\b.txt"`, parsed: `a\b.txt`},
+		{name: "header injection", filename: "a\";\r\nSet-Cookie: x=1.txt", fallback: `"a\";Set-Cookie: x=1.txt"`, parsed: `a";Set-Cookie: x=1.txt`},
+		{name: "accents", filename: "résumé.pdf", fallback: `"r_sum_.pdf"`, encoded: "r%C3%A9sum%C3%A9.pdf", parsed: "résumé.pdf"},
+		{name: "cjk with space", filename: "報告 書.docx", fallback: `"__ _.docx"`, encoded: "%E5%A0%B1%E5%91%8A%20%E6%9B%B8.docx", parsed: "報告 書.docx"},
+		{name: "emoji and quote", filename: `🎉 "party".png`, fallback: `"_ \"party\".png"`, encoded: "%F0%9F%8E%89%20%22party%22.png", parsed: `🎉 "party".png`},
+		{name: "percent and semicolon", filename: "100% ü;x.txt", fallback: `"100% _;x.txt"`, encoded: "100%25%20%C3%BC%3Bx.txt", parsed: "100% ü;x.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := contentDisposition("attachment", tt.filename)
+
+			want := "attachment; filename=" + tt.fallback
+			if tt.encoded != "" {
+				want += "; filename*=UTF-8''" + tt.encoded
+			}
+			if header != want {
+				t.Errorf("header\n  %s\nwant\n  %s", header, want)
+			}
+			if strings.ContainsAny(header, "\r\n") {
+				t.Errorf("header %q spans lines", header)
+			}
+
+			disposition, params, err := mime.ParseMediaType(header)
+			if err != nil {
+				t.Fatalf("header %q does not parse: %v", header, err)
+			}
+			if disposition != "attachment" || params["filename"] != tt.parsed {
+				t.Errorf("parsed as %s with filename %q, want attachment with %q", disposition, params["filename"], tt.parsed)
+			}
+		})
+	}
+}
+
+func TestContentDispositionInline(t *testing.T) {
+	if got, want := contentDisposition("inline", "photo.jpg"), `inline; filename="photo.jpg"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// Only the allow-listed types are ever served inline; the request can turn
+// inline off for them but never on for anything else.
+func TestServeInline(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		override string
+		want     bool
+	}{
+		{mimeType: "image/png", want: true},
+		{mimeType: "application/pdf", want: true},
+		{mimeType: "text/plain; charset=utf-8", want: true},
+		{mimeType: "image/png", override: "false", want: false},
+		{mimeType: "video/mp4", want: false},
+		{mimeType: "video/mp4", override: "true", want: true},
+		{mimeType: "audio/mpeg", override: "1", want: true},
+		{mimeType: "image/svg+xml", override: "true", want: false},
+		{mimeType: "text/html", override: "true", want: false},
+		{mimeType: "text/css", override: "true", want: false},
+		{mimeType: "application/json", override: "true", want: false},
+		{mimeType: "application/octet-stream", override: "true", want: false},
+		{mimeType: "application/x-shockwave-flash", override: "true", want: false},
+		{mimeType: "not a type", override: "true", want: false},
+	}
+	h := &FileHandler{inlineTypes: defaultInlineTypes}
+	for _, tt := range tests {
+		if got := h.serveInline(tt.mimeType, tt.override); got != tt.want {
+			t.Errorf("serveInline(%q, %q) = %v, want %v", tt.mimeType, tt.override, got, tt.want)
+		}
+	}
+
+	// Configuration cannot widen the allow-list either
+	h.inlineTypes = []string{"text/", "application/json"}
+	if h.serveInline("text/css", "") || h.serveInline("application/json", "") {
+		t.Error("INLINE_MIME_TYPES made a type outside the allow-list inline")
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const shareCookiePrefix = "share_access_"
+
+// shareCookies issues short-lived signed cookies proving that a browser has
+// entered a share's password, so downloads need no password in the URL.
+// Each cookie is named after its share's UUID and sent on every path, as a
+// share is reached by its UUID or slug and through the info API alike. It
+// is bound to the current password hash, so changing the password
+// invalidates it.
+type shareCookies struct {
+	enabled bool
+	secret  []byte
+	ttl     time.Duration
+}
+
+// loadShareCookies reads SHARE_COOKIES, SHARE_COOKIE_SECRET (defaulting to
+// JWT_SECRET) and SHARE_COOKIE_TTL.
+func loadShareCookies() shareCookies {
+	enabled, _ := strconv.ParseBool(os.Getenv("SHARE_COOKIES"))
+	secret := os.Getenv("SHARE_COOKIE_SECRET")
+	if secret == "" {
+		secret = string(middleware.JWTSecret())
+	}
+	ttl, err := time.ParseDuration(os.Getenv("SHARE_COOKIE_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	return shareCookies{enabled: enabled && secret != "", secret: []byte(secret), ttl: ttl}
+}
+
+// shareCookieName is the name of the cookie unlocking the share.
+func shareCookieName(fileUUID string) string {
+	return shareCookiePrefix + fileUUID
+}
+
+func (s shareCookies) sign(fileUUID, passwordHash string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(fileUUID + "\n" + strconv.FormatInt(expires, 10) + "\n" + passwordHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// set attaches a fresh cookie for the share to the response.
+func (s shareCookies) set(c *gin.Context, fileUUID, passwordHash string) {
+	if !s.enabled {
+		return
+	}
+	expires := time.Now().Add(s.ttl).Unix()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     shareCookieName(fileUUID),
+		Value:    strconv.FormatInt(expires, 10) + "." + s.sign(fileUUID, passwordHash, expires),
+		Path:     "/",
+		MaxAge:   int(s.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   middleware.RequestScheme(c) == "https",
+		// Lax keeps the cookie on the top-level navigation that follows unlocking
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// present reports whether the request carries a cookie for the share at
+// all, valid or not.
+func (s shareCookies) present(c *gin.Context, fileUUID string) bool {
+	if !s.enabled {
+		return false
+	}
+	_, err := c.Cookie(shareCookieName(fileUUID))
+	return err == nil
+}
+
+// valid reports whether the request carries an unexpired cookie for the
+// share signed over its current password hash.
+func (s shareCookies) valid(c *gin.Context, fileUUID, passwordHash string) bool {
+	if !s.enabled {
+		return false
+	}
+	value, err := c.Cookie(shareCookieName(fileUUID))
+	if err != nil {
+		return false
+	}
+	expiresStr, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(s.sign(fileUUID, passwordHash, expires)))
+}
+
+// UnlockShare checks a share password posted from an HTML form, sets the
+// share cookie and redirects to the download, keeping the password out of
+// URLs and logs. The share may be named by its UUID or slug; the redirect
+// goes back to whichever the form was posted to.
+func (h *FileHandler) UnlockShare(c *gin.Context) {
+	if !h.shareCookies.enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share cookies are disabled"})
+		return
+	}
+
+	shareID := c.Param("uuid")
+	fileUUID, err := h.resolveShareID(shareID)
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+	var passwordHash *string
+	var expiresAt time.Time
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT password_hash, expires_at FROM files WHERE uuid = $1 AND deleted_at IS NULL", fileUUID).Scan(&passwordHash, &expiresAt)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+
+	if !checkSharePassword(passwordHash, c.PostForm("password")) {
+		c.JSON(http.StatusUnauthorized, errSharePassword)
+		return
+	}
+	if passwordHash != nil {
+		h.shareCookies.set(c, fileUUID, *passwordHash)
+	}
+
+	c.Redirect(http.StatusSeeOther, "/share/"+shareID)
+}
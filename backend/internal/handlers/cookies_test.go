@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newUnlockTest returns a handler for a share protected by hash and a jar
+// keeping the cookies it sets.
+func newUnlockTest(t *testing.T, hash string) (*FileHandler, *cookiejar.Jar) {
+	t.Helper()
+	db := dbtest.Open(t, withSlug(func(query string, args []driver.Value) (*dbtest.Result, error) {
+		if args[0] != testShareUUID {
+			return nil, nil
+		}
+		switch {
+		case dbtest.Match(query, "SELECT password_hash, expires_at FROM files WHERE uuid = $1"):
+			return dbtest.Row(hash, time.Now().Add(time.Hour)), nil
+		// The share as GetFileInfo sees it
+		case dbtest.Match(query, "SELECT id, original_name, file_size", "FROM files WHERE uuid = $1"):
+			return dbtest.Row(
+				int64(1), "report.pdf", int64(1024), "application/pdf",
+				hash,
+				time.Now().Add(time.Hour), int64(0), time.Now(),
+				false, nil, int64(0), nil, nil,
+				nil, false, "clean", testShareSlug, nil,
+			), nil
+		}
+		return nil, nil
+	}))
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &FileHandler{
+		db:           db,
+		views:        services.NewViewCounter(db),
+		shareCookies: shareCookies{enabled: true, secret: []byte("test secret"), ttl: time.Minute},
+	}
+	return h, jar
+}
+
+// unlock posts password to the unlock route of the share named id and
+// stores the cookies of the response in jar.
+func unlock(h *FileHandler, jar *cookiejar.Jar, id, password string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/share/:uuid/unlock", h.UnlockShare)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://files.example.com/share/"+id+"/unlock", strings.NewReader(url.Values{"password": {password}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(w, req)
+	jar.SetCookies(req.URL, w.Result().Cookies())
+	return w
+}
+
+// cookieValid reports whether the cookies jar sends to path unlock the
+// share.
+func cookieValid(h *FileHandler, jar *cookiejar.Jar, path, hash string) bool {
+	req := httptest.NewRequest(http.MethodGet, "http://files.example.com"+path, nil)
+	for _, cookie := range jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	return h.shareCookies.valid(c, testShareUUID, hash)
+}
+
+// A share unlocked by its UUID or its slug is unlocked on every path it is
+// reachable at, and the browser is sent back to the path it came from.
+func TestUnlockShare(t *testing.T) {
+	hash := mustHash(t, "correct horse")
+	paths := []string{
+		"/share/" + testShareUUID,
+		"/share/" + testShareSlug,
+		"/api/files/info/" + testShareUUID,
+		"/api/files/info/" + testShareSlug + "/preview",
+	}
+	for _, id := range []string{testShareUUID, testShareSlug} {
+		t.Run(id, func(t *testing.T) {
+			h, jar := newUnlockTest(t, hash)
+
+			w := unlock(h, jar, id, "correct horse")
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("status %d, want 303: %s", w.Code, w.Body)
+			}
+			if location := w.Header().Get("Location"); location != "/share/"+id {
+				t.Errorf("redirected to %s, want /share/%s", location, id)
+			}
+			for _, path := range paths {
+				if !cookieValid(h, jar, path, hash) {
+					t.Errorf("%s does not get a valid share cookie", path)
+				}
+			}
+		})
+	}
+}
+
+func TestUnlockShareRefusals(t *testing.T) {
+	hash := mustHash(t, "correct horse")
+	h, jar := newUnlockTest(t, hash)
+
+	if w := unlock(h, jar, testShareSlug, "battery staple"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want 401", w.Code)
+	}
+	if w := unlock(h, jar, "no-such-share", "correct horse"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown slug: status %d, want 404", w.Code)
+	}
+	if cookieValid(h, jar, "/share/"+testShareSlug, hash) {
+		t.Error("a refused unlock set a share cookie")
+	}
+	// A cookie only unlocks the password it was issued for
+	unlock(h, jar, testShareSlug, "correct horse")
+	if cookieValid(h, jar, "/share/"+testShareSlug, mustHash(t, "new password")) {
+		t.Error("the cookie survived a password change")
+	}
+}
+
+// With PROTECT_FILE_INFO the details of a protected share are shown to
+// browsers that unlocked it, whichever name they use for it.
+func TestGetFileInfoAcceptsShareCookie(t *testing.T) {
+	hash := mustHash(t, "correct horse")
+	h, jar := newUnlockTest(t, hash)
+	h.protectInfo = true
+	r := gin.New()
+	r.GET("/api/files/info/:uuid", h.GetFileInfo)
+	info := func(id string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://files.example.com/api/files/info/"+id, nil)
+		for _, cookie := range jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status := info(testShareSlug); status != http.StatusUnauthorized {
+		t.Fatalf("before unlocking: status %d, want 401", status)
+	}
+	unlock(h, jar, testShareSlug, "correct horse")
+	for _, id := range []string{testShareSlug, testShareUUID} {
+		if status := info(id); status != http.StatusOK {
+			t.Errorf("%s after unlocking: status %d, want 200", id, status)
+		}
+	}
+}
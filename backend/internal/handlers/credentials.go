@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// session is a login of the user as listed to them: the chain of refresh
+// tokens handed out since they logged in, of which only the latest is
+// active. Its ID stays the same as refreshes rotate the token.
+type session struct {
+	ID         int        `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// GetCredentials lists the caller's active sessions and API keys in one
+// place, so they can audit what can act on their behalf.
+func (h *AuthHandler) GetCredentials(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// A token created after its session started was handed out by a
+	// refresh, which is when the session was last used
+	rows, err := h.db.QueryRetry(`
+		SELECT session_id, session_started_at, expires_at,
+		       CASE WHEN created_at > session_started_at THEN created_at END
+		FROM refresh_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC, session_id DESC`,
+		userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch sessions")
+		return
+	}
+	sessions := []session{}
+	for rows.Next() {
+		var s session
+		if err := rows.Scan(&s.ID, &s.CreatedAt, &s.ExpiresAt, &s.LastUsedAt); err != nil {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+
+	rows, err = h.db.QueryRetry(
+		"SELECT id, name, prefix, created_at, last_used_at FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id DESC",
+		userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch API keys")
+		return
+	}
+	defer rows.Close()
+	keys := []apiKey{}
+	for rows.Next() {
+		var k apiKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "api_keys": keys})
+}
+
+// RevokeSession ends one of the caller's sessions by revoking its refresh
+// tokens. Access tokens already issued to it stay valid until they expire.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	res, err := h.db.Exec(
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE session_id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()",
+		sessionID, userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to revoke session")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDigestBlockSize = 4 << 20
+	minDigestBlockSize     = 64 << 10
+	maxDigestBlockSize     = 64 << 20
+)
+
+// fileDigest is the whole-file SHA-256 plus one SHA-256 per block, letting a
+// client resuming a download verify the bytes it already has.
+type fileDigest struct {
+	Algorithm string   `json:"algorithm"`
+	Digest    string   `json:"digest"`
+	FileSize  int64    `json:"file_size"`
+	BlockSize int64    `json:"block_size"`
+	Blocks    []string `json:"blocks"`
+}
+
+// computeFileDigest hashes the blob in a single pass, producing both the
+// whole-file digest and the per-block digests.
+func computeFileDigest(store storage.Storage, path string, blockSize int64) (*fileDigest, error) {
+	f, err := store.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	whole := sha256.New()
+	digest := &fileDigest{Algorithm: "sha-256", BlockSize: blockSize, Blocks: []string{}}
+	for {
+		block := sha256.New()
+		n, err := io.CopyN(io.MultiWriter(whole, block), f, blockSize)
+		if n > 0 {
+			digest.Blocks = append(digest.Blocks, hex.EncodeToString(block.Sum(nil)))
+			digest.FileSize += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	digest.Digest = base64.StdEncoding.EncodeToString(whole.Sum(nil))
+	return digest, nil
+}
+
+// cachedFileDigest returns the stored digest for a file and block size,
+// computing and storing it on first use. Stored blobs never change, so the
+// cached value stays valid for the lifetime of the file.
+func (h *FileHandler) cachedFileDigest(fileID int, path string, blockSize int64) (*fileDigest, error) {
+	var digest fileDigest
+	var blocks string
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(
+			"SELECT digest, file_size, blocks FROM file_digests WHERE file_id = $1 AND block_size = $2",
+			fileID, blockSize,
+		).Scan(&digest.Digest, &digest.FileSize, &blocks)
+	})
+	if err == nil && json.Unmarshal([]byte(blocks), &digest.Blocks) == nil {
+		digest.Algorithm = "sha-256"
+		digest.BlockSize = blockSize
+		return &digest, nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	computed, err := computeFileDigest(h.storage, path, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	// Caching is best effort; the computed digest is correct either way
+	encoded, _ := json.Marshal(computed.Blocks)
+	h.db.Exec(`
+		INSERT INTO file_digests (file_id, block_size, digest, file_size, blocks)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (file_id, block_size) DO NOTHING`,
+		fileID, blockSize, computed.Digest, computed.FileSize, string(encoded),
+	)
+	return computed, nil
+}
+
+// fileETag is the strong entity tag of a file version, derived from its
+// whole-file digest.
+func fileETag(digest string) string {
+	return `"sha256-` + digest + `"`
+}
+
+// etagMatches evaluates an If-Match header against etag using the strong
+// comparison RFC 9110 requires for If-Match.
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checksumDigest converts a file's hex upload checksum to the base64 form
+// used in digests and ETags, or "" for files without one.
+func checksumDigest(checksum *string) string {
+	if checksum == nil {
+		return ""
+	}
+	sum, err := hex.DecodeString(*checksum)
+	if err != nil || len(sum) != sha256.Size {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// storedReprDigest returns any previously computed whole-file digest, so
+// downloads can carry Repr-Digest without hashing on the request path.
+func (h *FileHandler) storedReprDigest(fileID int) string {
+	var digest string
+	err := h.db.QueryRow("SELECT digest FROM file_digests WHERE file_id = $1 LIMIT 1", fileID).Scan(&digest)
+	if err != nil {
+		return ""
+	}
+	return digest
+}
+
+// GetFileDigest returns the whole-file and per-block SHA-256 digests of a
+// shared file. It is subject to the same expiry and password checks as the
+// download itself.
+func (h *FileHandler) GetFileDigest(c *gin.Context) {
+	blockSize := int64(defaultDigestBlockSize)
+	if v := c.Query("block_size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < minDigestBlockSize || size > maxDigestBlockSize || size%minDigestBlockSize != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "block_size must be a multiple of 65536 between 64KiB and 64MiB"})
+			return
+		}
+		blockSize = size
+	}
+
+	fileUUID, ok := h.shareParam(c)
+	if !ok {
+		return
+	}
+	file, ok := h.loadDownloadableFile(c, fileUUID)
+	if !ok {
+		return
+	}
+
+	digest, err := h.cachedFileDigest(file.ID, file.FilePath, blockSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute file digest"})
+		return
+	}
+
+	c.Header("Repr-Digest", "sha-256=:"+digest.Digest+":")
+	c.Header("ETag", fileETag(digest.Digest))
+	c.JSON(http.StatusOK, digest)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errShareDisabled answers requests for a file whose owner switched its
+// share off.
+var errShareDisabled = gin.H{
+	"error":    "This share has been disabled by its owner",
+	"disabled": true,
+}
+
+// DisableShare switches off the share of an owned file: it answers 410 to
+// everyone until it is enabled again, but stays in the owner's listing with
+// its expiry, statistics and download history untouched.
+func (h *FileHandler) DisableShare(c *gin.Context) {
+	h.setShareDisabled(c, true)
+}
+
+// EnableShare switches a disabled share back on.
+func (h *FileHandler) EnableShare(c *gin.Context) {
+	h.setShareDisabled(c, false)
+}
+
+func (h *FileHandler) setShareDisabled(c *gin.Context, disabled bool) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	// Disabling again keeps the time it was first disabled
+	var disabledAt *time.Time
+	err := h.db.QueryRow(`
+		UPDATE files
+		SET disabled_at = CASE WHEN $1 THEN COALESCE(disabled_at, NOW()) END, updated_at = NOW()
+		WHERE id = $2
+		RETURNING disabled_at`,
+		disabled, fileID,
+	).Scan(&disabledAt)
+	if err != nil {
+		respondDBError(c, err, "Failed to update share")
+		return
+	}
+
+	event := "share_enabled"
+	if disabled {
+		event = "share_disabled"
+	}
+	logging.FromContext(c).Info("share switched", "event", event, "file_uuid", c.Param("uuid"))
+	c.JSON(http.StatusOK, gin.H{
+		"uuid":        c.Param("uuid"),
+		"disabled":    disabledAt != nil,
+		"disabled_at": disabledAt,
+	})
+}
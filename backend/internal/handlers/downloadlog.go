@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// downloadEvent is a logged download. IP address and user agent are null
+// once the download history of a deleted file was anonymized.
+type downloadEvent struct {
+	DownloadedAt time.Time `json:"downloaded_at"`
+	IPAddress    *string   `json:"ip_address"`
+	UserAgent    *string   `json:"user_agent"`
+}
+
+// anonymizeIP keeps only the network part of an address: the first three
+// octets of IPv4 and the first 48 bits of IPv6 addresses.
+func anonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// GetFileDownloads lists the downloads of an owned file, newest first, with
+// IP addresses truncated, along with its downloads per day.
+func (h *FileHandler) GetFileDownloads(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+	respondDownloadLog(c, h.db, fileID, false)
+}
+
+// GetFileDownloads is GetFileDownloads for any file. Full IP addresses are
+// only shown with ADMIN_SHOW_FULL_IPS=true.
+func (h *AdminHandler) GetFileDownloads(c *gin.Context) {
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var exists bool
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM files WHERE id = $1)", fileID).Scan(&exists)
+	})
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	respondDownloadLog(c, h.db, fileID, h.showFullIPs)
+}
+
+// respondDownloadLog answers with a page of a file's downloads (limit and
+// offset as for file lists) and its daily series (from and to).
+func respondDownloadLog(c *gin.Context, db *database.DB, fileID int, fullIPs bool) {
+	limit, offset, ok := parseFileListPage(c)
+	if !ok {
+		return
+	}
+	from, to, ok := parseDayRange(c)
+	if !ok {
+		return
+	}
+
+	reader := db.Reader()
+	var total int
+	err := reader.Retry(func() error {
+		return reader.QueryRow("SELECT COUNT(*) FROM downloads WHERE file_id = $1", fileID).Scan(&total)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch downloads")
+		return
+	}
+
+	rows, err := reader.QueryRetry(`
+		SELECT downloaded_at, ip_address, user_agent
+		FROM downloads
+		WHERE file_id = $1
+		ORDER BY downloaded_at DESC, id DESC
+		LIMIT $2 OFFSET $3`,
+		fileID, limit, offset,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch downloads")
+		return
+	}
+	downloads := []downloadEvent{}
+	for rows.Next() {
+		var e downloadEvent
+		var ip, userAgent sql.NullString
+		if err := rows.Scan(&e.DownloadedAt, &ip, &userAgent); err != nil {
+			rows.Close()
+			respondDBError(c, err, "Failed to fetch downloads")
+			return
+		}
+		if ip.Valid {
+			addr := ip.String
+			if !fullIPs {
+				addr = anonymizeIP(addr)
+			}
+			e.IPAddress = &addr
+		}
+		if userAgent.Valid {
+			e.UserAgent = &userAgent.String
+		}
+		downloads = append(downloads, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		respondDBError(c, err, "Failed to fetch downloads")
+		return
+	}
+
+	series, err := dailyDownloads(reader, from, to, "dl.file_id = $3", fileID)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch download statistics")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"downloads":     downloads,
+		"total":         total,
+		"limit":         limit,
+		"offset":        offset,
+		"ip_anonymized": !fullIPs,
+		"from":          from.Format(dayLayout),
+		"to":            to.Format(dayLayout),
+		"series":        series,
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDuplicateMinSize = 100 << 20
+	defaultDuplicateWindow  = 24 * time.Hour
+)
+
+// duplicateCheck configures the near-duplicate upload warning: a large file
+// whose name and size match one the user uploaded within the window is held
+// back until the client confirms it.
+type duplicateCheck struct {
+	minSize int64
+	window  time.Duration
+}
+
+// loadDuplicateCheck reads DUPLICATE_WARN_MIN_SIZE (bytes, 0 disables the
+// check) and DUPLICATE_WARN_WINDOW (a Go duration).
+func loadDuplicateCheck() duplicateCheck {
+	check := duplicateCheck{minSize: defaultDuplicateMinSize, window: defaultDuplicateWindow}
+	if v, err := strconv.ParseInt(os.Getenv("DUPLICATE_WARN_MIN_SIZE"), 10, 64); err == nil && v >= 0 {
+		check.minSize = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("DUPLICATE_WARN_WINDOW")); err == nil && v > 0 {
+		check.window = v
+	}
+	return check
+}
+
+// findRecentDuplicates returns, for every large uploaded file, the most
+// recent unexpired file of the user with the same name and size inside the
+// window.
+func (h *FileHandler) findRecentDuplicates(c *gin.Context, userID int, files []*uploadedFile) ([]gin.H, error) {
+	if h.duplicates.minSize == 0 {
+		return nil, nil
+	}
+
+	since := time.Now().Add(-h.duplicates.window)
+	var duplicates []gin.H
+	for _, file := range files {
+		if file.Size < h.duplicates.minSize {
+			continue
+		}
+
+		rows, err := h.db.QueryRetry(`
+			SELECT uuid, file_size, created_at, expires_at
+			FROM files
+			WHERE user_id = $1 AND original_name = $2 AND file_size = $3
+			  AND created_at > $4 AND expires_at > NOW() AND deleted_at IS NULL
+			ORDER BY created_at DESC
+			LIMIT 1`,
+			userID, file.Filename, file.Size, since,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var fileUUID string
+			var fileSize int64
+			var createdAt, expiresAt time.Time
+			if err := rows.Scan(&fileUUID, &fileSize, &createdAt, &expiresAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			duplicates = append(duplicates, gin.H{
+				"file_name":  file.Filename,
+				"uuid":       fileUUID,
+				"share_url":  middleware.ExternalURL(c, "/share/"+fileUUID),
+				"file_size":  fileSize,
+				"created_at": createdAt,
+				"expires_at": expiresAt,
+			})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Zero-knowledge shares
+//
+// For encrypted uploads the browser encrypts each file before uploading it,
+// so the server only ever stores ciphertext. The client derives key material
+// from the share password with a slow KDF, splits it into an encryption key
+// and a key verifier, and sends the server:
+//
+//   - encryption_params: a JSON object with everything a recipient needs to
+//     repeat the derivation and decrypt (KDF name, salt, cost parameters,
+//     cipher, IV/nonce). None of it is secret.
+//   - key_verifier: the verifier half of the derived key material.
+//
+// The server stores encryption_params verbatim and only a bcrypt hash of the
+// verifier. A recipient repeats the derivation from the password and proves
+// knowledge of it by sending the verifier in the X-Key-Verifier header; the
+// server then returns the ciphertext, which the client decrypts. Because the
+// verifier and the encryption key are independent outputs of the KDF, the
+// server can check the password but cannot recover the key or the plaintext.
+
+const (
+	maxEncryptionParamsSize = 4096
+	// bcrypt ignores input beyond 72 bytes, so longer verifiers would only
+	// be partially checked.
+	maxKeyVerifierLength = 72
+	minKeyVerifierLength = 16
+)
+
+var (
+	errInvalidEncryptionParams = errors.New("encryption_params must be a JSON object of at most 4096 bytes")
+	errInvalidKeyVerifier      = errors.New("key_verifier must be between 16 and 72 characters")
+)
+
+// hashKeyVerifier validates the upload's encryption fields and returns the
+// bcrypt hash of the key verifier to store.
+func hashKeyVerifier(params, verifier string) (string, error) {
+	var obj map[string]interface{}
+	if len(params) == 0 || len(params) > maxEncryptionParamsSize || json.Unmarshal([]byte(params), &obj) != nil {
+		return "", errInvalidEncryptionParams
+	}
+	if len(verifier) < minKeyVerifierLength || len(verifier) > maxKeyVerifierLength {
+		return "", errInvalidKeyVerifier
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(verifier), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"file-sharing-backend/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// respondDBError writes the response for a failed database call. Transient
+// errors that survived retrying are reported as 503 so clients know the
+// request can be repeated; anything else is a plain 500 with message.
+func respondDBError(c *gin.Context, err error, message string) {
+	if database.IsTransient(err) {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database temporarily unavailable, please retry"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultExifMaxSize = 50 << 20
+
+// exifTypes are the image types whose embedded metadata can be previewed.
+var exifTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/tiff": true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// exifReader extracts EXIF, IPTC and XMP fields with exiftool, included in
+// the Docker image.
+type exifReader struct {
+	tool    string
+	maxSize int64
+	timeout time.Duration
+}
+
+// loadExifReader reads EXIFTOOL_PATH and EXIF_MAX_SIZE (bytes).
+func loadExifReader() exifReader {
+	r := exifReader{tool: os.Getenv("EXIFTOOL_PATH"), maxSize: defaultExifMaxSize, timeout: 30 * time.Second}
+	if r.tool == "" {
+		r.tool = "exiftool"
+	}
+	if v, err := strconv.ParseInt(os.Getenv("EXIF_MAX_SIZE"), 10, 64); err == nil && v > 0 {
+		r.maxSize = v
+	}
+	return r
+}
+
+// read returns the metadata fields of the image at path keyed by
+// "Group:Tag", without file system details.
+func (r exifReader) read(path string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, r.tool, "-json", "-G", "-EXIF:All", "-IPTC:All", "-XMP:All", path).Output()
+	if err != nil {
+		return nil, err
+	}
+	var results []map[string]interface{}
+	if err := json.Unmarshal(output, &results); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if len(results) > 0 {
+		fields = results[0]
+	}
+	delete(fields, "SourceFile")
+	return fields, nil
+}
+
+// GetFileExif previews the metadata embedded in an owned image, so owners can
+// check what they are sharing.
+func (h *FileHandler) GetFileExif(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	var path, mimeType string
+	var fileSize int64
+	var isEncrypted bool
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(
+			"SELECT file_path, mime_type, file_size, is_encrypted FROM files WHERE id = $1", fileID,
+		).Scan(&path, &mimeType, &fileSize, &isEncrypted)
+	})
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+
+	if isEncrypted || !exifTypes[mimeType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Metadata preview is only available for JPEG, PNG, TIFF, WebP and HEIC images"})
+		return
+	}
+	if fileSize > h.exif.maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Metadata preview is limited to images up to %d bytes", h.exif.maxSize)})
+		return
+	}
+
+	// exiftool only reads local files
+	local, release, err := storage.LocalFile(h.storage, path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image metadata"})
+		return
+	}
+	defer release()
+
+	fields, err := h.exif.read(local)
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Metadata extraction is not available"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image metadata"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mime_type": mimeType, "metadata": fields})
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultFileLifetime    = 24 * time.Hour
+	defaultMaxFileLifetime = 30 * 24 * time.Hour
+)
+
+// loadMaxFileLifetime reads MAX_FILE_LIFETIME, the longest a file may live
+// counted from its upload, however often it is extended.
+func loadMaxFileLifetime() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("MAX_FILE_LIFETIME"))
+	if err != nil || d <= 0 {
+		return defaultMaxFileLifetime
+	}
+	return d
+}
+
+// parseExpiresIn reads an upload lifetime: a Go duration ("90m", "12h"), a
+// number of days ("7d") or a bare number of hours ("48").
+func parseExpiresIn(value string, max time.Duration) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	var d time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid expires_in %q: use e.g. 1h, 7d or a number of hours", value)
+		}
+		d = time.Duration(n * float64(24*time.Hour))
+	} else if hours, err := strconv.ParseFloat(value, 64); err == nil {
+		d = time.Duration(hours * float64(time.Hour))
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("invalid expires_in %q: use e.g. 1h, 7d or a number of hours", value)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("expires_in must be positive")
+	}
+	if d > max {
+		return 0, fmt.Errorf("expires_in must be at most %s", max)
+	}
+	return d, nil
+}
+
+// uploadLifetimes returns the lifetime of each uploaded file from the
+// expires_in form values: none for the 24h default, one for all files or
+// one per file in upload order.
+func uploadLifetimes(values []string, count int, max time.Duration) ([]time.Duration, error) {
+	if len(values) > 1 && len(values) != count {
+		return nil, fmt.Errorf("send one expires_in for all files or exactly one per file (%d)", count)
+	}
+	lifetimes := make([]time.Duration, count)
+	for i := range lifetimes {
+		lifetimes[i] = defaultFileLifetime
+		var value string
+		if len(values) == 1 {
+			value = values[0]
+		} else if len(values) == count {
+			value = values[i]
+		}
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		d, err := parseExpiresIn(value, max)
+		if err != nil {
+			return nil, err
+		}
+		lifetimes[i] = d
+	}
+	return lifetimes, nil
+}
+
+// ExtendAllFiles pushes the expiry of every unexpired file of the caller
+// forward by {"duration": "72h"}, capped per file at its upload time plus
+// MAX_FILE_LIFETIME. Files already at the cap are left alone and not
+// counted.
+func (h *FileHandler) ExtendAllFiles(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Duration string `json:"duration" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 || d > h.maxFileLifetime {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be a positive duration such as 72h, at most " + h.maxFileLifetime.String()})
+		return
+	}
+
+	res, err := h.db.Exec(`
+		UPDATE files
+		SET expires_at = LEAST(expires_at + $1 * INTERVAL '1 second', created_at + $2 * INTERVAL '1 second'),
+		    updated_at = NOW()
+		WHERE user_id = $3 AND expires_at > NOW() AND deleted_at IS NULL AND expires_at < created_at + $2 * INTERVAL '1 second'`,
+		d.Seconds(), h.maxFileLifetime.Seconds(), userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to extend files")
+		return
+	}
+	updated, _ := res.RowsAffected()
+
+	c.JSON(http.StatusOK, gin.H{
+		"updated":           updated,
+		"max_file_lifetime": h.maxFileLifetime.String(),
+	})
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/middleware"
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+type exportedFile struct {
+	UUID          string            `json:"uuid"`
+	OriginalName  string            `json:"original_name"`
+	FileSize      int64             `json:"file_size"`
+	MimeType      string            `json:"mime_type"`
+	HasPassword   bool              `json:"has_password"`
+	IsEncrypted   bool              `json:"is_encrypted"`
+	DownloadCount int               `json:"download_count"`
+	ViewCount     int               `json:"view_count"`
+	RelativePath  *string           `json:"relative_path,omitempty"`
+	Metadata      map[string]string `json:"metadata"`
+	Tags          []string          `json:"tags"`
+	ExpiresAt     time.Time         `json:"expires_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	ScanStatus    string            `json:"scan_status"`
+	// ArchivePath locates the blob inside the export when files are included
+	ArchivePath string `json:"archive_path,omitempty"`
+
+	filePath string
+}
+
+type exportedDownload struct {
+	FileUUID     string    `json:"file_uuid"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// ExportData streams a ZIP with everything stored about the caller: account
+// details, file metadata, the download history of their files and, with
+// ?include_files=true, the file contents. Downloaders' IP addresses and user
+// agents are left out as they are personal data of third parties.
+func (h *AuthHandler) ExportData(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	includeFiles, _ := strconv.ParseBool(c.Query("include_files"))
+	compression, ok := parseZipCompression(c)
+	if !ok {
+		return
+	}
+
+	var account struct {
+		ID        int       `json:"id"`
+		Email     string    `json:"email"`
+		Role      string    `json:"role"`
+		IsAdmin   bool      `json:"is_admin"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT id, email, role, is_admin, created_at FROM users WHERE id = $1", userID).
+			Scan(&account.ID, &account.Email, &account.Role, &account.IsAdmin, &account.CreatedAt)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to load account")
+		return
+	}
+
+	files, err := h.exportFiles(userID)
+	if err != nil {
+		respondDBError(c, err, "Failed to load files")
+		return
+	}
+	downloads, err := h.exportDownloads(userID)
+	if err != nil {
+		respondDBError(c, err, "Failed to load download history")
+		return
+	}
+	if includeFiles {
+		for i := range files {
+			// Blobs found infected are gone, pending ones are not handed out
+			if files[i].ScanStatus == services.ScanClean {
+				files[i].ArchivePath = "files/" + files[i].UUID + "/" + files[i].OriginalName
+			}
+		}
+	}
+
+	// From here on the status is sent; failures can only cut the archive short
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="fileshare-export.zip"`)
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	err = writeZipJSON(zw, "account.json", account)
+	if err == nil {
+		err = writeZipJSON(zw, "files.json", files)
+	}
+	if err == nil {
+		err = writeZipJSON(zw, "downloads.json", downloads)
+	}
+	if err == nil && includeFiles {
+		for _, file := range files {
+			if file.ArchivePath == "" {
+				continue
+			}
+			if err = writeZipFile(zw, h.storage, file.ArchivePath, file.filePath, compression.method(file.MimeType)); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		logging.FromContext(c).Error("writing data export failed", "event", "export_failed", "error", err)
+	}
+}
+
+func (h *AuthHandler) exportFiles(userID int) ([]exportedFile, error) {
+	rows, err := h.db.QueryRetry(`
+		SELECT uuid, original_name, file_size, mime_type, password_hash IS NOT NULL,
+		       is_encrypted, download_count, view_count, relative_path, metadata,
+		       ARRAY(SELECT tag FROM file_tags WHERE file_id = files.id ORDER BY tag),
+		       expires_at, created_at, scan_status, file_path
+		FROM files
+		WHERE user_id = $1
+		ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []exportedFile{}
+	for rows.Next() {
+		var f exportedFile
+		var metadata []byte
+		err := rows.Scan(&f.UUID, &f.OriginalName, &f.FileSize, &f.MimeType, &f.HasPassword,
+			&f.IsEncrypted, &f.DownloadCount, &f.ViewCount, &f.RelativePath, &metadata,
+			(*pq.StringArray)(&f.Tags), &f.ExpiresAt, &f.CreatedAt, &f.ScanStatus, &f.filePath)
+		if err != nil {
+			return nil, err
+		}
+		f.Metadata = decodeMetadata(metadata)
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (h *AuthHandler) exportDownloads(userID int) ([]exportedDownload, error) {
+	rows, err := h.db.QueryRetry(`
+		SELECT f.uuid, d.downloaded_at
+		FROM downloads d
+		JOIN files f ON f.id = d.file_id
+		WHERE f.user_id = $1
+		ORDER BY d.downloaded_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	downloads := []exportedDownload{}
+	for rows.Next() {
+		var d exportedDownload
+		if err := rows.Scan(&d.FileUUID, &d.DownloadedAt); err != nil {
+			return nil, err
+		}
+		downloads = append(downloads, d)
+	}
+	return downloads, rows.Err()
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeZipFile copies a blob into the archive using the given method
+// (zip.Store or zip.Deflate).
+func writeZipFile(zw *zip.Writer, store storage.Storage, name, path string, method uint16) error {
+	src, err := store.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	header := &zip.FileHeader{Name: name, Method: method}
+	if blob, err := store.Stat(path); err == nil {
+		header.Modified = blob.ModifiedAt
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
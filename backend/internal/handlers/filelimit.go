@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/services"
+)
+
+// loadMaxActiveFiles reads MAX_ACTIVE_FILES, the default cap on unexpired
+// files per user. Unset or 0 means unlimited.
+func loadMaxActiveFiles() int {
+	n, err := strconv.Atoi(os.Getenv("MAX_ACTIVE_FILES"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// loadMaxFileSize reads MAX_FILE_SIZE, the largest single file in bytes an
+// upload may contain. Unset or 0 means unlimited.
+func loadMaxFileSize() int64 {
+	n, err := strconv.ParseInt(os.Getenv("MAX_FILE_SIZE"), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// activeFileUsage returns how many unexpired files outside the trash a
+// user has and the cap that applies to them, where 0 means unlimited. A
+// per-user override set by an admin takes precedence over def.
+func activeFileUsage(db *database.DB, userID, def int) (count, limit int, err error) {
+	var override *int
+	err = db.Retry(func() error {
+		return db.QueryRow(`
+			SELECT max_active_files,
+			       (SELECT COUNT(*) FROM files WHERE user_id = users.id AND expires_at > NOW() AND deleted_at IS NULL)
+			FROM users WHERE id = $1`,
+			userID,
+		).Scan(&override, &count)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	limit = def
+	if override != nil {
+		limit = *override
+	}
+	return count, limit, nil
+}
+
+// storedUpload is a file of an upload batch that has been written to disk
+// and recorded.
+type storedUpload struct {
+	id   int
+	path string
+}
+
+// discardUploads removes the blobs and rows of a batch's earlier files when
+// a later one fails, so a failed upload leaves nothing behind.
+func (h *FileHandler) discardUploads(logger *slog.Logger, uploads []storedUpload) {
+	for _, u := range uploads {
+		if err := h.history.DeleteFileRecord(u.id); err != nil {
+			logger.Error("discarding file of failed upload failed", "event", "upload_discard_failed", "file_id", u.id, "error", err)
+			continue
+		}
+		if err := services.ReleaseBlob(h.db, h.storage, u.id, u.path); err != nil {
+			logger.Error("deleting blob failed", "event", "blob_delete_failed", "path", u.path, "error", err)
+		}
+	}
+}
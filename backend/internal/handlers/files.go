@@ -2,8 +2,9 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,31 +13,101 @@ import (
 	"time"
 
 	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/metrics"
 	"file-sharing-backend/internal/middleware"
 	"file-sharing-backend/internal/models"
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type FileHandler struct {
-	db         *database.DB
-	uploadPath string
+	db          *database.DB
+	events      *services.EventBus
+	history     *services.DownloadHistory
+	images      *services.ImageVariantService
+	scanner     *services.ScanService
+	settings    *services.SettingsService
+	storage     storage.Storage
+	thumbnails  *services.ThumbnailService
+	views       *services.ViewCounter
+	inlineTypes []string
+	inline      inlineContent
+	duplicates  duplicateCheck
+	exif        exifReader
+
+	downloadRateLimit int64
+	// presignExpiry is how long presigned download URLs are valid, zero
+	// when downloads are streamed (S3_PRESIGN_DOWNLOADS)
+	presignExpiry time.Duration
+	// originalNames stores blobs under their sanitized original name
+	// instead of the file UUID (STORAGE_NAMING=original)
+	originalNames bool
+	// contentNames stores blobs under their SHA-256, so identical uploads
+	// share one blob (STORAGE_NAMING=content)
+	contentNames      bool
+	uploadTypes       uploadTypePolicy
+	shareCookies      shareCookies
+	signedLinks       signedLinks
+	codes             shareCodeSettings
+	slots             *downloadSlots
+	maxFilenameLength int
+	maxActiveFiles    int
+	maxFileSize       int64
+	maxFileLifetime   time.Duration
+	// trashRetention is how long deleted files can be restored
+	// (TRASH_RETENTION_DAYS)
+	trashRetention time.Duration
+	// protectInfo hides the details of password-protected files from
+	// GetFileInfo until the password is given (PROTECT_FILE_INFO)
+	protectInfo bool
+	// disableRedirect serves shares directly to browsers too, for
+	// deployments without a frontend (DISABLE_FRONTEND_REDIRECT)
+	disableRedirect bool
+	// blockUnverifiedOwners refuses downloads of files whose owner has not
+	// verified their email address (UNVERIFIED_OWNER_DOWNLOADS=block)
+	blockUnverifiedOwners bool
 }
 
-func NewFileHandler(db *database.DB) *FileHandler {
-	uploadPath := os.Getenv("UPLOAD_PATH")
-	if uploadPath == "" {
-		uploadPath = "./uploads"
-	}
-	
-	// Create upload directory if it doesn't exist
-	os.MkdirAll(uploadPath, 0755)
-	
+func NewFileHandler(db *database.DB, events *services.EventBus, history *services.DownloadHistory, images *services.ImageVariantService, scanner *services.ScanService, settings *services.SettingsService, store storage.Storage, thumbnails *services.ThumbnailService, views *services.ViewCounter) *FileHandler {
 	return &FileHandler{
-		db:         db,
-		uploadPath: uploadPath,
+		db:          db,
+		events:      events,
+		history:     history,
+		images:      images,
+		scanner:     scanner,
+		settings:    settings,
+		storage:     store,
+		thumbnails:  thumbnails,
+		views:       views,
+		inlineTypes: loadInlineTypes(),
+		inline:      loadInlineContent(),
+		duplicates:  loadDuplicateCheck(),
+		exif:        loadExifReader(),
+
+		downloadRateLimit: loadDownloadRateLimit(),
+		presignExpiry:     loadPresignExpiry(),
+		originalNames:     os.Getenv("STORAGE_NAMING") == "original",
+		contentNames:      os.Getenv("STORAGE_NAMING") == "content",
+		uploadTypes:       loadUploadTypePolicy(),
+		shareCookies:      loadShareCookies(),
+		signedLinks:       loadSignedLinks(),
+		codes:             loadShareCodeSettings(),
+		slots:             newDownloadSlots(),
+		maxFilenameLength: loadMaxFilenameLength(),
+		maxActiveFiles:    loadMaxActiveFiles(),
+		maxFileSize:       loadMaxFileSize(),
+		maxFileLifetime:   loadMaxFileLifetime(),
+		trashRetention:    services.TrashRetention(),
+		protectInfo:       os.Getenv("PROTECT_FILE_INFO") == "true",
+		disableRedirect:   os.Getenv("DISABLE_FRONTEND_REDIRECT") == "true",
+
+		blockUnverifiedOwners: os.Getenv("UNVERIFIED_OWNER_DOWNLOADS") == "block",
 	}
 }
 
@@ -47,93 +118,510 @@ func (h *FileHandler) UploadFiles(c *gin.Context) {
 		return
 	}
 
-	form, err := c.MultipartForm()
+	// Retried uploads carrying the same Idempotency-Key replay the original
+	// result instead of storing the files a second time
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+		return
+	}
+	if idempotencyKey != "" {
+		if h.replayIdempotentUpload(c, userID, idempotencyKey) {
+			return
+		}
+	}
+
+	// Files are streamed to staged blobs as they arrive and committed under
+	// their final names once the whole upload has been validated
+	form, err := h.readUploadForm(c.Request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
+		var tooLarge *http.MaxBytesError
+		var fileTooLarge *fileTooLargeError
+		switch {
+		case errors.As(err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit)})
+		case errors.As(err, &fileTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error":         fileTooLarge.Error(),
+				"file_name":     fileTooLarge.name,
+				"max_file_size": fileTooLarge.limit,
+			})
+		case errors.Is(err, errUploadStorage):
+			h.events.Publish("error", "Failed to save uploaded file", map[string]interface{}{
+				"error": err.Error(),
+			})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
+		}
 		return
 	}
+	defer form.discard()
 
-	files := form.File["files"]
+	h.storeUploads(c, userID, idempotencyKey, form)
+}
+
+// storeUploads validates the files of a parsed upload form and stores them
+// with their rows, answering the request either way. It reports whether the
+// files were stored.
+func (h *FileHandler) storeUploads(c *gin.Context, userID int, idempotencyKey string, form *uploadForm) bool {
+	files := form.files
 	if len(files) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded"})
-		return
+		return false
+	}
+
+	// Cap the number of unexpired files per user
+	activeFiles, maxActiveFiles, err := activeFileUsage(h.db, userID, h.maxActiveFiles)
+	if err != nil {
+		respondDBError(c, err, "Failed to check file limit")
+		return false
+	}
+	if maxActiveFiles > 0 && activeFiles+len(files) > maxActiveFiles {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            fmt.Sprintf("You can have at most %d active files; delete some or wait for them to expire", maxActiveFiles),
+			"active_files":     activeFiles,
+			"max_active_files": maxActiveFiles,
+		})
+		return false
+	}
+
+	// Overlong names would overflow original_name and break headers
+	for _, file := range files {
+		name, err := normalizeFilename(file.Filename, h.maxFilenameLength)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		file.Filename = name
+	}
+
+	// File types are judged by their content, not by what the client claims
+	for _, file := range files {
+		if !h.uploadTypes.permits(file.Filename, file.ContentType, form.value("encrypted") == "true") {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{
+				"error":         fmt.Sprintf("%s is of a type that may not be uploaded", file.Filename),
+				"file_name":     file.Filename,
+				"detected_type": file.ContentType,
+			})
+			return false
+		}
+	}
+
+	// Folder uploads send one relative path per file, in the same order
+	relativePaths, err := folderRelativePaths(form.values["relative_paths"], len(files))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	// Each file lives 24h unless expires_in asks otherwise
+	lifetimes, err := uploadLifetimes(form.values["expires_in"], len(files), h.maxFileLifetime)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	var folderUUID *string
+	if relativePaths != nil {
+		id := uuid.New().String()
+		folderUUID = &id
+	}
+
+	// An album groups the uploaded files behind one share link
+	album := form.value("album") == "true"
+	albumTitle := strings.TrimSpace(form.value("album_title"))
+	if !validAlbumTitle(albumTitle) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("album_title must be at most %d characters", maxAlbumTitleLength)})
+		return false
+	}
+
+	// A slug makes a single file reachable at /share/:slug as well as by UUID
+	slug, err := parseShareSlug(form.value("slug"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if slug != nil {
+		if len(files) > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slug can only be set when uploading a single file"})
+			return false
+		}
+		taken, err := h.slugTaken(*slug)
+		if err != nil {
+			respondDBError(c, err, "Failed to check slug")
+			return false
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "Slug is already taken", "slug": *slug})
+			return false
+		}
+	}
+
+	// Warn before storing a large file the user just uploaded, unless the
+	// client has already confirmed the upload
+	if form.value("confirm_duplicate") != "true" {
+		duplicates, err := h.findRecentDuplicates(c, userID, files)
+		if err != nil {
+			respondDBError(c, err, "Failed to check for duplicate uploads")
+			return false
+		}
+		if len(duplicates) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      "A file with the same name and size was uploaded recently; resend with confirm_duplicate=true to upload anyway",
+				"duplicates": duplicates,
+			})
+			return false
+		}
+	}
+
+	password := form.value("password")
+	encrypted := form.value("encrypted") == "true"
+
+	// Admins can require every new share to be protected; an encrypted
+	// upload is protected by its key verifier instead of a password
+	requirePassword, err := h.settings.RequireSharePassword()
+	if err != nil {
+		respondDBError(c, err, "Failed to load share settings")
+		return false
+	}
+	if requirePassword && password == "" && !encrypted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A password is required for all shares"})
+		return false
 	}
 
-	password := c.PostForm("password")
 	var passwordHash *string
 	if password != "" {
-		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
-			return
+		hashStr, ok := h.hashSharePassword(c, password)
+		if !ok {
+			return false
 		}
-		hashStr := string(hashedPwd)
 		passwordHash = &hashStr
 	}
 
+	// Zero-knowledge uploads arrive already encrypted; the server only keeps
+	// the KDF parameters and a hash of the key verifier (see encryption.go)
+	var encryptionParams, keyVerifierHash *string
+	if encrypted {
+		if password != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted uploads must not send the password to the server"})
+			return false
+		}
+		params := form.value("encryption_params")
+		verifierHash, err := hashKeyVerifier(params, form.value("key_verifier"))
+		if err != nil {
+			if err == errInvalidEncryptionParams || err == errInvalidKeyVerifier {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash key verifier"})
+			}
+			return false
+		}
+		encryptionParams = &params
+		keyVerifierHash = &verifierHash
+	}
+
 	var responses []models.UploadResponse
-	expiresAt := time.Now().Add(24 * time.Hour)
+	uploadedAt := time.Now()
+
+	// Optionally stop serving downloads before the file itself expires
+	var downloadEnabledUntil *time.Time
+	if v := form.value("download_enabled_for"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "download_enabled_for must be a positive duration such as 24h"})
+			return false
+		}
+		until := time.Now().Add(d)
+		downloadEnabledUntil = &until
+	}
+
+	// Listed files also appear on the owner's public profile
+	publicListed := form.value("public_listed") == "true"
+
+	maxConcurrentDownloads, ok := parseMaxConcurrentDownloads(form.value("max_concurrent_downloads"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_concurrent_downloads must be a positive integer"})
+		return false
+	}
+
+	// Files can go away after a number of downloads; 0 means unlimited
+	var maxDownloads *int
+	if v := form.value("max_downloads"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_downloads must be a non-negative integer"})
+			return false
+		}
+		if n > 0 {
+			maxDownloads = &n
+		}
+	}
+
+	// One-time files are deleted right after their single download
+	oneTime := form.value("one_time") == "true"
+	if oneTime {
+		if maxDownloads != nil && *maxDownloads != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "one_time files allow exactly one download"})
+			return false
+		}
+		one := 1
+		maxDownloads = &one
+	}
+
+	var keyArg *string
+	if idempotencyKey != "" {
+		keyArg = &idempotencyKey
+	}
+
+	// Nothing is stored if any file is infected; ciphertext cannot be scanned
+	scanStatus := services.ScanClean
+	if h.scanner.Async() && !encrypted {
+		scanStatus = services.ScanPending
+	} else if h.scanner.Enabled() && !encrypted {
+		if !h.scanUploads(c, files) {
+			return false
+		}
+	}
+
+	var albumID *int
+	var albumUUID string
+	if album {
+		id, u, err := h.createAlbum(userID, albumTitle)
+		if err != nil {
+			respondDBError(c, err, "Failed to create album")
+			return false
+		}
+		albumID, albumUUID = &id, u
+	}
+
+	// Files of this batch stored so far, removed again if a later one fails
+	var stored []storedUpload
+
+	for i, file := range files {
+		expiresAt := uploadedAt.Add(lifetimes[i])
 
-	for _, file := range files {
 		// Generate UUID for file
 		fileUUID := uuid.New().String()
-		
-		// Create file path
+
+		// Create file name; original names get a "-N" suffix on collision
 		ext := filepath.Ext(file.Filename)
 		fileName := fileUUID + ext
-		filePath := filepath.Join(h.uploadPath, fileName)
+		collision := storage.CollisionError
+		if h.originalNames {
+			fileName = storage.SanitizeName(file.Filename)
+			collision = storage.CollisionSuffix
+		}
 
-		// Save file to disk
-		src, err := file.Open()
+		// The blob and the row referencing it are committed together
+		tx, err := h.db.Begin()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file"})
-			return
+			h.discardUploads(logging.FromContext(c), stored)
+			respondDBError(c, err, "Failed to save file info")
+			return false
 		}
-		defer src.Close()
 
-		dst, err := os.Create(filePath)
+		// Move the staged blob into place, never overwriting an existing blob
+		filePath, reused, err := h.commitBlob(tx, file, fileName, collision)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file on server"})
-			return
+			tx.Rollback()
+			h.discardUploads(logging.FromContext(c), stored)
+			logging.FromContext(c).Error("saving uploaded file failed", "event", "upload_failed", "error", err)
+			h.events.Publish("error", "Failed to save uploaded file", map[string]interface{}{
+				"file_name": file.Filename,
+				"error":     err.Error(),
+			})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+			return false
 		}
-		defer dst.Close()
 
-		if _, err := io.Copy(dst, src); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-			return
+		// Ciphertext has no meaningful type of its own
+		mimeType := file.ContentType
+		if encrypted {
+			mimeType = "application/octet-stream"
+		}
+
+		var relativePath *string
+		if relativePaths != nil {
+			relativePath = &relativePaths[i]
 		}
 
 		// Save file info to database
 		var fileID int
-		err = h.db.QueryRow(`
-			INSERT INTO files (uuid, user_id, original_name, file_path, file_size, mime_type, password_hash, expires_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		err = tx.QueryRow(`
+			INSERT INTO files (uuid, user_id, original_name, file_path, file_size, mime_type, password_hash, expires_at, idempotency_key, upload_index,
+			                   is_encrypted, encryption_params, key_verifier_hash, folder_uuid, relative_path, album_id,
+			                   download_enabled_until, public_listed, max_concurrent_downloads, max_downloads, checksum,
+			                   one_time, scan_status, slug)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
 			RETURNING id`,
-			fileUUID, userID, file.Filename, filePath, file.Size, file.Header.Get("Content-Type"), passwordHash, expiresAt,
+			fileUUID, userID, file.Filename, filePath, file.Size, mimeType, passwordHash, expiresAt, keyArg, i,
+			encrypted, encryptionParams, keyVerifierHash, folderUUID, relativePath, albumID,
+			downloadEnabledUntil, publicListed, maxConcurrentDownloads, maxDownloads, file.Checksum,
+			oneTime, scanStatus, slug,
 		).Scan(&fileID)
+		if err == nil {
+			err = tx.Commit()
+		}
 
 		if err != nil {
-			os.Remove(filePath) // Clean up file if database insert fails
+			// Clean up file if database insert fails, unless it is shared.
+			// The transaction still holds the blob's lock, so no other upload
+			// can pick the blob up before it is gone
+			if !reused {
+				h.storage.Delete(filePath)
+			}
+			tx.Rollback()
+			h.discardUploads(logging.FromContext(c), stored)
+			// A concurrent request with the same key won the race
+			if keyArg != nil && isUniqueViolation(err) && h.replayIdempotentUpload(c, userID, idempotencyKey) {
+				return true
+			}
+			if slug != nil && isSlugViolation(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Slug is already taken", "slug": *slug})
+				return false
+			}
+			logging.FromContext(c).Error("saving file info failed", "event", "upload_failed", "error", err)
+			h.events.Publish("error", "Failed to save file info", map[string]interface{}{
+				"file_name": file.Filename,
+				"error":     err.Error(),
+			})
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file info"})
-			return
+			return false
+		}
+
+		stored = append(stored, storedUpload{id: fileID, path: filePath})
+		if scanStatus == services.ScanPending {
+			h.scanner.Enqueue(fileID)
+		}
+
+		// Variants are named after the blob, so a reused blob's variants stay
+		// with the file that stored it first
+		if !encrypted && !reused {
+			h.images.Enqueue(fileID, filePath, mimeType)
+		}
+		if !encrypted {
+			h.thumbnails.Enqueue(fileID, mimeType)
 		}
 
-		shareURL := fmt.Sprintf("/share/%s", fileUUID)
-		
-		responses = append(responses, models.UploadResponse{
+		metrics.Uploads.Inc()
+		logging.FromContext(c).Info("file uploaded", "event", "upload", "file_uuid", fileUUID, "file_size", file.Size)
+		h.events.Publish("upload", "File uploaded", map[string]interface{}{
+			"file_uuid": fileUUID,
+			"file_name": file.Filename,
+			"file_size": file.Size,
+			"user_id":   userID,
+		})
+
+		shareURL := middleware.ExternalURL(c, "/share/"+fileUUID)
+
+		resp := models.UploadResponse{
 			UUID:        fileUUID,
 			ShareURL:    shareURL,
 			FileName:    file.Filename,
 			FileSize:    file.Size,
 			ExpiresAt:   expiresAt,
 			HasPassword: passwordHash != nil,
-		})
+			IsEncrypted: encrypted,
+			Checksum:    file.Checksum,
+		}
+		if relativePath != nil {
+			resp.RelativePath = *relativePath
+		}
+		if slug != nil {
+			resp.Slug = *slug
+			resp.SlugURL = middleware.ExternalURL(c, "/share/"+*slug)
+		}
+		responses = append(responses, resp)
+	}
+
+	result := gin.H{
+		"message": "Files uploaded successfully",
+		"files":   responses,
+	}
+	if folderUUID != nil {
+		result["folder_uuid"] = *folderUUID
+		result["folder_url"] = middleware.ExternalURL(c, "/api/folders/"+*folderUUID)
+	}
+	if albumID != nil {
+		result["album_uuid"] = albumUUID
+		result["album_url"] = middleware.ExternalURL(c, "/album/"+albumUUID)
+	}
+	c.JSON(http.StatusOK, result)
+	return true
+}
+
+// replayIdempotentUpload responds with the files previously stored under
+// key for the user, reporting whether there were any to replay.
+func (h *FileHandler) replayIdempotentUpload(c *gin.Context, userID int, key string) bool {
+	rows, err := h.db.QueryRetry(`
+		SELECT uuid, original_name, file_size, expires_at, password_hash IS NOT NULL, is_encrypted, COALESCE(checksum, ''), COALESCE(slug, '')
+		FROM files
+		WHERE user_id = $1 AND idempotency_key = $2
+		ORDER BY upload_index`,
+		userID, key,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to look up previous upload")
+		return true
+	}
+	defer rows.Close()
+
+	var responses []models.UploadResponse
+	for rows.Next() {
+		var resp models.UploadResponse
+		if err := rows.Scan(&resp.UUID, &resp.FileName, &resp.FileSize, &resp.ExpiresAt, &resp.HasPassword, &resp.IsEncrypted, &resp.Checksum, &resp.Slug); err != nil {
+			continue
+		}
+		resp.ShareURL = middleware.ExternalURL(c, "/share/"+resp.UUID)
+		if resp.Slug != "" {
+			resp.SlugURL = middleware.ExternalURL(c, "/share/"+resp.Slug)
+		}
+		responses = append(responses, resp)
+	}
+	if len(responses) == 0 {
+		return false
 	}
 
+	c.Header("Idempotent-Replayed", "true")
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Files uploaded successfully",
 		"files":   responses,
 	})
+	return true
+}
+
+// userFileSortColumns maps the sort values GetUserFiles accepts to columns.
+var userFileSortColumns = map[string]string{
+	"created_at":     "created_at",
+	"name":           "original_name",
+	"original_name":  "original_name",
+	"size":           "file_size",
+	"file_size":      "file_size",
+	"downloads":      "download_count",
+	"download_count": "download_count",
+	"expires_at":     "expires_at",
+}
+
+const (
+	defaultFileListLimit = 50
+	maxFileListLimit     = 200
+)
+
+// parseFileListPage reads the limit and offset of a file listing, answering
+// 400 itself when they are invalid.
+func parseFileListPage(c *gin.Context) (limit, offset int, ok bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultFileListLimit)))
+	if err != nil || limit < 1 || limit > maxFileListLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxFileListLimit)})
+		return 0, 0, false
+	}
+	offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+		return 0, 0, false
+	}
+	return limit, offset, true
 }
 
 func (h *FileHandler) GetUserFiles(c *gin.Context) {
@@ -143,17 +631,106 @@ func (h *FileHandler) GetUserFiles(c *gin.Context) {
 		return
 	}
 
-	rows, err := h.db.Query(`
+	// Sorting: only allowlisted columns ever reach the SQL text
+	sortParam := c.DefaultQuery("sort", "created_at")
+	sortColumn, ok := userFileSortColumns[sortParam]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort field"})
+		return
+	}
+	order := strings.ToLower(c.DefaultQuery("order", "desc"))
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort order, use asc or desc"})
+		return
+	}
+
+	limit, offset, ok := parseFileListPage(c)
+	if !ok {
+		return
+	}
+
+	// Filtering; files in the trash are only listed on their own
+	conditions := []string{"user_id = $1", "deleted_at IS NULL"}
+	args := []interface{}{userID}
+	if v := c.Query("trashed"); v != "" {
+		trashed, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trashed filter"})
+			return
+		}
+		if trashed {
+			conditions[1] = "deleted_at IS NOT NULL"
+		}
+	}
+	if v := c.Query("has_password"); v != "" {
+		hasPassword, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid has_password filter"})
+			return
+		}
+		if hasPassword {
+			conditions = append(conditions, "password_hash IS NOT NULL")
+		} else {
+			conditions = append(conditions, "password_hash IS NULL")
+		}
+	}
+	if v := c.Query("expired"); v != "" {
+		expired, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expired filter"})
+			return
+		}
+		if expired {
+			conditions = append(conditions, "expires_at <= NOW()")
+		} else {
+			conditions = append(conditions, "expires_at > NOW()")
+		}
+	}
+	if v := c.Query("tag"); v != "" {
+		tag, err := normalizeTag(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		args = append(args, tag)
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT file_id FROM file_tags WHERE tag = $%d)", len(args)))
+	}
+	if key := c.Query("metadata_key"); key != "" {
+		args = append(args, key)
+		if value, ok := c.GetQuery("metadata_value"); ok {
+			args = append(args, value)
+			conditions = append(conditions, fmt.Sprintf("metadata ->> $%d = $%d", len(args)-1, len(args)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("metadata ? $%d", len(args)))
+		}
+	}
+
+	where := strings.Join(conditions, " AND ")
+	reader := h.db.Reader()
+
+	var total int
+	err = reader.Retry(func() error {
+		return reader.QueryRow("SELECT COUNT(*) FROM files WHERE "+where, args...).Scan(&total)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch files")
+		return
+	}
+
+	rows, err := reader.QueryRetry(`
 		SELECT id, uuid, original_name, file_size, mime_type, 
 		       password_hash IS NOT NULL as has_password,
-		       download_count, expires_at, created_at
+		       download_count, view_count, expires_at, created_at, metadata,
+		       download_enabled_until, public_listed, deleted_at, disabled_at, thumbnail_status,
+		       ARRAY(SELECT tag FROM file_tags WHERE file_id = files.id ORDER BY tag) AS tags
 		FROM files 
-		WHERE user_id = $1 
-		ORDER BY created_at DESC`,
-		userID,
+		WHERE `+where+`
+		ORDER BY `+sortColumn+` `+order+`, id `+order+fmt.Sprintf(`
+		LIMIT %d OFFSET %d`, limit, offset),
+		args...,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		respondDBError(c, err, "Failed to fetch files")
 		return
 	}
 	defer rows.Close()
@@ -161,20 +738,29 @@ func (h *FileHandler) GetUserFiles(c *gin.Context) {
 	var files []models.File
 	for rows.Next() {
 		var file models.File
+		var metadata []byte
 		err := rows.Scan(
 			&file.ID, &file.UUID, &file.OriginalName, &file.FileSize,
-			&file.MimeType, &file.HasPassword, &file.DownloadCount,
-			&file.ExpiresAt, &file.CreatedAt,
+			&file.MimeType, &file.HasPassword, &file.DownloadCount, &file.ViewCount,
+			&file.ExpiresAt, &file.CreatedAt, &metadata, &file.DownloadEnabledUntil,
+			&file.PublicListed, &file.DeletedAt, &file.DisabledAt, &file.ThumbnailStatus, (*pq.StringArray)(&file.Tags),
 		)
 		if err != nil {
 			continue
 		}
-		
+
+		file.Metadata = decodeMetadata(metadata)
 		file.IsExpired = time.Now().After(file.ExpiresAt)
 		files = append(files, file)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"files": files})
+	c.JSON(http.StatusOK, gin.H{
+		"files":  files,
+		"sort":   gin.H{"field": sortParam, "order": order},
+		"limit":  limit,
+		"offset": offset,
+		"total":  total,
+	})
 }
 
 func (h *FileHandler) DeleteFile(c *gin.Context) {
@@ -191,18 +777,20 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 	}
 
 	var file models.File
-	err = h.db.QueryRow(`
-		SELECT id, file_path, user_id 
-		FROM files 
-		WHERE uuid = $1`,
-		fileUUID,
-	).Scan(&file.ID, &file.FilePath, &file.UserID)
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT id, file_path, user_id 
+			FROM files 
+			WHERE uuid = $1 AND deleted_at IS NULL`,
+			fileUUID,
+		).Scan(&file.ID, &file.FilePath, &file.UserID)
+	})
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			respondDBError(c, err, "Database error")
 		}
 		return
 	}
@@ -213,20 +801,38 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	// Delete file from filesystem
-	if err := os.Remove(file.FilePath); err != nil {
-		// Log error but continue with database deletion
-		fmt.Printf("Warning: Failed to delete file from filesystem: %v\n", err)
+	// With If-Match the file is only deleted if it is still the version the
+	// client has seen
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		digest, err := h.cachedFileDigest(file.ID, file.FilePath, defaultDigestBlockSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute file digest"})
+			return
+		}
+		etag := fileETag(digest.Digest)
+		c.Header("ETag", etag)
+		if !etagMatches(ifMatch, etag) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "File has changed since it was last retrieved"})
+			return
+		}
 	}
 
-	// Delete file record from database
-	_, err = h.db.Exec("DELETE FROM files WHERE id = $1", file.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file record"})
+	// The file goes to the trash; the cleanup job removes its blob and row
+	// once it can no longer be restored
+	if _, err := services.TrashFiles(h.db, []int{file.ID}); err != nil {
+		respondDBError(c, err, "Failed to delete file")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+	h.events.Publish("delete", "File deleted by owner", map[string]interface{}{
+		"file_uuid": fileUUID,
+		"user_id":   userID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "File moved to trash",
+		"restorable_until": time.Now().Add(h.trashRetention),
+	})
 }
 
 func (h *FileHandler) GetFileInfo(c *gin.Context) {
@@ -235,24 +841,35 @@ func (h *FileHandler) GetFileInfo(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File UUID is required"})
 		return
 	}
+	fileUUID, err := h.resolveShareID(fileUUID)
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
 
 	var file models.File
-	err := h.db.QueryRow(`
-		SELECT id, original_name, file_size, mime_type, 
-		       password_hash IS NOT NULL as has_password, 
-		       expires_at, download_count, created_at
-		FROM files 
-		WHERE uuid = $1`,
-		fileUUID,
-	).Scan(&file.ID, &file.OriginalName, &file.FileSize, 
-		   &file.MimeType, &file.HasPassword, &file.ExpiresAt, 
-		   &file.DownloadCount, &file.CreatedAt)
+	err = h.db.RetryRead(func(db *database.DB) error {
+		return db.QueryRow(`
+			SELECT id, original_name, file_size, mime_type, 
+			       password_hash, 
+			       expires_at, download_count, created_at,
+			       is_encrypted, encryption_params, view_count, download_enabled_until, max_downloads,
+			       checksum, one_time, scan_status, slug, disabled_at
+			FROM files 
+			WHERE uuid = $1 AND deleted_at IS NULL`,
+			fileUUID,
+		).Scan(&file.ID, &file.OriginalName, &file.FileSize,
+			&file.MimeType, &file.PasswordHash, &file.ExpiresAt,
+			&file.DownloadCount, &file.CreatedAt,
+			&file.IsEncrypted, &file.EncryptionParams, &file.ViewCount, &file.DownloadEnabledUntil,
+			&file.MaxDownloads, &file.Checksum, &file.OneTime, &file.ScanStatus, &file.Slug, &file.DisabledAt)
+	})
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			respondDBError(c, err, "Database error")
 		}
 		return
 	}
@@ -262,21 +879,60 @@ func (h *FileHandler) GetFileInfo(c *gin.Context) {
 		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
 		return
 	}
+	if downloadsExhausted(&file) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has reached its download limit"})
+		return
+	}
+	if file.DisabledAt != nil {
+		c.JSON(http.StatusGone, errShareDisabled)
+		return
+	}
 
 	file.IsExpired = time.Now().After(file.ExpiresAt)
+	file.HasPassword = file.PasswordHash != nil
 
-	c.JSON(http.StatusOK, gin.H{
-		"file": gin.H{
-			"original_name":  file.OriginalName,
-			"file_size":      file.FileSize,
-			"mime_type":      file.MimeType,
-			"has_password":   file.HasPassword,
-			"download_count": file.DownloadCount,
-			"expires_at":     file.ExpiresAt,
-			"created_at":     file.CreatedAt,
-			"is_expired":     file.IsExpired,
-		},
-	})
+	// Optionally reveal details of protected files only to password holders
+	// or browsers that unlocked the share; the landing page can use the gate
+	// endpoint before that
+	if h.protectInfo && file.HasPassword && !h.shareCookies.valid(c, fileUUID, *file.PasswordHash) &&
+		!checkSharePassword(file.PasswordHash, c.Query("password")) {
+		c.JSON(http.StatusUnauthorized, errSharePassword)
+		return
+	}
+
+	// The info endpoint backs the share landing page, so a hit is a view
+	if h.views.Record(file.ID, c.ClientIP()) {
+		file.ViewCount++
+	}
+
+	info := gin.H{
+		"uuid":           fileUUID,
+		"slug":           file.Slug,
+		"original_name":  file.OriginalName,
+		"file_size":      file.FileSize,
+		"mime_type":      file.MimeType,
+		"has_password":   file.HasPassword,
+		"download_count": file.DownloadCount,
+		"view_count":     file.ViewCount,
+		"expires_at":     file.ExpiresAt,
+		"created_at":     file.CreatedAt,
+		"is_expired":     file.IsExpired,
+		"is_encrypted":   file.IsEncrypted,
+
+		"download_enabled_until": file.DownloadEnabledUntil,
+		"download_enabled":       downloadEnabled(&file),
+		"max_downloads":          file.MaxDownloads,
+		"downloads_remaining":    downloadsRemaining(&file),
+		"checksum":               file.Checksum,
+		"one_time":               file.OneTime,
+		"scan_status":            file.ScanStatus,
+	}
+	// Recipients need the KDF parameters to derive the key before downloading
+	if file.IsEncrypted && file.EncryptionParams != nil {
+		info["encryption_params"] = json.RawMessage(*file.EncryptionParams)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file": info})
 }
 
 func (h *FileHandler) GetFile(c *gin.Context) {
@@ -285,94 +941,283 @@ func (h *FileHandler) GetFile(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File UUID is required"})
 		return
 	}
+	fileUUID, err := h.resolveShareID(fileUUID)
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
 
+	h.serveShare(c, fileUUID)
+}
+
+// serveShare sends browsers to the frontend share page and serves the file
+// to everyone else.
+func (h *FileHandler) serveShare(c *gin.Context, fileUUID string) {
 	// ALWAYS redirect browser requests to frontend first
 	acceptHeader := c.GetHeader("Accept")
 	userAgent := c.GetHeader("User-Agent")
-	
+
 	// Check if this is a browser request (not an API call)
 	isBrowserRequest := strings.Contains(acceptHeader, "text/html") || strings.Contains(userAgent, "Mozilla")
-	
-	// If browser request and no password query param, redirect to frontend
-	if isBrowserRequest && c.Query("password") == "" {
+
+	// If browser request without a password, key verifier or share cookie, redirect to frontend
+	keyVerifier := c.GetHeader("X-Key-Verifier")
+	if !h.disableRedirect && isBrowserRequest && c.Query("password") == "" && keyVerifier == "" && !h.shareCookies.present(c, fileUUID) {
 		// Get the frontend URL from environment or use default
 		frontendURL := os.Getenv("FRONTEND_URL")
 		if frontendURL == "" {
 			frontendURL = "http://localhost:3000"
 		}
 		redirectURL := fmt.Sprintf("%s/share/%s", frontendURL, fileUUID)
-		fmt.Printf("Redirecting browser to: %s\n", redirectURL)
+		logging.FromContext(c).Debug("redirecting browser to frontend", "event", "share_redirect", "file_uuid", fileUUID)
 		c.Redirect(http.StatusFound, redirectURL)
 		return
 	}
 
+	h.serveDownload(c, fileUUID)
+}
+
+// loadDownloadableFile looks up a file for download and enforces expiry,
+// password and key verifier checks. On failure the response has been
+// written and ok is false.
+func (h *FileHandler) loadDownloadableFile(c *gin.Context, fileUUID string) (*models.File, bool) {
+	keyVerifier := c.GetHeader("X-Key-Verifier")
+
 	var file models.File
-	err := h.db.QueryRow(`
-		SELECT id, original_name, file_path, file_size, mime_type, 
-		       password_hash, expires_at, download_count
-		FROM files 
-		WHERE uuid = $1`,
-		fileUUID,
-	).Scan(&file.ID, &file.OriginalName, &file.FilePath, &file.FileSize, 
-		   &file.MimeType, &file.PasswordHash, &file.ExpiresAt, &file.DownloadCount)
+	var ownerVerified bool
+	err := h.db.RetryRead(func(db *database.DB) error {
+		return db.QueryRow(`
+			SELECT id, original_name, file_path, file_size, mime_type, 
+			       password_hash, expires_at, download_count,
+			       is_encrypted, key_verifier_hash, download_rate_limit, download_enabled_until,
+			       max_concurrent_downloads, max_downloads, checksum, one_time, scan_status, disabled_at,
+			       COALESCE((SELECT email_verified FROM users WHERE id = files.user_id), TRUE)
+			FROM files 
+			WHERE uuid = $1 AND deleted_at IS NULL`,
+			fileUUID,
+		).Scan(&file.ID, &file.OriginalName, &file.FilePath, &file.FileSize,
+			&file.MimeType, &file.PasswordHash, &file.ExpiresAt, &file.DownloadCount,
+			&file.IsEncrypted, &file.KeyVerifierHash, &file.DownloadRateLimit, &file.DownloadEnabledUntil,
+			&file.MaxConcurrentDownloads, &file.MaxDownloads, &file.Checksum, &file.OneTime, &file.ScanStatus, &file.DisabledAt,
+			&ownerVerified)
+	})
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			respondDBError(c, err, "Database error")
 		}
-		return
+		return nil, false
 	}
 
 	// Check if file is expired
 	if time.Now().After(file.ExpiresAt) {
 		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
-		return
+		return nil, false
+	}
+	if downloadsExhausted(&file) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has reached its download limit"})
+		return nil, false
+	}
+	if file.DisabledAt != nil {
+		c.JSON(http.StatusGone, errShareDisabled)
+		return nil, false
+	}
+	if h.blockUnverifiedOwners && !ownerVerified {
+		c.JSON(http.StatusForbidden, errUnverifiedOwner)
+		return nil, false
+	}
+
+	// Only files the malware scanner has cleared are served
+	if !scanCleared(c, &file) {
+		return nil, false
 	}
 
-	// Check if password is required
-	if file.PasswordHash != nil {
-		password := c.Query("password")
-		if password == "" {
+	// The owner may have closed the share while keeping the file
+	if !downloadEnabled(&file) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                  "Downloads are disabled for this file",
+			"download_enabled_until": file.DownloadEnabledUntil,
+		})
+		return nil, false
+	}
+
+	// Check the password; a valid share cookie stands in for it. Missing and
+	// wrong passwords get the same answer after the same amount of work
+	if file.PasswordHash == nil || !h.shareCookies.valid(c, fileUUID, *file.PasswordHash) {
+		if !checkSharePassword(file.PasswordHash, c.Query("password")) {
+			c.JSON(http.StatusUnauthorized, errSharePassword)
+			return nil, false
+		}
+		if file.PasswordHash != nil {
+			h.shareCookies.set(c, fileUUID, *file.PasswordHash)
+		}
+	}
+
+	// Encrypted files are released only to clients that derived the right key
+	if file.IsEncrypted && file.KeyVerifierHash != nil {
+		if keyVerifier == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":            "Password required",
-				"password_required": true,
+				"error":             "Key verifier required",
+				"verifier_required": true,
 			})
-			return
+			return nil, false
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(*file.PasswordHash), []byte(password))
+		err = bcrypt.CompareHashAndPassword([]byte(*file.KeyVerifierHash), []byte(keyVerifier))
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid key verifier"})
+			return nil, false
+		}
+	}
+
+	return &file, true
+}
+
+// serveDownload streams a downloadable file to the client and records the
+// download.
+func (h *FileHandler) serveDownload(c *gin.Context, fileUUID string) {
+	userAgent := c.GetHeader("User-Agent")
+
+	file, ok := h.loadDownloadableFile(c, fileUUID)
+	if !ok {
+		return
+	}
+
+	// Inline views are only rendered on the sandbox origin, if there is one
+	inline := !file.IsEncrypted && h.serveInline(file.MimeType, c.Query("inline"))
+	if inline && h.inline.redirect(c) {
+		return
+	}
+
+	// The slot is held until the handler returns, which includes the
+	// client going away mid-transfer
+	if limit := file.MaxConcurrentDownloads; limit != nil {
+		if !h.slots.acquire(file.ID, *limit) {
+			c.Header("Retry-After", strconv.Itoa(downloadRetryAfter))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many downloads of this file in progress, try again shortly"})
 			return
 		}
+		defer h.slots.release(file.ID)
 	}
 
-	// Increment download count
-	_, err = h.db.Exec("UPDATE files SET download_count = download_count + 1 WHERE id = $1", file.ID)
+	// Increment download count; the cap is checked again in the same
+	// statement so concurrent downloads cannot exceed it
+	res, err := h.db.Exec(`
+		UPDATE files SET download_count = download_count + 1
+		WHERE id = $1 AND (max_downloads IS NULL OR download_count < max_downloads)`,
+		file.ID,
+	)
 	if err != nil {
-		fmt.Printf("Warning: Failed to increment download count: %v\n", err)
+		// A one-time file must not be served without winning the gate
+		if file.OneTime {
+			respondDBError(c, err, "Failed to record download")
+			return
+		}
+		logging.FromContext(c).Warn("incrementing download count failed", "event", "download_count_failed", "file_uuid", fileUUID, "error", err)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "File has reached its download limit"})
+		return
+	}
+	if file.OneTime {
+		defer h.burnFile(logging.FromContext(c), file.ID, file.FilePath, fileUUID)
 	}
 
 	// Log download
 	clientIP := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
 	_, err = h.db.Exec(`
 		INSERT INTO downloads (file_id, ip_address, user_agent) 
 		VALUES ($1, $2, $3)`,
 		file.ID, clientIP, userAgent,
 	)
 	if err != nil {
-		fmt.Printf("Warning: Failed to log download: %v\n", err)
+		logging.FromContext(c).Warn("recording download failed", "event", "download_log_failed", "file_uuid", fileUUID, "error", err)
 	}
 
+	rateLimit := h.effectiveRateLimit(file)
+	metrics.Downloads.Inc()
+	logging.FromContext(c).Info("file downloaded", "event", "download", "file_uuid", fileUUID, "client_ip", clientIP)
+	h.events.Publish("download", "File downloaded", map[string]interface{}{
+		"file_uuid":  fileUUID,
+		"ip_address": clientIP,
+		"rate_limit": rateLimit,
+	})
+
 	// Serve file
+	defer metrics.ObserveDownload(c.Writer)
+	originalPath := file.FilePath
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", "attachment; filename="+file.OriginalName)
-	c.Header("Content-Type", "application/octet-stream")
+	if inline {
+		// Inline images may be swapped for a smaller variant the client accepts
+		if h.images.Enabled() {
+			c.Header("Vary", "Accept")
+			h.selectImageVariant(c, file)
+		}
+		c.Header("Content-Disposition", contentDisposition("inline", file.OriginalName))
+		c.Header("Content-Type", file.MimeType)
+		if h.inline.csp != "" {
+			c.Header("Content-Security-Policy", h.inline.csp)
+		}
+	} else {
+		c.Header("Content-Disposition", contentDisposition("attachment", file.OriginalName))
+		c.Header("Content-Type", file.MimeType)
+		// Keep scriptable types inert even if the global policy is disabled
+		if executableType(file.MimeType) {
+			c.Header("Content-Type", "application/octet-stream")
+			c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+		}
+	}
 	c.Header("Content-Length", strconv.FormatInt(file.FileSize, 10))
 
-	c.File(file.FilePath)
-}
\ No newline at end of file
+	// A known digest lets resuming clients detect a changed file: If-Range
+	// is matched against the ETag and Repr-Digest covers the whole file.
+	// The upload checksum is preferred as it needs no extra query
+	if file.FilePath == originalPath {
+		digest := checksumDigest(file.Checksum)
+		if digest == "" {
+			digest = h.storedReprDigest(file.ID)
+		}
+		if digest != "" {
+			c.Header("Repr-Digest", "sha-256=:"+digest+":")
+			c.Header("ETag", fileETag(digest))
+		}
+	}
+
+	// Throttled, capped and one-time downloads must pass through the server
+	if rateLimit == 0 && !file.OneTime && file.MaxConcurrentDownloads == nil && h.redirectToBlob(c, file.FilePath) {
+		return
+	}
+	h.serveBlob(c, file.FilePath, rateLimit)
+}
+
+// selectImageVariant points file at the most preferred stored variant the
+// client accepts, leaving it unchanged when there is none.
+func (h *FileHandler) selectImageVariant(c *gin.Context, file *models.File) {
+	rows, err := h.db.Query("SELECT mime_type, file_path, file_size FROM file_variants WHERE file_id = $1 AND format <> $2", file.ID, services.ThumbnailFormat)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	variants := make(map[string]models.File)
+	for rows.Next() {
+		var variant models.File
+		if err := rows.Scan(&variant.MimeType, &variant.FilePath, &variant.FileSize); err != nil {
+			continue
+		}
+		variants[variant.MimeType] = variant
+	}
+
+	accept := c.GetHeader("Accept")
+	for _, mimeType := range variantPreference {
+		variant, ok := variants[mimeType]
+		if ok && acceptsMediaType(accept, mimeType) {
+			file.MimeType = variant.MimeType
+			file.FilePath = variant.FilePath
+			file.FileSize = variant.FileSize
+			return
+		}
+	}
+}
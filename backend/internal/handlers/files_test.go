@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"file-sharing-backend/internal/database/dbtest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadDownloadableFileUnverifiedOwner(t *testing.T) {
+	tests := []struct {
+		name          string
+		block         bool
+		ownerVerified bool
+		wantOK        bool
+	}{
+		{name: "allowed, verified owner", block: false, ownerVerified: true, wantOK: true},
+		{name: "allowed, unverified owner", block: false, ownerVerified: false, wantOK: true},
+		{name: "blocked, verified owner", block: true, ownerVerified: true, wantOK: true},
+		{name: "blocked, unverified owner", block: true, ownerVerified: false, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := dbtest.NewEnv(t)
+			env.On(downloadableFileQuery...).Return(downloadableFile(tt.ownerVerified))
+			h := newFileHandler(env)
+			h.blockUnverifiedOwners = tt.block
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/share/"+testShareUUID, nil)
+
+			file, ok := h.loadDownloadableFile(c, testShareUUID)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v (response %d %s)", ok, tt.wantOK, w.Code, w.Body)
+			}
+			if ok && file.OriginalName != "report.pdf" {
+				t.Errorf("loaded %q, want report.pdf", file.OriginalName)
+			}
+			if !ok && (w.Code != http.StatusForbidden || !jsonHas(w, "owner_unverified")) {
+				t.Errorf("response %d %s, want 403 with owner_unverified", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+// newUploadEnv is an empty files table that fails the nth insert of a
+// file row, counting from 1, once failInsert is set to n.
+func newUploadEnv(t *testing.T) (env *dbtest.Env, failInsert *int) {
+	env = dbtest.NewEnv(t)
+	failInsert = new(int)
+	env.On("SELECT max_active_files").Do(func([]driver.Value) (*dbtest.Result, error) {
+		return dbtest.Row(nil, int64(len(env.Files))), nil
+	})
+	inserts := 0
+	env.On("INSERT INTO files", "RETURNING id").Do(func([]driver.Value) (*dbtest.Result, error) {
+		inserts++
+		if inserts == *failInsert {
+			return nil, errors.New("server closed the connection unexpectedly")
+		}
+		return nil, dbtest.Skip
+	})
+	return env, failInsert
+}
+
+// upload posts count small files to UploadFiles in one request.
+func upload(t *testing.T, h *FileHandler, count int) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i := 0; i < count; i++ {
+		part, err := mw.CreateFormFile("files", fmt.Sprintf("notes-%d.txt", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(part, "contents of file %d\n", i)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return serve(req, "/api/files/upload", asUser(1), h.UploadFiles)
+}
+
+func openDescriptors(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open descriptors cannot be counted here:", err)
+	}
+	return len(entries)
+}
+
+// A large batch is staged and committed one file at a time; neither a
+// stored batch nor one that fails part way leaves descriptors open or
+// staged blobs behind.
+func TestUploadManyFilesLeaksNothing(t *testing.T) {
+	const count = 200
+	tests := []struct {
+		name string
+		// failLast fails the insert of the last file of the batch
+		failLast   bool
+		wantStatus int
+		// wantFiles is the number of rows and of blobs, counting the
+		// warm-up file
+		wantFiles int
+	}{
+		{name: "stored", wantStatus: http.StatusOK, wantFiles: count + 1},
+		{name: "failing part way", failLast: true, wantStatus: http.StatusInternalServerError, wantFiles: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, failInsert := newUploadEnv(t)
+			h := newFileHandler(env)
+			// The first upload sets up what the runtime keeps open for good
+			if w := upload(t, h, 1); w.Code != http.StatusOK {
+				t.Fatalf("warm-up upload: status %d: %s", w.Code, w.Body)
+			}
+			before := openDescriptors(t)
+
+			if tt.failLast {
+				*failInsert = 1 + count
+			}
+			if w := upload(t, h, count); w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if after := openDescriptors(t); after > before {
+				t.Errorf("%d descriptors open after the upload, %d before", after, before)
+			}
+			blobs := env.Blobs(t)
+			for _, name := range blobs {
+				if strings.HasPrefix(name, ".upload-") {
+					t.Errorf("staged blob %s left behind", name)
+				}
+			}
+			if len(env.Files) != tt.wantFiles || len(blobs) != tt.wantFiles {
+				t.Errorf("%d rows and %d blobs, want %d of each", len(env.Files), len(blobs), tt.wantFiles)
+			}
+		})
+	}
+}
+
+// A blob whose row could not be inserted is deleted while the transaction
+// still holds the blob's lock, before the rollback releases it.
+func TestUploadDeletesBlobBeforeRollback(t *testing.T) {
+	env, failInsert := newUploadEnv(t)
+	*failInsert = 1
+	var atRollback []string
+	env.On("ROLLBACK").Do(func([]driver.Value) (*dbtest.Result, error) {
+		atRollback = env.Blobs(t)
+		return nil, nil
+	})
+
+	if w := upload(t, newFileHandler(env), 1); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", w.Code, w.Body)
+	}
+	for _, name := range atRollback {
+		if !strings.HasPrefix(name, ".upload-") {
+			t.Errorf("blob %s was still there when the lock was released", name)
+		}
+	}
+}
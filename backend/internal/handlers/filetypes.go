@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadTypePolicy decides which files may be uploaded. Entries are MIME
+// types ("application/pdf"), type prefixes ending in "/" ("image/") or
+// extensions starting with "." (".exe"), matched against the sniffed type
+// and the file name.
+type uploadTypePolicy struct {
+	allowed []string
+	blocked []string
+}
+
+// loadUploadTypePolicy reads UPLOAD_ALLOWED_TYPES and UPLOAD_BLOCKED_TYPES.
+// Without an allowlist every type not blocked is accepted.
+func loadUploadTypePolicy() uploadTypePolicy {
+	return uploadTypePolicy{
+		allowed: parseTypeList(os.Getenv("UPLOAD_ALLOWED_TYPES")),
+		blocked: parseTypeList(os.Getenv("UPLOAD_BLOCKED_TYPES")),
+	}
+}
+
+func parseTypeList(value string) []string {
+	var entries []string
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// permits reports whether a file with the given name and sniffed type may
+// be uploaded. Encrypted files are only checked by extension, as their
+// content reveals nothing.
+func (p uploadTypePolicy) permits(name, contentType string, encrypted bool) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if encrypted {
+		mediaType = ""
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+
+	matches := func(entries []string) bool {
+		for _, e := range entries {
+			switch {
+			case strings.HasPrefix(e, "."):
+				if ext == e {
+					return true
+				}
+			case strings.HasSuffix(e, "/"):
+				if strings.HasPrefix(mediaType, e) {
+					return true
+				}
+			case mediaType == e:
+				return true
+			}
+		}
+		return false
+	}
+
+	if matches(p.blocked) {
+		return false
+	}
+	if len(p.allowed) == 0 {
+		return true
+	}
+	// An encrypted file can only satisfy an allowlist by its extension
+	return matches(p.allowed)
+}
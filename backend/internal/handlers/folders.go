@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxRelativePathLength = 1024
+
+var errInvalidRelativePath = errors.New("invalid relative path")
+
+// sanitizeRelativePath normalizes a client-supplied path inside an uploaded
+// folder, rejecting anything that could escape it: absolute paths, ".."
+// segments and control characters.
+func sanitizeRelativePath(p string) (string, error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if p == "" || len(p) > maxRelativePathLength || strings.HasPrefix(p, "/") {
+		return "", errInvalidRelativePath
+	}
+	for _, r := range p {
+		if unicode.IsControl(r) {
+			return "", errInvalidRelativePath
+		}
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", errInvalidRelativePath
+		}
+	}
+
+	cleaned := path.Clean(p)
+	if cleaned == "." || strings.HasPrefix(cleaned, "../") {
+		return "", errInvalidRelativePath
+	}
+	return cleaned, nil
+}
+
+// folderRelativePaths validates the relative_paths sent with a folder upload.
+// It returns nil when the upload is not a folder upload.
+func folderRelativePaths(values []string, fileCount int) ([]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) != fileCount {
+		return nil, errors.New("relative_paths must contain one entry per file")
+	}
+
+	paths := make([]string, len(values))
+	seen := make(map[string]bool, len(values))
+	for i, value := range values {
+		p, err := sanitizeRelativePath(value)
+		if err != nil {
+			return nil, errors.New("invalid relative path: " + value)
+		}
+		if seen[p] {
+			return nil, errors.New("duplicate relative path: " + p)
+		}
+		seen[p] = true
+		paths[i] = p
+	}
+
+	// A path cannot be both a file and a directory
+	for _, p := range paths {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if seen[dir] {
+				return nil, errors.New("relative path conflicts with a file: " + dir)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// folderNode is a directory or file in a folder listing.
+type folderNode struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	UUID        string        `json:"uuid,omitempty"`
+	FileSize    int64         `json:"file_size,omitempty"`
+	HasPassword bool          `json:"has_password,omitempty"`
+	Children    []*folderNode `json:"children,omitempty"`
+}
+
+type folderEntry struct {
+	UUID         string    `json:"uuid"`
+	RelativePath string    `json:"relative_path"`
+	FileSize     int64     `json:"file_size"`
+	HasPassword  bool      `json:"has_password"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// buildFolderTree turns the flat entry list into a nested tree with
+// directories listed before files and both sorted by name.
+func buildFolderTree(entries []folderEntry) *folderNode {
+	root := &folderNode{Name: "", Type: "dir"}
+	dirs := map[string]*folderNode{"": root}
+
+	for _, entry := range entries {
+		parent := root
+		segments := strings.Split(entry.RelativePath, "/")
+		for i, segment := range segments[:len(segments)-1] {
+			key := strings.Join(segments[:i+1], "/")
+			dir, ok := dirs[key]
+			if !ok {
+				dir = &folderNode{Name: segment, Type: "dir"}
+				dirs[key] = dir
+				parent.Children = append(parent.Children, dir)
+			}
+			parent = dir
+		}
+		parent.Children = append(parent.Children, &folderNode{
+			Name:        segments[len(segments)-1],
+			Type:        "file",
+			UUID:        entry.UUID,
+			FileSize:    entry.FileSize,
+			HasPassword: entry.HasPassword,
+		})
+	}
+
+	for _, dir := range dirs {
+		sort.Slice(dir.Children, func(i, j int) bool {
+			a, b := dir.Children[i], dir.Children[j]
+			if a.Type != b.Type {
+				return a.Type == "dir"
+			}
+			return a.Name < b.Name
+		})
+	}
+	return root
+}
+
+// GetFolder lists the non-expired files of an uploaded folder as a tree.
+func (h *FileHandler) GetFolder(c *gin.Context) {
+	folderUUID := c.Param("uuid")
+
+	rows, err := h.db.QueryRetry(`
+		SELECT uuid, relative_path, file_size, password_hash IS NOT NULL, expires_at
+		FROM files
+		WHERE folder_uuid = $1 AND deleted_at IS NULL AND disabled_at IS NULL
+		ORDER BY relative_path`,
+		folderUUID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch folder")
+		return
+	}
+	defer rows.Close()
+
+	found := false
+	entries := []folderEntry{}
+	for rows.Next() {
+		var entry folderEntry
+		if err := rows.Scan(&entry.UUID, &entry.RelativePath, &entry.FileSize, &entry.HasPassword, &entry.ExpiresAt); err != nil {
+			continue
+		}
+		found = true
+		if time.Now().After(entry.ExpiresAt) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusGone, gin.H{"error": "Folder has expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"folder_uuid": folderUUID,
+		"files":       entries,
+		"tree":        buildFolderTree(entries).Children,
+	})
+}
+
+// GetFolderEntry downloads a single file from an uploaded folder by its
+// relative path, with the same checks as a direct share download.
+func (h *FileHandler) GetFolderEntry(c *gin.Context) {
+	folderUUID := c.Param("uuid")
+	relativePath, err := sanitizeRelativePath(strings.TrimPrefix(c.Param("path"), "/"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return
+	}
+
+	var fileUUID string
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow(
+			"SELECT uuid FROM files WHERE folder_uuid = $1 AND relative_path = $2 AND deleted_at IS NULL",
+			folderUUID, relativePath,
+		).Scan(&fileUUID)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		} else {
+			respondDBError(c, err, "Database error")
+		}
+		return
+	}
+
+	h.serveDownload(c, fileUUID)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetFileGate tells the share landing page which screen to show with as
+// little information as possible: whether the file exists, needs a password
+// and has expired. Unknown files get the same 200 shape as known ones, and
+// disabled shares the same as expired ones.
+func (h *FileHandler) GetFileGate(c *gin.Context) {
+	fileUUID, ok := h.shareParam(c)
+	if !ok {
+		return
+	}
+
+	var hasPassword, exhausted, disabled bool
+	var expiresAt time.Time
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT password_hash IS NOT NULL, expires_at, COALESCE(download_count >= max_downloads, FALSE), disabled_at IS NOT NULL
+			FROM files WHERE uuid = $1 AND deleted_at IS NULL`,
+			fileUUID,
+		).Scan(&hasPassword, &expiresAt, &exhausted, &disabled)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		respondDBError(c, err, "Database error")
+		return
+	}
+
+	exists := err == nil
+	// A file that used up its downloads is gone just like an expired one
+	expired := exists && (time.Now().After(expiresAt) || exhausted || disabled)
+	c.JSON(http.StatusOK, gin.H{
+		"exists":            exists,
+		"password_required": exists && !expired && hasPassword,
+		"expired":           expired,
+	})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetFileGate(t *testing.T) {
+	db := dbtest.Open(t, withSlug(func(query string, args []driver.Value) (*dbtest.Result, error) {
+		if dbtest.Match(query, "FROM files WHERE uuid = $1 AND deleted_at IS NULL") && args[0] == testShareUUID {
+			return dbtest.Row(true, time.Now().Add(time.Hour), false, false), nil
+		}
+		return nil, nil
+	}))
+	h := &FileHandler{db: db}
+	r := gin.New()
+	r.GET("/api/files/:uuid/gate", h.GetFileGate)
+
+	tests := []struct {
+		id     string
+		exists bool
+	}{
+		{id: testShareUUID, exists: true},
+		{id: testShareSlug, exists: true},
+		{id: "no-such-share", exists: false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/files/"+tt.id+"/gate", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", tt.id, w.Code)
+			continue
+		}
+		var gate struct {
+			Exists           bool `json:"exists"`
+			PasswordRequired bool `json:"password_required"`
+			Expired          bool `json:"expired"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &gate); err != nil {
+			t.Fatal(err)
+		}
+		if gate.Exists != tt.exists || gate.PasswordRequired != tt.exists || gate.Expired {
+			t.Errorf("%s: gate %+v, want exists and password_required %v", tt.id, gate, tt.exists)
+		}
+	}
+}
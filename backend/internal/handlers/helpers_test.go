@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+const (
+	testShareUUID = "6f1c2a9e-3b4d-4e5f-8a7b-9c0d1e2f3a4b"
+	testShareSlug = "quarterly-report"
+)
+
+// newFileHandler, newAdminHandler and newAuthHandler build handlers the way
+// main does, on env's database and store. They read their configuration
+// from the environment, so tests set it before.
+func newFileHandler(env *dbtest.Env) *FileHandler {
+	events := services.NewEventBus(0)
+	jobs := services.NewJobRegistry()
+	return NewFileHandler(env.DB, events, services.NewDownloadHistory(env.DB),
+		services.NewImageVariantService(env.DB, jobs, env.Store, env.Logger),
+		services.NewScanService(env.DB, events, jobs, env.Store, env.Logger),
+		services.NewSettingsService(env.DB), env.Store,
+		services.NewThumbnailService(env.DB, jobs, env.Store, env.Logger), nil)
+}
+
+func newAdminHandler(env *dbtest.Env) *AdminHandler {
+	history := services.NewDownloadHistory(env.DB)
+	jobs := services.NewJobRegistry()
+	return NewAdminHandler(env.DB, services.NewEventBus(0), history, jobs,
+		services.NewIntegrityService(env.DB, history, jobs, env.Store), env.Store)
+}
+
+func newAuthHandler(env *dbtest.Env, mailer services.Mailer) *AuthHandler {
+	return NewAuthHandler(env.DB, services.NewDownloadHistory(env.DB), mailer, services.NewSettingsService(env.DB), env.Store)
+}
+
+// serve sends req to a router with chain registered for route.
+func serve(req *http.Request, route string, chain ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(req.Method, route, chain...)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// asUser and asRole stand in for the auth middleware.
+func asUser(id int) gin.HandlerFunc {
+	return func(c *gin.Context) { c.Set("user_id", id) }
+}
+
+func asRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) { c.Set("role", role) }
+}
+
+// downloadableFile is how loadDownloadableFile sees a clean, unprotected
+// file whose owner's verification state is ownerVerified.
+func downloadableFile(ownerVerified bool) *dbtest.Result {
+	return dbtest.Row(
+		int64(1), "report.pdf", "/uploads/report.pdf", int64(1024), "application/pdf",
+		nil, time.Now().Add(time.Hour), int64(0),
+		false, nil, nil, nil,
+		nil, nil, nil, false, "clean", nil,
+		ownerVerified,
+	)
+}
+
+// downloadableFileQuery identifies the query of loadDownloadableFile.
+var downloadableFileQuery = []string{"FROM files WHERE uuid = $1 AND deleted_at IS NULL", "email_verified"}
+
+// withSlug answers the slug lookup of resolveShareID for testShareSlug and
+// passes every other query on to next.
+func withSlug(next dbtest.Handler) dbtest.Handler {
+	return func(query string, args []driver.Value) (*dbtest.Result, error) {
+		if dbtest.Match(query, "SELECT uuid FROM files WHERE slug = $1") {
+			if args[0] == testShareSlug {
+				return dbtest.Row(testShareUUID), nil
+			}
+			return nil, nil
+		}
+		return next(query, args)
+	}
+}
+
+// jsonHas reports whether the JSON object in the response has key.
+func jsonHas(w *httptest.ResponseRecorder, key string) bool {
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		return false
+	}
+	_, ok := body[key]
+	return ok
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJobs reports the status of every background job.
+func (h *AdminHandler) GetJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"jobs": h.jobs.Statuses()})
+}
+
+// RunJob starts a triggerable job in the background. Its progress shows up
+// in GetJobs.
+func (h *AdminHandler) RunJob(c *gin.Context) {
+	h.triggerJob(c, c.Param("name"))
+}
+
+// RunIntegrityCheck starts a consistency check of download rows, blobs and
+// stored digests as a job. It only reports unless ?fix=true, which deletes
+// orphaned download rows and the records of files whose blob is gone.
+func (h *AdminHandler) RunIntegrityCheck(c *gin.Context) {
+	name := services.JobIntegrityCheck
+	if c.Query("fix") == "true" {
+		name = services.JobIntegrityRepair
+	}
+	h.triggerJob(c, name)
+}
+
+// GetIntegrityReport returns the report of the last finished integrity run.
+func (h *AdminHandler) GetIntegrityReport(c *gin.Context) {
+	report := h.integrity.LastReport()
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No integrity check has finished yet"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// RunCleanup runs the expired file cleanup right away and answers once it
+// has finished, with how many files it removed.
+func (h *AdminHandler) RunCleanup(c *gin.Context) {
+	status, err := h.jobs.Run(services.JobCleanupExpiredFiles)
+	if err == services.ErrJobAlreadyRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cleanup is already running"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	body := gin.H{"removed": status.LastProcessed, "duration_ms": status.LastDurationMs}
+	if status.LastError != "" {
+		body["error"] = status.LastError
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// GetOrphans reports files whose blob is missing and blobs on disk that no
+// file refers to. Nothing is changed; see RunIntegrityCheck for repairs.
+func (h *AdminHandler) GetOrphans(c *gin.Context) {
+	report, err := h.integrity.FindOrphans()
+	if err != nil {
+		respondDBError(c, err, "Failed to check for orphans")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *AdminHandler) triggerJob(c *gin.Context, name string) {
+	switch err := h.jobs.Trigger(name); err {
+	case nil:
+		c.JSON(http.StatusAccepted, gin.H{"job": name, "running": true})
+	case services.ErrUnknownJob:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case services.ErrJobNotRunnable:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This job cannot be triggered manually"})
+	case services.ErrJobAlreadyRunning:
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is already running"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
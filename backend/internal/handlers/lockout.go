@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginLockout is how many failed logins for one email from one IP are
+// allowed within window before further attempts are refused. Keying on the
+// pair keeps an attacker from locking a victim out from elsewhere.
+type loginLockout struct {
+	maxFailures int
+	window      time.Duration
+}
+
+// loadLoginLockout reads LOGIN_MAX_FAILURES (default 5, 0 disables the
+// lockout) and LOGIN_LOCKOUT_WINDOW (default 15m).
+func loadLoginLockout() loginLockout {
+	lockout := loginLockout{maxFailures: 5, window: 15 * time.Minute}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil && n >= 0 {
+		lockout.maxFailures = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT_WINDOW")); err == nil && d > 0 {
+		lockout.window = d
+	}
+	return lockout
+}
+
+// loginRetryAfter returns how long logins for email from ip stay locked, or
+// 0 if they are not. The oldest failure in the window has to age out first.
+func (h *AuthHandler) loginRetryAfter(email, ip string) (time.Duration, error) {
+	if h.lockout.maxFailures == 0 {
+		return 0, nil
+	}
+	var failures int
+	var wait float64
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM MIN(attempted_at) + $3 * INTERVAL '1 second' - NOW()), 0)
+			FROM login_failures
+			WHERE email = $1 AND ip_address = $2 AND attempted_at > NOW() - $3 * INTERVAL '1 second'`,
+			email, ip, h.lockout.window.Seconds(),
+		).Scan(&failures, &wait)
+	})
+	if err != nil || failures < h.lockout.maxFailures {
+		return 0, err
+	}
+	return time.Duration(math.Ceil(max(wait, 1))) * time.Second, nil
+}
+
+// recordLoginFailure counts a failed login, whether or not the account
+// exists, and drops failures that have left the window.
+func (h *AuthHandler) recordLoginFailure(email, ip string) error {
+	if h.lockout.maxFailures == 0 {
+		return nil
+	}
+	_, err := h.db.Exec(`
+		WITH expired AS (
+			DELETE FROM login_failures WHERE attempted_at <= NOW() - $3 * INTERVAL '1 second'
+		)
+		INSERT INTO login_failures (email, ip_address) VALUES ($1, $2)`,
+		email, ip, h.lockout.window.Seconds(),
+	)
+	return err
+}
+
+// clearLoginFailures resets the count after a successful login.
+func (h *AuthHandler) clearLoginFailures(email, ip string) error {
+	if h.lockout.maxFailures == 0 {
+		return nil
+	}
+	_, err := h.db.Exec("DELETE FROM login_failures WHERE email = $1 AND ip_address = $2", email, ip)
+	return err
+}
+
+// respondLockedOut answers a login attempt while locked out. The answer is
+// the same whether or not the account exists.
+func respondLockedOut(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(retryAfter.Seconds())
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, try again later",
+		"retry_after": seconds,
+	})
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxMetadataEntries     = 50
+	maxMetadataValueLength = 1024
+)
+
+// metadataKeyPattern keeps keys short and safe to use in query strings.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// decodeMetadata converts the stored JSONB object into a map.
+func decodeMetadata(raw []byte) map[string]string {
+	metadata := map[string]string{}
+	if len(raw) > 0 {
+		json.Unmarshal(raw, &metadata)
+	}
+	return metadata
+}
+
+// lookupOwnedFile resolves the :uuid route parameter to the ID of a file
+// owned by the caller. On failure the response has been written and ok is
+// false.
+func (h *FileHandler) lookupOwnedFile(c *gin.Context) (fileID int, ok bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+
+	var ownerID int
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT id, user_id FROM files WHERE uuid = $1 AND deleted_at IS NULL", c.Param("uuid")).Scan(&fileID, &ownerID)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return 0, false
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return 0, false
+	}
+	if ownerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return 0, false
+	}
+	return fileID, true
+}
+
+// GetFileMetadata returns all metadata of an owned file.
+func (h *FileHandler) GetFileMetadata(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	var raw []byte
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT metadata FROM files WHERE id = $1", fileID).Scan(&raw)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch metadata")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"metadata": decodeMetadata(raw)})
+}
+
+// SetFileMetadata sets a single metadata key on an owned file.
+func (h *FileHandler) SetFileMetadata(c *gin.Context) {
+	key := c.Param("key")
+	if !metadataKeyPattern.MatchString(key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Metadata keys must be 1-64 letters, digits, '_', '.' or '-'"})
+		return
+	}
+
+	var req struct {
+		Value *string `json:"value" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body must be {\"value\": \"...\"}"})
+		return
+	}
+	if len(*req.Value) > maxMetadataValueLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Metadata values must be at most %d bytes", maxMetadataValueLength)})
+		return
+	}
+
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	// The entry limit is checked in the same statement so concurrent writes
+	// cannot push a file past it
+	var raw []byte
+	err := h.db.QueryRow(`
+		UPDATE files SET metadata = metadata || jsonb_build_object($2::text, $3::text), updated_at = NOW()
+		WHERE id = $1
+		  AND (metadata ? $2 OR (SELECT COUNT(*) FROM jsonb_object_keys(metadata)) < $4)
+		RETURNING metadata`,
+		fileID, key, *req.Value, maxMetadataEntries,
+	).Scan(&raw)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A file can have at most %d metadata entries", maxMetadataEntries)})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to update metadata")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"metadata": decodeMetadata(raw)})
+}
+
+// DeleteFileMetadata removes a single metadata key from an owned file.
+func (h *FileHandler) DeleteFileMetadata(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	var raw []byte
+	err := h.db.QueryRow(`
+		UPDATE files SET metadata = metadata - $2::text, updated_at = NOW()
+		WHERE id = $1
+		RETURNING metadata`,
+		fileID, c.Param("key"),
+	).Scan(&raw)
+	if err != nil {
+		respondDBError(c, err, "Failed to delete metadata")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"metadata": decodeMetadata(raw)})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultMaxFilenameLength = 255
+	// maxFilenameColumnLength is the size of files.original_name
+	maxFilenameColumnLength = 500
+)
+
+var errEmptyFilename = errors.New("file names must not be empty")
+
+// loadMaxFilenameLength reads MAX_FILENAME_LENGTH in characters, capped at
+// the column size.
+func loadMaxFilenameLength() int {
+	n, err := strconv.Atoi(os.Getenv("MAX_FILENAME_LENGTH"))
+	if err != nil || n <= 0 {
+		return defaultMaxFilenameLength
+	}
+	if n > maxFilenameColumnLength {
+		return maxFilenameColumnLength
+	}
+	return n
+}
+
+// windowsReservedNames are device names Windows will not open as files,
+// whatever their extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// normalizeFilename makes an uploaded file name safe to store and to offer
+// as a download name: invalid UTF-8 and control characters are replaced,
+// directories are dropped, trailing dots and spaces (which Windows strips)
+// are trimmed and Windows device names get a "_" prefix. The result is
+// shortened to maxLength characters, keeping the extension and truncating
+// the base name unless the extension alone is too long to fit.
+func normalizeFilename(name string, maxLength int) (string, error) {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.ToValidUTF8(name, "\uFFFD"))
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimLeft(strings.TrimRight(name, ". "), " ")
+	if name == "" {
+		return "", errEmptyFilename
+	}
+	if stem, _, _ := strings.Cut(name, "."); windowsReservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = "_" + name
+	}
+
+	runes := []rune(name)
+	if len(runes) <= maxLength {
+		return name, nil
+	}
+
+	ext := []rune(filepath.Ext(name))
+	if len(ext) >= maxLength/2 {
+		return string(runes[:maxLength]), nil
+	}
+	base := runes[:len(runes)-len(ext)]
+	return strings.TrimRight(string(base[:maxLength-len(ext)]), " ") + string(ext), nil
+}
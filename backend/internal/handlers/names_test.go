@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeFilename(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		maxLength int
+		want      string
+	}{
+		{name: "plain", in: "report.pdf", maxLength: 255, want: "report.pdf"},
+		{name: "surrounding spaces", in: "  report.pdf  ", maxLength: 255, want: "report.pdf"},
+		{name: "unicode", in: "résumé 履歴書.pdf", maxLength: 255, want: "résumé 履歴書.pdf"},
+		{name: "dotfile", in: ".bashrc", maxLength: 255, want: ".bashrc"},
+
+		{name: "unix directories", in: "../../etc/passwd", maxLength: 255, want: "passwd"},
+		{name: "windows directories", in: `C:\Users\me\report.pdf`, maxLength: 255, want: "report.pdf"},
+		{name: "mixed separators", in: `a/b\c.txt`, maxLength: 255, want: "c.txt"},
+
+		{name: "NUL", in: "evil\x00.exe.txt", maxLength: 255, want: "evil_.exe.txt"},
+		{name: "CRLF", in: "a\r\nSet-Cookie: x.txt", maxLength: 255, want: "a__Set-Cookie: x.txt"},
+		{name: "DEL and C1 controls", in: "a\x7fb\u0085c.txt", maxLength: 255, want: "a_b_c.txt"},
+		{name: "invalid UTF-8", in: "bad\xff\xfe.txt", maxLength: 255, want: "bad\uFFFD.txt"},
+		// An overlong encoding of "/" must not be decoded as a separator
+		{name: "overlong slash", in: "a\xc0\xafb.txt", maxLength: 255, want: "a\uFFFDb.txt"},
+		// nor an overlong "." as a dot to trim
+		{name: "overlong dot", in: "name\xc0\xae", maxLength: 255, want: "name\uFFFD"},
+
+		{name: "trailing dots", in: "report.pdf...", maxLength: 255, want: "report.pdf"},
+		{name: "trailing dots and spaces", in: "report . . ", maxLength: 255, want: "report"},
+		{name: "reserved name", in: "CON", maxLength: 255, want: "_CON"},
+		{name: "reserved name with extension", in: "nul.txt", maxLength: 255, want: "_nul.txt"},
+		{name: "reserved name with double extension", in: "Com1.tar.gz", maxLength: 255, want: "_Com1.tar.gz"},
+		{name: "reserved name with trailing dot", in: "aux.", maxLength: 255, want: "_aux"},
+		{name: "not reserved", in: "CONSOLE.txt", maxLength: 255, want: "CONSOLE.txt"},
+		{name: "not reserved COM0", in: "COM0", maxLength: 255, want: "COM0"},
+
+		{name: "truncated keeping extension", in: strings.Repeat("a", 300) + ".pdf", maxLength: 20, want: strings.Repeat("a", 16) + ".pdf"},
+		{name: "truncated without trailing space", in: "report      final.pdf", maxLength: 12, want: "report.pdf"},
+		{name: "overlong extension", in: "a." + strings.Repeat("x", 30), maxLength: 10, want: "a." + strings.Repeat("x", 8)},
+		{name: "truncated on characters", in: strings.Repeat("é", 30) + ".txt", maxLength: 10, want: strings.Repeat("é", 6) + ".txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeFilename(tt.in, tt.maxLength)
+			if err != nil {
+				t.Fatalf("normalizeFilename(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("normalizeFilename(%q) = %q is not valid UTF-8", tt.in, got)
+			}
+			if n := utf8.RuneCountInString(got); n > tt.maxLength {
+				t.Errorf("normalizeFilename(%q) is %d characters, longer than %d", tt.in, n, tt.maxLength)
+			}
+		})
+	}
+}
+
+func TestNormalizeFilenameRejectsEmptyNames(t *testing.T) {
+	for _, in := range []string{"", "   ", ".", "..", "...", ". .", "dir/", `dir\`, "../..", "/"} {
+		if got, err := normalizeFilename(in, 255); err != errEmptyFilename {
+			t.Errorf("normalizeFilename(%q) = %q, %v; want errEmptyFilename", in, got, err)
+		}
+	}
+}
+
+// Names that differ only in what normalization removes end up the same.
+// That is harmless: even when blobs are named after uploads, storage gives
+// a taken name a suffix rather than overwriting it.
+func TestNormalizeFilenameCollisions(t *testing.T) {
+	groups := [][]string{
+		{"report.pdf", "  report.pdf", "report.pdf.", "report.pdf . ", "a/report.pdf", `c:\x\report.pdf`},
+		{"a_b.txt", "a\x00b.txt", "a\nb.txt", "a\tb.txt"},
+	}
+	for _, group := range groups {
+		want, err := normalizeFilename(group[0], 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, in := range group[1:] {
+			if got, err := normalizeFilename(in, 255); err != nil || got != want {
+				t.Errorf("normalizeFilename(%q) = %q, %v; want %q like %q", in, got, err, want, group[0])
+			}
+		}
+	}
+}
+
+func TestNormalizeFilenameIsIdempotent(t *testing.T) {
+	for _, in := range []string{"a\x00b.txt", "CON.txt", "x\xffy", "report . . ", strings.Repeat("long ", 100) + ".txt"} {
+		once, err := normalizeFilename(in, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		twice, err := normalizeFilename(once, 50)
+		if err != nil || twice != once {
+			t.Errorf("normalizing %q again gave %q, %v; want %q", once, twice, err, once)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultPasswordResetTTL = time.Hour
+
+	// passwordResetInterval is the least time between reset emails to one
+	// account, so the forgot endpoint cannot be used to flood a mailbox.
+	passwordResetInterval = time.Minute
+)
+
+// loadPasswordResetTTL reads PASSWORD_RESET_TTL.
+func loadPasswordResetTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultPasswordResetTTL
+}
+
+// ForgotPassword emails a password reset link to the account with the given
+// email. The response is the same whether or not such an account exists,
+// and the email is sent in the background, so neither the answer nor its
+// timing reveals registered addresses.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+		return
+	}
+	email := normalizeEmail(req.Email)
+
+	token, err := h.createPasswordReset(email)
+	if err != nil {
+		respondDBError(c, err, "Failed to request password reset")
+		return
+	}
+	if token != "" {
+		logger := logging.FromContext(c)
+		go func() {
+			if err := h.sendPasswordResetEmail(email, token); err != nil {
+				logger.Error("sending password reset email failed", "event", "email_failed", "error", err)
+			}
+		}()
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account exists for this email, a password reset link has been sent"})
+}
+
+// createPasswordReset stores a new reset token for the account with email
+// and returns it. It returns "" if there is no such account or one was
+// issued within passwordResetInterval.
+func (h *AuthHandler) createPasswordReset(email string) (string, error) {
+	var userID int
+	var recent bool
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT u.id, EXISTS(
+				SELECT 1 FROM password_resets r
+				WHERE r.user_id = u.id AND r.created_at > NOW() - $2 * INTERVAL '1 second'
+			)
+			FROM users u
+			WHERE LOWER(u.email) = $1`,
+			email, int(passwordResetInterval.Seconds()),
+		).Scan(&userID, &recent)
+	})
+	if err == sql.ErrNoRows || (err == nil && recent) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	_, err = h.db.Exec(
+		"INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		userID, hashToken(token), time.Now().Add(h.resetTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendPasswordResetEmail mails a link to the frontend's reset page.
+func (h *AuthHandler) sendPasswordResetEmail(email, token string) error {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:3000"
+	}
+	link := strings.TrimSuffix(frontendURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+	body := "A password reset was requested for your account. Choose a new password here:\n\n" + link + "\n\n" +
+		"The link can be used once and expires in " + h.resetTTL.String() + ". " +
+		"If you did not ask for this, you can ignore this email; your password stays the same.\n"
+	return h.mailer.Send(email, "Reset your password", body)
+}
+
+// ResetPassword sets a new password with a token from a reset email. Like a
+// password change it ends every session. Using the token also confirms the
+// email address, and all of the account's outstanding tokens stop working.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := h.settings.PasswordPolicy()
+	if err != nil {
+		respondDBError(c, err, "Failed to load password policy")
+		return
+	}
+	if err := policy.Validate(req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondDBError(c, err, "Failed to reset password")
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the token row makes concurrent uses of one token wait, and
+	// all but the first find it used
+	var userID int
+	err = tx.QueryRow(`
+		SELECT user_id FROM password_resets
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE`,
+		hashToken(req.Token),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to reset password")
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET password_hash = $1, email_verified = TRUE, tokens_valid_after = date_trunc('second', NOW()), updated_at = NOW()
+		WHERE id = $2`,
+		string(hashedPwd), userID,
+	)
+	if err == nil {
+		_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to reset password")
+		return
+	}
+
+	logging.FromContext(c).Info("password reset", "event", "password_reset", "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset; log in with the new password"})
+}
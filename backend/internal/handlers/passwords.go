@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errSharePassword is the single response for a missing or wrong share
+// password, so the two cases cannot be told apart.
+var errSharePassword = gin.H{
+	"error":             "Password required or invalid",
+	"password_required": true,
+}
+
+// dummyPasswordHash is compared against when a file has no password, so
+// every check costs one bcrypt comparison.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("no password set"), bcrypt.DefaultCost)
+
+// checkSharePassword reports whether password unlocks a share protected by
+// passwordHash (nil meaning unprotected). It always performs exactly one
+// bcrypt comparison, so response timing does not reveal whether the share
+// has a password or how far a guess got.
+func checkSharePassword(passwordHash *string, password string) bool {
+	if passwordHash == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(*passwordHash), []byte(password)) == nil
+}
+
+// hashSharePassword checks a new share password against the password policy
+// and hashes it. On failure the response has been written and ok is false.
+func (h *FileHandler) hashSharePassword(c *gin.Context, password string) (hash string, ok bool) {
+	policy, err := h.settings.PasswordPolicy()
+	if err != nil {
+		respondDBError(c, err, "Failed to load password policy")
+		return "", false
+	}
+	if err := policy.Validate(password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return "", false
+	}
+	return string(hashed), true
+}
+
+// SetSharePassword sets, changes or, given an empty password, removes the
+// password of an owned share. Share cookies are bound to the old hash, so
+// browsers that unlocked the share have to unlock it again.
+func (h *FileHandler) SetSharePassword(c *gin.Context) {
+	var req struct {
+		Password *string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Password == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required; send an empty string to remove it"})
+		return
+	}
+
+	var passwordHash *string
+	if *req.Password == "" {
+		required, err := h.settings.RequireSharePassword()
+		if err != nil {
+			respondDBError(c, err, "Failed to load share settings")
+			return
+		}
+		if required {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A password is required for all shares"})
+			return
+		}
+	} else {
+		hash, ok := h.hashSharePassword(c, *req.Password)
+		if !ok {
+			return
+		}
+		passwordHash = &hash
+	}
+
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	// Encrypted files are unlocked by their key verifier, never a password
+	result, err := h.db.Exec(
+		"UPDATE files SET password_hash = $1, updated_at = NOW() WHERE id = $2 AND NOT is_encrypted",
+		passwordHash, fileID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to update password")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Encrypted files cannot have a share password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"uuid": c.Param("uuid"), "has_password": passwordHash != nil})
+}
+
+// VerifySharePassword checks a share password without downloading the file,
+// letting clients validate input before starting a download. It answers 204
+// or the same 401 as a download, and is rate limited per IP.
+func (h *FileHandler) VerifySharePassword(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fileUUID, ok := h.shareParam(c)
+	if !ok {
+		return
+	}
+
+	var passwordHash *string
+	var expiresAt time.Time
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT password_hash, expires_at FROM files WHERE uuid = $1 AND deleted_at IS NULL", fileUUID).
+			Scan(&passwordHash, &expiresAt)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+
+	if !checkSharePassword(passwordHash, req.Password) {
+		c.JSON(http.StatusUnauthorized, errSharePassword)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
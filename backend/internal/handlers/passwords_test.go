@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func mustHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// medianDuration returns the median time fn takes over a few runs, which
+// smooths out scheduling noise.
+func medianDuration(fn func()) time.Duration {
+	durations := make([]time.Duration, 5)
+	for i := range durations {
+		start := time.Now()
+		fn()
+		durations[i] = time.Since(start)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[len(durations)/2]
+}
+
+// Every check costs one bcrypt comparison: an unprotected share compares
+// against the dummy hash, so it takes as long as a missing or wrong password.
+func TestCheckSharePasswordTakesOneComparison(t *testing.T) {
+	if testing.Short() {
+		t.Skip("times bcrypt comparisons")
+	}
+	hash := mustHash(t, "correct horse")
+
+	reference := medianDuration(func() { bcrypt.CompareHashAndPassword([]byte(hash), []byte("x")) })
+	cases := map[string]func() bool{
+		"unprotected":      func() bool { return checkSharePassword(nil, "") },
+		"missing password": func() bool { return checkSharePassword(&hash, "") },
+		"wrong password":   func() bool { return checkSharePassword(&hash, "battery staple") },
+		"right password":   func() bool { return checkSharePassword(&hash, "correct horse") },
+	}
+	for name, check := range cases {
+		got := medianDuration(func() { check() })
+		if got < reference/2 || got > reference*2 {
+			t.Errorf("%s took %v, want about one bcrypt comparison (%v)", name, got, reference)
+		}
+	}
+
+	if !checkSharePassword(nil, "") || !checkSharePassword(nil, "anything") {
+		t.Error("unprotected share refused")
+	}
+	if checkSharePassword(&hash, "") || checkSharePassword(&hash, "battery staple") {
+		t.Error("protected share unlocked without its password")
+	}
+	if !checkSharePassword(&hash, "correct horse") {
+		t.Error("protected share refused its password")
+	}
+}
+
+// verifyPassword posts body to VerifySharePassword for a share protected
+// by passwordHash (nil meaning unprotected), named by id.
+func verifyPassword(t *testing.T, passwordHash *string, id, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	db := dbtest.Open(t, withSlug(func(query string, args []driver.Value) (*dbtest.Result, error) {
+		if dbtest.Match(query, "SELECT password_hash, expires_at FROM files") && args[0] == testShareUUID {
+			var hash driver.Value
+			if passwordHash != nil {
+				hash = *passwordHash
+			}
+			return dbtest.Row(hash, time.Now().Add(time.Hour)), nil
+		}
+		return nil, nil
+	}))
+	h := &FileHandler{db: db}
+
+	r := gin.New()
+	r.POST("/api/files/info/:uuid/password", h.VerifySharePassword)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/files/info/"+id+"/password", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestVerifySharePasswordUniformResponse(t *testing.T) {
+	hash := mustHash(t, "correct horse")
+
+	missing := verifyPassword(t, &hash, testShareUUID, `{}`)
+	empty := verifyPassword(t, &hash, testShareUUID, `{"password": ""}`)
+	wrong := verifyPassword(t, &hash, testShareUUID, `{"password": "battery staple"}`)
+	for name, w := range map[string]*httptest.ResponseRecorder{"missing": missing, "empty": empty, "wrong": wrong} {
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s password: status %d, want 401", name, w.Code)
+		}
+		if w.Body.String() != missing.Body.String() {
+			t.Errorf("%s password: body %s differs from %s", name, w.Body, missing.Body)
+		}
+	}
+	if !strings.Contains(missing.Body.String(), `"password_required":true`) {
+		t.Errorf("401 body %s does not say a password is required", missing.Body)
+	}
+
+	if w := verifyPassword(t, &hash, testShareUUID, `{"password": "correct horse"}`); w.Code != http.StatusNoContent {
+		t.Errorf("right password: status %d, want 204", w.Code)
+	}
+	if w := verifyPassword(t, nil, testShareUUID, `{}`); w.Code != http.StatusNoContent {
+		t.Errorf("unprotected share: status %d, want 204", w.Code)
+	}
+}
+
+func TestVerifySharePasswordBySlug(t *testing.T) {
+	hash := mustHash(t, "correct horse")
+	if w := verifyPassword(t, &hash, testShareSlug, `{"password": "correct horse"}`); w.Code != http.StatusNoContent {
+		t.Errorf("right password: status %d, want 204", w.Code)
+	}
+	if w := verifyPassword(t, &hash, testShareSlug, `{"password": "battery staple"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want 401", w.Code)
+	}
+	if w := verifyPassword(t, &hash, "no-such-share", `{"password": "correct horse"}`); w.Code != http.StatusNotFound {
+		t.Errorf("unknown slug: status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+	"file-sharing-backend/internal/models"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	previewProviderName = "FileShare"
+	// previewThumbnailMaxSize bounds the images served as link thumbnails,
+	// which are sent without counting a download
+	previewThumbnailMaxSize = 10 << 20
+)
+
+// sharePreview is what a link preview may show about a share. Locked
+// shares (password-protected, encrypted or closed for downloads) reveal
+// nothing about their content.
+type sharePreview struct {
+	UUID        string
+	Title       string
+	Description string
+	Locked      bool
+	// Thumbnail is set for unlocked images with a generated thumbnail or
+	// small enough to serve as one
+	Thumbnail       *models.File
+	ThumbnailWidth  int
+	ThumbnailHeight int
+}
+
+// loadSharePreview builds the preview of an unexpired share, answering the
+// request itself when there is none.
+func (h *FileHandler) loadSharePreview(c *gin.Context, fileUUID string) (*sharePreview, bool) {
+	var file models.File
+	var ownerVerified bool
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT id, original_name, file_path, file_size, mime_type, password_hash IS NOT NULL,
+			       is_encrypted, expires_at, download_enabled_until, scan_status, disabled_at,
+			       COALESCE((SELECT email_verified FROM users WHERE id = files.user_id), TRUE)
+			FROM files
+			WHERE uuid = $1 AND deleted_at IS NULL`,
+			fileUUID,
+		).Scan(&file.ID, &file.OriginalName, &file.FilePath, &file.FileSize, &file.MimeType, &file.HasPassword,
+			&file.IsEncrypted, &file.ExpiresAt, &file.DownloadEnabledUntil, &file.ScanStatus, &file.DisabledAt,
+			&ownerVerified)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return nil, false
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return nil, false
+	}
+	if time.Now().After(file.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return nil, false
+	}
+	if file.DisabledAt != nil {
+		c.JSON(http.StatusGone, errShareDisabled)
+		return nil, false
+	}
+
+	preview := &sharePreview{UUID: fileUUID}
+	if file.HasPassword || file.IsEncrypted || !downloadEnabled(&file) || file.ScanStatus != services.ScanClean ||
+		(h.blockUnverifiedOwners && !ownerVerified) {
+		preview.Locked = true
+		preview.Title = "Protected file"
+		preview.Description = "Open the link to access this file"
+		return preview, true
+	}
+
+	preview.Title = file.OriginalName
+	preview.Description = fmt.Sprintf("%s, %s", formatSize(file.FileSize), file.MimeType)
+	if !strings.HasPrefix(file.MimeType, "image/") || executableType(file.MimeType) {
+		return preview, true
+	}
+	// The generated thumbnail is preferred, falling back to small originals
+	thumbnail := h.storedThumbnail(file.ID)
+	if thumbnail == nil && file.FileSize <= previewThumbnailMaxSize {
+		thumbnail = &file
+	}
+	if thumbnail != nil {
+		if f, err := h.storage.Open(thumbnail.FilePath); err == nil {
+			config, _, err := image.DecodeConfig(f)
+			f.Close()
+			if err == nil {
+				preview.Thumbnail = thumbnail
+				preview.ThumbnailWidth, preview.ThumbnailHeight = config.Width, config.Height
+			}
+		}
+	}
+	return preview, true
+}
+
+// GetSharePreview returns Open Graph properties for a share so the frontend
+// can render link previews.
+func (h *FileHandler) GetSharePreview(c *gin.Context) {
+	fileUUID, ok := h.shareParam(c)
+	if !ok {
+		return
+	}
+	preview, ok := h.loadSharePreview(c, fileUUID)
+	if !ok {
+		return
+	}
+
+	openGraph := gin.H{
+		"og:site_name":   previewProviderName,
+		"og:type":        "website",
+		"og:title":       preview.Title,
+		"og:description": preview.Description,
+		"og:url":         middleware.ExternalURL(c, "/share/"+preview.UUID),
+	}
+	if preview.Thumbnail != nil {
+		openGraph["og:image"] = middleware.ExternalURL(c, "/api/files/info/"+preview.UUID+"/thumbnail")
+		openGraph["og:image:type"] = preview.Thumbnail.MimeType
+		openGraph["og:image:width"] = preview.ThumbnailWidth
+		openGraph["og:image:height"] = preview.ThumbnailHeight
+	}
+
+	c.JSON(http.StatusOK, gin.H{"locked": preview.Locked, "open_graph": openGraph})
+}
+
+// GetOEmbed implements the oEmbed JSON endpoint for share links
+// (url=<origin>/share/<uuid>).
+func (h *FileHandler) GetOEmbed(c *gin.Context) {
+	if format := c.DefaultQuery("format", "json"); format != "json" {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Only the json format is supported"})
+		return
+	}
+	shareURL, err := url.Parse(c.Query("url"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url"})
+		return
+	}
+	fileUUID, ok := strings.CutPrefix(shareURL.Path, "/share/")
+	if !ok || fileUUID == "" || strings.Contains(fileUUID, "/") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not a share link"})
+		return
+	}
+
+	preview, ok := h.loadSharePreview(c, fileUUID)
+	if !ok {
+		return
+	}
+
+	embed := gin.H{
+		"version":       "1.0",
+		"type":          "link",
+		"title":         preview.Title,
+		"provider_name": previewProviderName,
+		"provider_url":  middleware.ExternalURL(c, "/"),
+	}
+	if preview.Thumbnail != nil {
+		embed["thumbnail_url"] = middleware.ExternalURL(c, "/api/files/info/"+preview.UUID+"/thumbnail")
+		embed["thumbnail_width"] = preview.ThumbnailWidth
+		embed["thumbnail_height"] = preview.ThumbnailHeight
+	}
+	c.JSON(http.StatusOK, embed)
+}
+
+// GetShareThumbnail serves the image of an unlocked image share for link
+// previews. It is not counted as a download.
+func (h *FileHandler) GetShareThumbnail(c *gin.Context) {
+	fileUUID, ok := h.shareParam(c)
+	if !ok {
+		return
+	}
+	preview, ok := h.loadSharePreview(c, fileUUID)
+	if !ok {
+		return
+	}
+	if preview.Thumbnail == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail for this file"})
+		return
+	}
+
+	c.Header("Content-Type", preview.Thumbnail.MimeType)
+	c.Header("Content-Disposition", "inline")
+	c.Header("Cache-Control", "public, max-age=3600")
+	if h.inline.csp != "" {
+		c.Header("Content-Security-Policy", h.inline.csp)
+	}
+	h.serveBlob(c, preview.Thumbnail.FilePath, 0)
+}
+
+// formatSize renders a byte count for humans, e.g. "4.2 MB".
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
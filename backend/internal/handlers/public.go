@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPublicPageSize = 20
+	maxPublicPageSize     = 100
+)
+
+// SetPublicListed adds an owned file to or removes it from the owner's
+// public profile. The body is {"public_listed": bool}.
+func (h *FileHandler) SetPublicListed(c *gin.Context) {
+	var req struct {
+		PublicListed *bool `json:"public_listed" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "public_listed must be true or false"})
+		return
+	}
+
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.db.Exec("UPDATE files SET public_listed = $1, updated_at = NOW() WHERE id = $2", *req.PublicListed, fileID); err != nil {
+		respondDBError(c, err, "Failed to update listing")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"public_listed": *req.PublicListed})
+}
+
+// GetPublicFiles lists the unexpired files a user chose to show on their
+// public profile, newest first. Pages are selected with page and per_page.
+func (h *FileHandler) GetPublicFiles(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
+		return
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPublicPageSize)))
+	if err != nil || perPage < 1 || perPage > maxPublicPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "per_page must be between 1 and 100"})
+		return
+	}
+
+	var total int
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow(
+			"SELECT COUNT(*) FROM files WHERE user_id = $1 AND public_listed AND expires_at > NOW() AND deleted_at IS NULL AND disabled_at IS NULL",
+			userID,
+		).Scan(&total)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch public files")
+		return
+	}
+
+	rows, err := h.db.QueryRetry(`
+		SELECT uuid, original_name, file_size, mime_type, password_hash IS NOT NULL, is_encrypted, expires_at
+		FROM files
+		WHERE user_id = $1 AND public_listed AND expires_at > NOW() AND deleted_at IS NULL AND disabled_at IS NULL
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`,
+		userID, perPage, (page-1)*perPage,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch public files")
+		return
+	}
+	defer rows.Close()
+
+	entries := []albumEntry{}
+	for rows.Next() {
+		var entry albumEntry
+		if err := rows.Scan(&entry.UUID, &entry.OriginalName, &entry.FileSize, &entry.MimeType,
+			&entry.HasPassword, &entry.IsEncrypted, &entry.ExpiresAt); err != nil {
+			continue
+		}
+		entry.ShareURL = middleware.ExternalURL(c, "/share/"+entry.UUID)
+		entries = append(entries, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  userID,
+		"files":    entries,
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+	})
+}
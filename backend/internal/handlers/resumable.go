@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/middleware"
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads follow the tus protocol loosely: an upload is created
+// with its name and size, its bytes are appended in chunks at the offset the
+// server reports, and once complete it is turned into a file like a regular
+// upload. Every chunk is kept as a staged blob until then, so uploads work
+// with any storage backend and across server instances.
+
+// resumableUploadContentType is the media type chunks must be sent with.
+const resumableUploadContentType = "application/offset+octet-stream"
+
+type resumableUpload struct {
+	id         int
+	fileName   string
+	totalSize  int64
+	offset     int64
+	completing bool
+	createdAt  time.Time
+	updatedAt  time.Time
+}
+
+// CreateResumableUpload starts a resumable upload of file_size bytes. The
+// upload URL is returned in Location as well as in the body.
+func (h *FileHandler) CreateResumableUpload(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		FileName string `json:"file_name" binding:"required"`
+		FileSize *int64 `json:"file_size" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.FileSize < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file_size must be a non-negative number of bytes"})
+		return
+	}
+	name, err := normalizeFilename(req.FileName, h.maxFilenameLength)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.maxFileSize > 0 && *req.FileSize > h.maxFileSize {
+		tooLarge := &fileTooLargeError{name: name, limit: h.maxFileSize}
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":         tooLarge.Error(),
+			"file_name":     name,
+			"max_file_size": h.maxFileSize,
+		})
+		return
+	}
+
+	uploadUUID := uuid.New().String()
+	_, err = h.db.Exec(
+		"INSERT INTO resumable_uploads (uuid, user_id, file_name, total_size) VALUES ($1, $2, $3, $4)",
+		uploadUUID, userID, name, *req.FileSize,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to create upload")
+		return
+	}
+
+	uploadURL := middleware.ExternalURL(c, "/api/uploads/"+uploadUUID)
+	c.Header("Location", uploadURL)
+	c.JSON(http.StatusCreated, gin.H{
+		"upload_id":  uploadUUID,
+		"upload_url": uploadURL,
+		"file_name":  name,
+		"file_size":  *req.FileSize,
+		"offset":     0,
+	})
+}
+
+// lookupResumableUpload loads the caller's upload named in the URL,
+// answering the request itself if there is none.
+func (h *FileHandler) lookupResumableUpload(c *gin.Context) (*resumableUpload, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	var u resumableUpload
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT id, file_name, total_size, upload_offset, completing, created_at, updated_at
+			FROM resumable_uploads
+			WHERE uuid = $1 AND user_id = $2`,
+			c.Param("id"), userID,
+		).Scan(&u.id, &u.fileName, &u.totalSize, &u.offset, &u.completing, &u.createdAt, &u.updatedAt)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to load upload")
+		return nil, false
+	}
+	return &u, true
+}
+
+// GetResumableUpload reports how far an upload has got, in the
+// Upload-Offset and Upload-Length headers and, for GET, in the body. A
+// client resuming after an interruption continues from Upload-Offset.
+func (h *FileHandler) GetResumableUpload(c *gin.Context) {
+	upload, ok := h.lookupResumableUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.totalSize, 10))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"upload_id":  c.Param("id"),
+		"file_name":  upload.fileName,
+		"file_size":  upload.totalSize,
+		"offset":     upload.offset,
+		"completing": upload.completing,
+		"created_at": upload.createdAt,
+		"updated_at": upload.updatedAt,
+	})
+}
+
+// AppendResumableUpload adds the request body to an upload at the offset
+// given in Upload-Offset, which must be the upload's current offset. A chunk
+// is only kept if it arrives completely; after an interrupted request the
+// client asks for the offset again and resends from there.
+func (h *FileHandler) AppendResumableUpload(c *gin.Context) {
+	if c.ContentType() != resumableUploadContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Chunks must be sent as " + resumableUploadContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset must be the byte offset the chunk starts at"})
+		return
+	}
+
+	upload, ok := h.lookupResumableUpload(c)
+	if !ok {
+		return
+	}
+	if upload.completing {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is being completed"})
+		return
+	}
+	if offset != upload.offset {
+		respondOffsetMismatch(c, upload.offset)
+		return
+	}
+
+	// One byte past the remainder is enough to tell that a chunk is too long
+	remaining := upload.totalSize - upload.offset
+	body := &partReader{r: c.Request.Body}
+	staged, size, err := h.storage.Stage(io.LimitReader(body, remaining+1))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(body.err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds the request size limit; send smaller chunks"})
+		case body.err != nil && body.err != io.EOF:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk was not received completely"})
+		default:
+			logging.FromContext(c).Error("saving upload chunk failed", "event", "upload_failed", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save chunk"})
+		}
+		return
+	}
+	if size > remaining {
+		h.storage.Delete(staged)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk extends past the end of the upload", "offset": upload.offset, "file_size": upload.totalSize})
+		return
+	}
+	if size == 0 {
+		h.storage.Delete(staged)
+		c.Header("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	newOffset, err := h.recordChunk(upload.id, offset, size, staged)
+	if err != nil {
+		h.storage.Delete(staged)
+		var mismatch *offsetMismatchError
+		switch {
+		case errors.As(err, &mismatch):
+			respondOffsetMismatch(c, mismatch.offset)
+		case err == sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		default:
+			respondDBError(c, err, "Failed to save chunk")
+		}
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// offsetMismatchError reports that another chunk was appended, or the
+// upload is being completed, while a chunk was being received.
+type offsetMismatchError struct {
+	offset int64
+}
+
+func (e *offsetMismatchError) Error() string {
+	return "upload offset changed"
+}
+
+func respondOffsetMismatch(c *gin.Context, offset int64) {
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the upload's offset", "offset": offset})
+}
+
+// recordChunk adds a staged chunk to an upload if the upload is still at
+// offset, returning the new offset.
+func (h *FileHandler) recordChunk(uploadID int, offset, size int64, staged string) (int64, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var current int64
+	var completing bool
+	err = tx.QueryRow("SELECT upload_offset, completing FROM resumable_uploads WHERE id = $1 FOR UPDATE", uploadID).
+		Scan(&current, &completing)
+	if err != nil {
+		return 0, err
+	}
+	if current != offset || completing {
+		return 0, &offsetMismatchError{offset: current}
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO resumable_upload_chunks (upload_id, chunk_offset, chunk_size, blob_path) VALUES ($1, $2, $3, $4)",
+		uploadID, offset, size, staged,
+	)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(
+		"UPDATE resumable_uploads SET upload_offset = $1, updated_at = NOW() WHERE id = $2",
+		offset+size, uploadID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return offset + size, tx.Commit()
+}
+
+// CompleteResumableUpload turns a fully received upload into a file. It
+// takes the same form fields as a regular upload (password, expires_in,
+// max_downloads, ...) except files, goes through the same checks and
+// answers like it. The upload is removed once its file is stored; if
+// storing fails it can be completed again.
+func (h *FileHandler) CompleteResumableUpload(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+		return
+	}
+	if idempotencyKey != "" {
+		if h.replayIdempotentUpload(c, userID, idempotencyKey) {
+			return
+		}
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadValuesSize)
+	if err := c.Request.ParseMultipartForm(maxUploadValuesSize); err != nil && err != http.ErrNotMultipart {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+		return
+	}
+
+	upload, ok := h.lookupResumableUpload(c)
+	if !ok {
+		return
+	}
+	if upload.offset < upload.totalSize {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is incomplete", "offset": upload.offset, "file_size": upload.totalSize})
+		return
+	}
+
+	// Only one request may turn the upload into a file
+	res, err := h.db.Exec("UPDATE resumable_uploads SET completing = TRUE, updated_at = NOW() WHERE id = $1 AND NOT completing", upload.id)
+	if err != nil {
+		respondDBError(c, err, "Failed to complete upload")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is already being completed"})
+		return
+	}
+
+	logger := logging.FromContext(c)
+	file, err := h.assembleResumableUpload(upload)
+	if err != nil {
+		h.releaseResumableUpload(c, upload.id)
+		logger.Error("assembling resumable upload failed", "event", "upload_failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	form := &uploadForm{values: c.Request.PostForm, files: []*uploadedFile{file}, store: h.storage}
+	defer form.discard()
+	if !h.storeUploads(c, userID, idempotencyKey, form) {
+		h.releaseResumableUpload(c, upload.id)
+		return
+	}
+
+	// The file is stored; a failure here leaves the upload to the cleanup
+	if err := services.DeleteResumableUpload(h.db, h.storage, upload.id); err != nil {
+		logger.Warn("deleting completed upload failed", "event", "upload_cleanup_failed", "error", err)
+	}
+}
+
+// releaseResumableUpload lets an upload that failed to complete be
+// completed again.
+func (h *FileHandler) releaseResumableUpload(c *gin.Context, uploadID int) {
+	if _, err := h.db.Exec("UPDATE resumable_uploads SET completing = FALSE WHERE id = $1", uploadID); err != nil {
+		logging.FromContext(c).Warn("releasing upload failed", "event", "upload_failed", "error", err)
+	}
+}
+
+// assembleResumableUpload joins the chunks of an upload into one staged
+// blob, hashed and sniffed like the files of an upload form.
+func (h *FileHandler) assembleResumableUpload(upload *resumableUpload) (*uploadedFile, error) {
+	rows, err := h.db.Query(
+		"SELECT chunk_offset, chunk_size, blob_path FROM resumable_upload_chunks WHERE upload_id = $1 ORDER BY chunk_offset",
+		upload.id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	var next int64
+	for rows.Next() {
+		var offset, size int64
+		var path string
+		if err := rows.Scan(&offset, &size, &path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if offset != next {
+			rows.Close()
+			return nil, errors.New("upload chunks are not contiguous")
+		}
+		paths = append(paths, path)
+		next += size
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if next != upload.totalSize {
+		return nil, errors.New("upload chunks do not add up to the file size")
+	}
+
+	chunks := &chunkReader{store: h.storage, paths: paths}
+	defer chunks.Close()
+	hash := sha256.New()
+	sniff := &sniffBuffer{}
+	staged, size, err := h.storage.Stage(io.TeeReader(chunks, io.MultiWriter(hash, sniff)))
+	if err != nil {
+		return nil, err
+	}
+	if size != upload.totalSize {
+		h.storage.Delete(staged)
+		return nil, errors.New("assembled upload has the wrong size")
+	}
+	return &uploadedFile{
+		Filename:    upload.fileName,
+		Size:        size,
+		ContentType: http.DetectContentType(sniff.data),
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		staged:      staged,
+	}, nil
+}
+
+// AbortResumableUpload deletes an unfinished upload and its chunks.
+func (h *FileHandler) AbortResumableUpload(c *gin.Context) {
+	upload, ok := h.lookupResumableUpload(c)
+	if !ok {
+		return
+	}
+	if upload.completing {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is being completed"})
+		return
+	}
+	if err := services.DeleteResumableUpload(h.db, h.storage, upload.id); err != nil {
+		respondDBError(c, err, "Failed to delete upload")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// chunkReader reads blobs one after another, opening each only when the
+// previous one is exhausted.
+type chunkReader struct {
+	store storage.Storage
+	paths []string
+	cur   io.ReadCloser
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			f, err := r.store.Open(r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.paths = f, r.paths[1:]
+		}
+		n, err := r.cur.Read(b)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotFound answers requests for unknown paths.
+func NotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+}
+
+// MethodNotAllowed answers requests for a known path with a method it does
+// not support, listing the supported ones in Allow. The route table is read
+// on the first such request, after all routes are registered.
+func MethodNotAllowed(r *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	var routes gin.RoutesInfo
+	return func(c *gin.Context) {
+		once.Do(func() { routes = r.Routes() })
+
+		var allowed []string
+		for _, route := range routes {
+			if routeMatches(route.Path, c.Request.URL.Path) {
+				allowed = append(allowed, route.Method)
+			}
+		}
+		sort.Strings(allowed)
+		c.Header("Allow", strings.Join(allowed, ", "))
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed", "allowed_methods": allowed})
+	}
+}
+
+// routeMatches reports whether path matches a route pattern with :param and
+// trailing *catchall segments.
+func routeMatches(pattern, path string) bool {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "*") {
+			return true
+		}
+		if i >= len(pathParts) {
+			return false
+		}
+		if !strings.HasPrefix(part, ":") && part != pathParts[i] {
+			return false
+		}
+	}
+	return len(patternParts) == len(pathParts)
+}
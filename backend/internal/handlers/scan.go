@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/models"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scanRetryAfter is the Retry-After, in seconds, for files still being
+// scanned.
+const scanRetryAfter = "30"
+
+// scanUploads runs the malware scanner over the staged files of an upload.
+// If a file is infected or the scanner fails, the response has been written
+// and ok is false; the staged blobs are discarded with the form.
+func (h *FileHandler) scanUploads(c *gin.Context, files []*uploadedFile) bool {
+	logger := logging.FromContext(c)
+	for _, file := range files {
+		f, err := h.storage.Open(file.staged)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+			return false
+		}
+		threat, err := h.scanner.Scan(f)
+		f.Close()
+		if err != nil {
+			logger.Error("scanning upload failed", "event", "scan_failed", "file_name", file.Filename, "error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Malware scanning is unavailable, try again later"})
+			return false
+		}
+		if threat != "" {
+			logger.Warn("malware found in upload", "event", "upload_infected", "file_name", file.Filename, "threat", threat)
+			h.events.Publish("infected", "Malware found in uploaded file", map[string]interface{}{
+				"file_name": file.Filename,
+				"threat":    threat,
+			})
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":     fmt.Sprintf("%s contains malware (%s) and was not stored", file.Filename, threat),
+				"file_name": file.Filename,
+				"threat":    threat,
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// scanCleared reports whether the scanner has cleared a file for download.
+// Otherwise the response has been written.
+func scanCleared(c *gin.Context, file *models.File) bool {
+	switch file.ScanStatus {
+	case services.ScanClean:
+		return true
+	case services.ScanPending:
+		c.Header("Retry-After", scanRetryAfter)
+		c.JSON(http.StatusConflict, gin.H{"error": "File is still being scanned for malware", "scan_status": file.ScanStatus})
+	default:
+		c.JSON(http.StatusGone, gin.H{"error": "File was removed because it contains malware", "scan_status": file.ScanStatus})
+	}
+	return false
+}
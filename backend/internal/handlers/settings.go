@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SettingsHandler struct {
+	settings *services.SettingsService
+}
+
+func NewSettingsHandler(settings *services.SettingsService) *SettingsHandler {
+	return &SettingsHandler{settings: settings}
+}
+
+// GetConfig exposes the public, client-relevant configuration so the
+// frontend can validate input the same way the server does.
+func (h *SettingsHandler) GetConfig(c *gin.Context) {
+	policy, err := h.settings.PasswordPolicy()
+	if err != nil {
+		respondDBError(c, err, "Failed to load configuration")
+		return
+	}
+	requireSharePassword, err := h.settings.RequireSharePassword()
+	if err != nil {
+		respondDBError(c, err, "Failed to load configuration")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"password_policy":        policy,
+		"require_share_password": requireSharePassword,
+	})
+}
+
+func (h *SettingsHandler) GetPasswordPolicy(c *gin.Context) {
+	policy, err := h.settings.PasswordPolicy()
+	if err != nil {
+		respondDBError(c, err, "Failed to load password policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"password_policy": policy})
+}
+
+func (h *SettingsHandler) UpdatePasswordPolicy(c *gin.Context) {
+	var policy services.PasswordPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if policy.Denylist == nil {
+		policy.Denylist = []string{}
+	}
+
+	if err := h.settings.SetPasswordPolicy(policy); err != nil {
+		if errors.Is(err, services.ErrInvalidPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			respondDBError(c, err, "Failed to save password policy")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Password policy updated successfully",
+		"password_policy": policy,
+	})
+}
+
+func (h *SettingsHandler) GetRequireSharePassword(c *gin.Context) {
+	required, err := h.settings.RequireSharePassword()
+	if err != nil {
+		respondDBError(c, err, "Failed to load setting")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"require_share_password": required})
+}
+
+func (h *SettingsHandler) UpdateRequireSharePassword(c *gin.Context) {
+	var req struct {
+		Required *bool `json:"require_share_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.settings.SetRequireSharePassword(*req.Required); err != nil {
+		respondDBError(c, err, "Failed to save setting")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":                "Setting updated successfully",
+		"require_share_password": *req.Required,
+	})
+}
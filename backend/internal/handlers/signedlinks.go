@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSignedLinkTTL    = time.Hour
+	defaultMaxSignedLinkTTL = 7 * 24 * time.Hour
+
+	// signedLinkExpiresKey holds the expiry of the signed link a download
+	// came through
+	signedLinkExpiresKey = "signed_link_expires"
+)
+
+// signedLinks issues direct download URLs carrying an HMAC over the file
+// UUID and an expiry, so recipients and tools need no API access.
+type signedLinks struct {
+	secret     []byte
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+// loadSignedLinks reads SIGNED_LINK_SECRET (defaulting to JWT_SECRET),
+// SIGNED_LINK_TTL and SIGNED_LINK_MAX_TTL.
+func loadSignedLinks() signedLinks {
+	secret := os.Getenv("SIGNED_LINK_SECRET")
+	if secret == "" {
+		secret = string(middleware.JWTSecret())
+	}
+	s := signedLinks{secret: []byte(secret), defaultTTL: defaultSignedLinkTTL, maxTTL: defaultMaxSignedLinkTTL}
+	if d, err := time.ParseDuration(os.Getenv("SIGNED_LINK_MAX_TTL")); err == nil && d > 0 {
+		s.maxTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SIGNED_LINK_TTL")); err == nil && d > 0 {
+		s.defaultTTL = d
+	}
+	s.defaultTTL = min(s.defaultTTL, s.maxTTL)
+	return s
+}
+
+// sign is domain-separated from share cookies, which may use the same secret.
+func (s signedLinks) sign(fileUUID string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("download\n" + fileUUID + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateSignedLink returns a signed URL downloading an owned file directly,
+// valid for expires_in (default SIGNED_LINK_TTL) but never past the file's
+// own expiry. The password of a protected file is not part of the link and
+// must still be sent with it.
+func (h *FileHandler) CreateSignedLink(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	ttl := h.signedLinks.defaultTTL
+	if v := c.Query("expires_in"); v != "" {
+		d, err := parseExpiresIn(v, h.signedLinks.maxTTL)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ttl = d
+	}
+
+	var fileExpiresAt time.Time
+	var hasPassword bool
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT expires_at, password_hash IS NOT NULL FROM files WHERE id = $1", fileID).
+			Scan(&fileExpiresAt, &hasPassword)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+	if time.Now().After(fileExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+
+	fileUUID := c.Param("uuid")
+	expiresAt := time.Now().Add(ttl)
+	if expiresAt.After(fileExpiresAt) {
+		expiresAt = fileExpiresAt
+	}
+	expires := expiresAt.Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", h.signedLinks.sign(fileUUID, expires))
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":          middleware.ExternalURL(c, "/download/"+fileUUID+"?"+query.Encode()),
+		"expires_at":   time.Unix(expires, 0),
+		"has_password": hasPassword,
+	})
+}
+
+// GetSignedDownload serves a file through a signed link. Everything else
+// is checked as for a share download: the file's expiry and download limits
+// and, for protected files, the password. With S3 storage the client is
+// redirected to a presigned URL expiring with the link.
+func (h *FileHandler) GetSignedDownload(c *gin.Context) {
+	fileUUID := c.Param("uuid")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	signature := c.Query("signature")
+	if err != nil || !hmac.Equal([]byte(signature), []byte(h.signedLinks.sign(fileUUID, expires))) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid link signature"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.JSON(http.StatusGone, gin.H{"error": "Link has expired"})
+		return
+	}
+
+	c.Set(signedLinkExpiresKey, time.Unix(expires, 0))
+	h.serveDownload(c, fileUUID)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"file-sharing-backend/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	minSlugLength = 3
+	maxSlugLength = 64
+)
+
+// slugPattern admits lowercase letters, digits and inner hyphens, which are
+// safe in URLs and hard to confuse with one another.
+var slugPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]*[a-z0-9])?$`)
+
+// reservedSlugs are path segments of existing routes and words likely to be
+// given to new ones, so a slug can never be mistaken for one.
+var reservedSlugs = map[string]bool{
+	"admin": true, "album": true, "api": true, "code": true, "delete": true,
+	"digest": true, "download": true, "downloads": true, "exif": true, "files": true,
+	"folder": true, "folders": true, "gate": true, "health": true, "info": true,
+	"link": true, "login": true, "metadata": true, "metrics": true, "p": true,
+	"password": true, "preview": true, "public": true, "share": true, "stats": true,
+	"thumbnail": true, "unlock": true, "upload": true, "uploads": true, "zip": true,
+}
+
+// parseShareSlug validates the slug form field. Slugs are case-insensitive
+// and stored lowercased; an empty value means the file has none.
+func parseShareSlug(value string) (*string, error) {
+	slug := strings.ToLower(strings.TrimSpace(value))
+	if slug == "" {
+		return nil, nil
+	}
+	if len(slug) < minSlugLength || len(slug) > maxSlugLength || !slugPattern.MatchString(slug) {
+		return nil, fmt.Errorf("slug must be %d to %d lowercase letters, digits or hyphens, not starting or ending with a hyphen", minSlugLength, maxSlugLength)
+	}
+	if _, err := uuid.Parse(slug); err == nil {
+		return nil, errors.New("slug must not be a UUID")
+	}
+	if reservedSlugs[slug] {
+		return nil, fmt.Errorf("slug %q is reserved", slug)
+	}
+	return &slug, nil
+}
+
+// slugTaken reports whether another file already uses slug. Expired files
+// give up their slug first, like their share codes.
+func (h *FileHandler) slugTaken(slug string) (bool, error) {
+	var taken bool
+	err := h.db.Retry(func() error {
+		if _, err := h.db.Exec("UPDATE files SET slug = NULL WHERE slug = $1 AND expires_at <= NOW()", slug); err != nil {
+			return err
+		}
+		return h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM files WHERE slug = $1)", slug).Scan(&taken)
+	})
+	return taken, err
+}
+
+// isSlugViolation reports whether err is a concurrent upload claiming the
+// same slug.
+func isSlugViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "idx_files_slug"
+}
+
+// resolveShareID returns the UUID of the file a share path names, which is
+// either the UUID itself or a slug. Anything that is neither is returned
+// unchanged, so the lookup that follows answers 404.
+func (h *FileHandler) resolveShareID(id string) (string, error) {
+	if _, err := uuid.Parse(id); err == nil {
+		return id, nil
+	}
+	slug := strings.ToLower(id)
+	if !slugPattern.MatchString(slug) {
+		return id, nil
+	}
+
+	var fileUUID string
+	err := h.db.RetryRead(func(db *database.DB) error {
+		return db.QueryRow("SELECT uuid FROM files WHERE slug = $1", slug).Scan(&fileUUID)
+	})
+	if err == sql.ErrNoRows {
+		return id, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fileUUID, nil
+}
+
+// shareParam resolves the share named by the request's :uuid parameter. If
+// the lookup fails the error has been answered and ok is false.
+func (h *FileHandler) shareParam(c *gin.Context) (string, bool) {
+	fileUUID, err := h.resolveShareID(c.Param("uuid"))
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return "", false
+	}
+	return fileUUID, true
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	maxTagLength    = 50
+	maxBulkTagFiles = 500
+)
+
+var errInvalidTag = errors.New("tags must be 1-50 characters and must not contain commas")
+
+// normalizeTag lowercases and trims a tag so "Work" and "work " are the same.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || strings.Contains(tag, ",") {
+		return "", errInvalidTag
+	}
+	return tag, nil
+}
+
+type bulkTagRequest struct {
+	Tag   string   `json:"tag" binding:"required"`
+	UUIDs []string `json:"uuids" binding:"required"`
+}
+
+type bulkFileResult struct {
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+}
+
+// AddTagToFiles tags many of the caller's files at once.
+func (h *FileHandler) AddTagToFiles(c *gin.Context) {
+	h.bulkTag(c, true)
+}
+
+// RemoveTagFromFiles untags many of the caller's files at once.
+func (h *FileHandler) RemoveTagFromFiles(c *gin.Context) {
+	h.bulkTag(c, false)
+}
+
+// bulkTag adds or removes a tag on every listed file in one transaction and
+// reports the outcome per file. Files that do not exist or belong to someone
+// else are reported as not_found.
+func (h *FileHandler) bulkTag(c *gin.Context, add bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req bulkTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tag, err := normalizeTag(req.Tag)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.UUIDs) == 0 || len(req.UUIDs) > maxBulkTagFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("uuids must list between 1 and %d files", maxBulkTagFiles)})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		respondDBError(c, err, "Failed to update tags")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT uuid, id FROM files WHERE uuid = ANY($1) AND user_id = $2", pq.Array(req.UUIDs), userID)
+	if err != nil {
+		respondDBError(c, err, "Failed to update tags")
+		return
+	}
+	owned := make(map[string]int)
+	for rows.Next() {
+		var fileUUID string
+		var fileID int
+		if err := rows.Scan(&fileUUID, &fileID); err != nil {
+			rows.Close()
+			respondDBError(c, err, "Failed to update tags")
+			return
+		}
+		owned[fileUUID] = fileID
+	}
+	rows.Close()
+
+	results := make([]bulkFileResult, 0, len(req.UUIDs))
+	for _, fileUUID := range req.UUIDs {
+		fileID, ok := owned[fileUUID]
+		if !ok {
+			results = append(results, bulkFileResult{UUID: fileUUID, Status: "not_found"})
+			continue
+		}
+
+		var status string
+		if add {
+			res, err := tx.Exec("INSERT INTO file_tags (file_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", fileID, tag)
+			if err != nil {
+				respondDBError(c, err, "Failed to update tags")
+				return
+			}
+			status = "added"
+			if n, _ := res.RowsAffected(); n == 0 {
+				status = "already_tagged"
+			}
+		} else {
+			res, err := tx.Exec("DELETE FROM file_tags WHERE file_id = $1 AND tag = $2", fileID, tag)
+			if err != nil {
+				respondDBError(c, err, "Failed to update tags")
+				return
+			}
+			status = "removed"
+			if n, _ := res.RowsAffected(); n == 0 {
+				status = "not_tagged"
+			}
+		}
+		results = append(results, bulkFileResult{UUID: fileUUID, Status: status})
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondDBError(c, err, "Failed to update tags")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tag": tag, "results": results})
+}
+
+// GetUserTags lists the caller's tags with the number of files carrying each.
+func (h *FileHandler) GetUserTags(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	rows, err := h.db.QueryRetry(`
+		SELECT t.tag, COUNT(*)
+		FROM file_tags t
+		JOIN files f ON f.id = t.file_id
+		WHERE f.user_id = $1
+		GROUP BY t.tag
+		ORDER BY COUNT(*) DESC, t.tag`,
+		userID,
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch tags")
+		return
+	}
+	defer rows.Close()
+
+	tags := []gin.H{}
+	for rows.Next() {
+		var tag string
+		var count int
+		if err := rows.Scan(&tag, &count); err != nil {
+			continue
+		}
+		tags = append(tags, gin.H{"tag": tag, "count": count})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
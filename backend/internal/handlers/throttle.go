@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/models"
+)
+
+// throttleChunk bounds how much is read between pauses, keeping the output
+// smooth at low rates.
+const throttleChunk = 32 << 10
+
+// throttledReader limits reads to rate bytes per second. Seeking restarts
+// the accounting, so http.ServeContent can position it for range requests
+// and only the bytes actually sent are throttled.
+type throttledReader struct {
+	io.ReadSeeker
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.ReadSeeker.Read(p)
+	r.read += int64(n)
+
+	// Sleep until the bytes read so far fit within the rate
+	due := time.Duration(float64(r.read) / float64(r.rate) * float64(time.Second))
+	if wait := due - time.Since(r.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+func (r *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	r.start = time.Time{}
+	r.read = 0
+	return r.ReadSeeker.Seek(offset, whence)
+}
+
+// loadDownloadRateLimit reads DOWNLOAD_RATE_LIMIT in bytes per second; zero
+// or unset means unlimited.
+func loadDownloadRateLimit() int64 {
+	rate, err := strconv.ParseInt(os.Getenv("DOWNLOAD_RATE_LIMIT"), 10, 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// effectiveRateLimit returns the file's own limit if set, else the global one.
+func (h *FileHandler) effectiveRateLimit(file *models.File) int64 {
+	if file.DownloadRateLimit != nil {
+		return *file.DownloadRateLimit
+	}
+	return h.downloadRateLimit
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"file-sharing-backend/internal/models"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetFileThumbnail serves the generated thumbnail of an owned image. While
+// it is still being generated the answer is 202, and files that have none,
+// because they are not images or could not be decoded, get 404.
+func (h *FileHandler) GetFileThumbnail(c *gin.Context) {
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	var status, path sql.NullString
+	err := h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT f.thumbnail_status, v.file_path
+			FROM files f
+			LEFT JOIN file_variants v ON v.file_id = f.id AND v.format = $2
+			WHERE f.id = $1`,
+			fileID, services.ThumbnailFormat,
+		).Scan(&status, &path)
+	})
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+
+	if !path.Valid {
+		if status.String == services.ThumbnailPending {
+			c.Header("Retry-After", "2")
+			c.JSON(http.StatusAccepted, gin.H{"thumbnail_status": status.String})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail for this file", "thumbnail_status": status.String})
+		return
+	}
+
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("Content-Disposition", "inline")
+	c.Header("Cache-Control", "private, max-age=3600")
+	h.serveBlob(c, path.String, 0)
+}
+
+// storedThumbnail returns the generated thumbnail of a file, or nil when it
+// has none.
+func (h *FileHandler) storedThumbnail(fileID int) *models.File {
+	thumbnail := models.File{MimeType: "image/jpeg"}
+	err := h.db.QueryRow(
+		"SELECT file_path, file_size FROM file_variants WHERE file_id = $1 AND format = $2",
+		fileID, services.ThumbnailFormat,
+	).Scan(&thumbnail.FilePath, &thumbnail.FileSize)
+	if err != nil {
+		return nil
+	}
+	return &thumbnail
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenLifetimes controls how long issued credentials stay valid.
+type tokenLifetimes struct {
+	access  time.Duration
+	refresh time.Duration
+}
+
+// loadTokenLifetimes reads ACCESS_TOKEN_TTL (default 15m) and
+// REFRESH_TOKEN_TTL (default 720h).
+func loadTokenLifetimes() tokenLifetimes {
+	lifetimes := tokenLifetimes{access: 15 * time.Minute, refresh: 720 * time.Hour}
+	if d, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && d > 0 {
+		lifetimes.access = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && d > 0 {
+		lifetimes.refresh = d
+	}
+	return lifetimes
+}
+
+// hashToken is what is stored for refresh tokens and API keys, so a leaked
+// table cannot be used to log in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens generates an access token and stores a new refresh token for
+// the user, returning both.
+func (h *AuthHandler) issueTokens(userID int, role string) (access, refresh string, err error) {
+	return h.issueSessionTokens(userID, role, nil)
+}
+
+// loginSession is the login a refresh token belongs to. Refreshes carry it
+// over to the token they issue, so a session keeps its id and start time.
+type loginSession struct {
+	id        int
+	startedAt time.Time
+}
+
+// issueSessionTokens is issueTokens for the session sess. With nil a new
+// session starts.
+func (h *AuthHandler) issueSessionTokens(userID int, role string, sess *loginSession) (access, refresh string, err error) {
+	access, err = h.generateToken(userID, role)
+	if err != nil {
+		return "", "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	refresh = hex.EncodeToString(b)
+
+	// Expired tokens are of no use to anyone; drop them while we're here
+	if _, err := h.db.Exec("DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < NOW()", userID); err != nil {
+		return "", "", err
+	}
+	var sessionID *int
+	var sessionStarted *time.Time
+	if sess != nil {
+		sessionID, sessionStarted = &sess.id, &sess.startedAt
+	}
+	_, err = h.db.Exec(`
+		INSERT INTO refresh_tokens (user_id, token_hash, expires_at, session_id, session_started_at)
+		VALUES ($1, $2, $3, COALESCE($4, nextval('refresh_token_session_seq')), COALESCE($5, CURRENT_TIMESTAMP))`,
+		userID, hashToken(refresh), time.Now().Add(h.tokens.refresh), sessionID, sessionStarted,
+	)
+	if err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// tokenResponse adds freshly issued credentials to a response body.
+func (h *AuthHandler) tokenResponse(body gin.H, access, refresh string) gin.H {
+	body["token"] = access
+	body["refresh_token"] = refresh
+	body["expires_in"] = int(h.tokens.access.Seconds())
+	return body
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RefreshToken exchanges a valid refresh token for a new access token and a
+// new refresh token. Each refresh token works once: presenting one that was
+// already used revokes all of the user's refresh tokens, since it means the
+// token was stolen by someone or replayed.
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokenHash := hashToken(req.RefreshToken)
+
+	// Revoking and reading in one statement keeps concurrent refreshes with
+	// the same token from both succeeding
+	var userID int
+	var role string
+	var sess loginSession
+	err := h.db.QueryRow(`
+		UPDATE refresh_tokens t SET revoked_at = NOW()
+		FROM users u
+		WHERE t.token_hash = $1 AND t.revoked_at IS NULL AND t.expires_at > NOW() AND u.id = t.user_id
+		RETURNING t.user_id, u.role, t.session_id, t.session_started_at`,
+		tokenHash,
+	).Scan(&userID, &role, &sess.id, &sess.startedAt)
+	if err == sql.ErrNoRows {
+		h.revokeReusedToken(tokenHash)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to refresh token")
+		return
+	}
+
+	access, refresh, err := h.issueSessionTokens(userID, role, &sess)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.tokenResponse(gin.H{}, access, refresh))
+}
+
+// revokeReusedToken revokes every refresh token of the owner of an already
+// used token.
+func (h *AuthHandler) revokeReusedToken(tokenHash string) {
+	h.db.Exec(`
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND user_id = (
+			SELECT user_id FROM refresh_tokens WHERE token_hash = $1 AND revoked_at IS NOT NULL
+		)`,
+		tokenHash,
+	)
+}
+
+// Logout revokes a refresh token. Access tokens already issued stay valid
+// until they expire.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := h.db.Exec(
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL",
+		hashToken(req.RefreshToken),
+	)
+	if err != nil {
+		respondDBError(c, err, "Failed to log out")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RestoreFile takes a file of the caller out of the trash, as long as it is
+// within the retention period and the user is below their active file cap.
+func (h *FileHandler) RestoreFile(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var fileID, ownerID int
+	var deletedAt *time.Time
+	var purgeDue bool
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow(`
+			SELECT id, user_id, deleted_at, COALESCE(deleted_at < NOW() - $2 * INTERVAL '1 second', FALSE)
+			FROM files
+			WHERE uuid = $1`,
+			c.Param("uuid"), int(h.trashRetention.Seconds()),
+		).Scan(&fileID, &ownerID, &deletedAt, &purgeDue)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Database error")
+		return
+	}
+	if ownerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if deletedAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "File is not in the trash"})
+		return
+	}
+	// The cleanup job has not purged it yet, but it is due
+	if purgeDue {
+		c.JSON(http.StatusGone, gin.H{"error": "File can no longer be restored"})
+		return
+	}
+
+	activeFiles, maxActiveFiles, err := activeFileUsage(h.db, userID, h.maxActiveFiles)
+	if err != nil {
+		respondDBError(c, err, "Failed to check file limit")
+		return
+	}
+	if maxActiveFiles > 0 && activeFiles >= maxActiveFiles {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            fmt.Sprintf("You can have at most %d active files; delete some or wait for them to expire", maxActiveFiles),
+			"active_files":     activeFiles,
+			"max_active_files": maxActiveFiles,
+		})
+		return
+	}
+
+	_, err = h.db.Exec("UPDATE files SET deleted_at = NULL, updated_at = NOW() WHERE id = $1", fileID)
+	if err != nil {
+		respondDBError(c, err, "Failed to restore file")
+		return
+	}
+
+	logging.FromContext(c).Info("file restored", "event", "file_restored", "file_uuid", c.Param("uuid"), "user_id", userID)
+	h.events.Publish("restore", "File restored from trash", map[string]interface{}{
+		"file_uuid": c.Param("uuid"),
+		"user_id":   userID,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "File restored"})
+}
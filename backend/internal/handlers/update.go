@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidDisplayName = errors.New("original_name must not contain path separators or control characters")
+
+type updateFileRequest struct {
+	OriginalName *string    `json:"original_name"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+// UpdateFile changes the settings of an owned file given in the body; fields
+// left out stay as they are. Renaming only changes the name downloads are
+// offered under, never the blob on disk. A new expiry must be in the future
+// and at most MAX_FILE_LIFETIME from now, and an expired file cannot be
+// brought back.
+func (h *FileHandler) UpdateFile(c *gin.Context) {
+	var req updateFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.OriginalName == nil && req.ExpiresAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	var name *string
+	if req.OriginalName != nil {
+		valid, err := validDisplayName(*req.OriginalName, h.maxFilenameLength)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name = &valid
+	}
+	if req.ExpiresAt != nil {
+		now := time.Now()
+		if !req.ExpiresAt.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		if req.ExpiresAt.After(now.Add(h.maxFileLifetime)) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "expires_at is further away than the maximum file lifetime allows",
+				"max_file_lifetime": h.maxFileLifetime.String(),
+			})
+			return
+		}
+	}
+
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	// The expiry check is part of the update so a file expiring meanwhile
+	// is not revived
+	var originalName string
+	var expiresAt time.Time
+	err := h.db.QueryRow(`
+		UPDATE files
+		SET original_name = COALESCE($1, original_name), expires_at = COALESCE($2, expires_at), updated_at = NOW()
+		WHERE id = $3 AND ($2::timestamp IS NULL OR expires_at > NOW())
+		RETURNING original_name, expires_at`,
+		name, req.ExpiresAt, fileID,
+	).Scan(&originalName, &expiresAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to update file")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"uuid": c.Param("uuid"), "original_name": originalName, "expires_at": expiresAt})
+}
+
+// validDisplayName checks a name chosen for an existing file. Unlike upload
+// names, which are repaired, a chosen name with path separators or control
+// characters is refused.
+func validDisplayName(name string, maxLength int) (string, error) {
+	if strings.ContainsAny(name, `/\`) || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", errInvalidDisplayName
+	}
+	return normalizeFilename(name, maxLength)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+)
+
+// A new expiry is capped at MAX_FILE_LIFETIME from now, however long ago the
+// file was uploaded.
+func TestUpdateFileExpiryCap(t *testing.T) {
+	const maxLifetime = 30 * 24 * time.Hour
+	uploadedAt := time.Now().Add(-20 * 24 * time.Hour)
+	latest := time.Now().Add(maxLifetime)
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      int
+	}{
+		{name: "within the lifetime from the upload", expiresAt: uploadedAt.Add(maxLifetime - time.Hour), want: http.StatusOK},
+		{name: "past the lifetime from the upload but within it from now", expiresAt: uploadedAt.Add(maxLifetime + time.Hour), want: http.StatusOK},
+		{name: "past the lifetime from now", expiresAt: latest.Add(time.Hour), want: http.StatusBadRequest},
+		{name: "in the past", expiresAt: time.Now().Add(-time.Hour), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := dbtest.NewEnv(t)
+			env.On("SELECT id, user_id FROM files WHERE uuid = $1").Return(dbtest.Row(int64(1), int64(7)))
+			update := env.On("UPDATE files", "RETURNING original_name, expires_at")
+			update.Do(func(args []driver.Value) (*dbtest.Result, error) {
+				return dbtest.Row("report.pdf", args[1]), nil
+			})
+			h := newFileHandler(env)
+			h.maxFileLifetime = maxLifetime
+
+			body := `{"expires_at": "` + tt.expiresAt.UTC().Format(time.RFC3339Nano) + `"}`
+			req := httptest.NewRequest(http.MethodPatch, "/api/files/"+testShareUUID, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := serve(req, "/api/files/:uuid", asUser(7), h.UpdateFile)
+
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if updated := update.Calls() > 0; updated != (tt.want == http.StatusOK) {
+				t.Errorf("file updated: %v, want %v", updated, tt.want == http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"file-sharing-backend/internal/services"
+	"file-sharing-backend/internal/storage"
+)
+
+// maxUploadValuesSize bounds the combined size of the non-file fields of an
+// upload form, which are the only parts held in memory.
+const maxUploadValuesSize = 10 << 20
+
+var (
+	errUploadValuesTooLarge = errors.New("form fields are too large")
+	errUploadStorage        = errors.New("failed to store uploaded file")
+)
+
+// fileTooLargeError reports a file exceeding MAX_FILE_SIZE.
+type fileTooLargeError struct {
+	name  string
+	limit int64
+}
+
+func (e *fileTooLargeError) Error() string {
+	return fmt.Sprintf("%s exceeds the maximum file size of %d bytes", e.name, e.limit)
+}
+
+// uploadedFile is a file of an upload form, already streamed to a staged
+// blob.
+type uploadedFile struct {
+	Filename string
+	Size     int64
+	// ContentType is sniffed from the first bytes; the type the client
+	// claims is not trusted
+	ContentType string
+	// Checksum is the hex SHA-256 of exactly the bytes staged
+	Checksum string
+	// staged is the blob's temporary path until it is committed
+	staged string
+}
+
+// uploadForm is a multipart upload read part by part. Files are copied
+// straight from the request to staged blobs, so neither memory use nor open
+// descriptors grow with the number or size of files.
+type uploadForm struct {
+	values     map[string][]string
+	files      []*uploadedFile
+	valuesSize int
+	store      storage.Storage
+}
+
+// readUploadForm streams the request's multipart body. Files are accepted
+// in the "files" field; fields may come before or after them. On error no
+// staged blob is left behind.
+func (h *FileHandler) readUploadForm(r *http.Request) (*uploadForm, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &uploadForm{values: make(map[string][]string), store: h.storage}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.discard()
+			return nil, err
+		}
+		err = form.read(part, h.maxFileSize)
+		part.Close()
+		if err != nil {
+			form.discard()
+			return nil, err
+		}
+	}
+}
+
+// read consumes a single part of the form.
+func (f *uploadForm) read(part *multipart.Part, maxFileSize int64) error {
+	name := part.FormName()
+	if name == "" {
+		return nil
+	}
+
+	if part.FileName() == "" {
+		value, err := io.ReadAll(io.LimitReader(part, int64(maxUploadValuesSize-f.valuesSize+1)))
+		if err != nil {
+			return err
+		}
+		if f.valuesSize += len(value); f.valuesSize > maxUploadValuesSize {
+			return errUploadValuesTooLarge
+		}
+		f.values[name] = append(f.values[name], string(value))
+		return nil
+	}
+	if name != "files" {
+		return nil
+	}
+
+	// One byte past the limit is enough to tell that a file is too large
+	body := &partReader{r: part}
+	var src io.Reader = body
+	if maxFileSize > 0 {
+		src = io.LimitReader(body, maxFileSize+1)
+	}
+	// Hashed and sniffed in the same pass; Stage fails unless everything
+	// read is written
+	hash := sha256.New()
+	sniff := &sniffBuffer{}
+	staged, size, err := f.store.Stage(io.TeeReader(src, io.MultiWriter(hash, sniff)))
+	if err != nil {
+		if body.err != nil && body.err != io.EOF {
+			return body.err
+		}
+		return fmt.Errorf("%w: %v", errUploadStorage, err)
+	}
+
+	file := &uploadedFile{
+		Filename:    part.FileName(),
+		Size:        size,
+		ContentType: http.DetectContentType(sniff.data),
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		staged:      staged,
+	}
+	f.files = append(f.files, file)
+	if maxFileSize > 0 && size > maxFileSize {
+		return &fileTooLargeError{name: file.Filename, limit: maxFileSize}
+	}
+	return nil
+}
+
+// value returns the first value of a field, or "".
+func (f *uploadForm) value(key string) string {
+	if values := f.values[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// discard removes the staged blobs of files that were never committed.
+func (f *uploadForm) discard() {
+	for _, file := range f.files {
+		if file.staged != "" {
+			f.store.Delete(file.staged)
+			file.staged = ""
+		}
+	}
+}
+
+// commitBlob moves a staged file to its final blob and reports whether an
+// identical blob already stored was reused instead. With content naming the
+// blob is named by the file's checksum, and tx holds the blob's lock so it
+// cannot be released before the row referencing it is committed.
+func (h *FileHandler) commitBlob(tx *sql.Tx, file *uploadedFile, name string, collision storage.CollisionMode) (string, bool, error) {
+	if !h.contentNames {
+		path, err := h.storage.Commit(file.staged, name, collision)
+		if err != nil {
+			return "", false, err
+		}
+		file.staged = ""
+		return path, false, nil
+	}
+
+	path := h.storage.Path(file.Checksum)
+	if err := services.LockBlob(tx, path); err != nil {
+		return "", false, err
+	}
+	path, err := h.storage.Commit(file.staged, file.Checksum, storage.CollisionError)
+	if errors.Is(err, storage.ErrExists) {
+		h.storage.Delete(file.staged)
+		file.staged = ""
+		return h.storage.Path(file.Checksum), true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	file.staged = ""
+	return path, false, nil
+}
+
+// sniffBuffer keeps the first bytes written to it, as many as content type
+// detection looks at.
+type sniffBuffer struct {
+	data []byte
+}
+
+func (s *sniffBuffer) Write(b []byte) (int, error) {
+	if room := sniffLen - len(s.data); room > 0 {
+		s.data = append(s.data, b[:min(room, len(b))]...)
+	}
+	return len(b), nil
+}
+
+// sniffLen is how many bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// partReader remembers the error reading a part failed with, so a broken
+// request can be told apart from a failing disk.
+type partReader struct {
+	r   io.Reader
+	err error
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil {
+		p.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+	"file-sharing-backend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultEmailVerificationTTL = 48 * time.Hour
+
+var errInvalidVerificationToken = errors.New("invalid verification token")
+
+// errUnverifiedOwner answers downloads of files whose owner has not verified
+// their email address, when UNVERIFIED_OWNER_DOWNLOADS=block.
+var errUnverifiedOwner = gin.H{
+	"error":            "The owner of this file has not verified their email address",
+	"owner_unverified": true,
+}
+
+// emailVerification issues the tokens of email verification links: the user
+// ID and an expiry with an HMAC over both and the email address, so a token
+// stops working if the address changes. Nothing is stored until a token is
+// used.
+type emailVerification struct {
+	secret []byte
+	ttl    time.Duration
+	// required keeps users from uploading until their email is verified
+	// (REQUIRE_EMAIL_VERIFICATION)
+	required bool
+}
+
+// loadEmailVerification reads EMAIL_VERIFICATION_TTL and
+// REQUIRE_EMAIL_VERIFICATION. Tokens are signed with JWT_SECRET.
+func loadEmailVerification() emailVerification {
+	v := emailVerification{
+		secret:   middleware.JWTSecret(),
+		ttl:      defaultEmailVerificationTTL,
+		required: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+	}
+	if d, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL")); err == nil && d > 0 {
+		v.ttl = d
+	}
+	return v
+}
+
+// sign is domain-separated from access tokens and other signed values
+// sharing the secret.
+func (v emailVerification) sign(userID int, email string, expires int64) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte("verify-email\n" + strconv.Itoa(userID) + "\n" + email + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (v emailVerification) token(userID int, email string, expires int64) string {
+	return fmt.Sprintf("%d.%d.%s", userID, expires, v.sign(userID, email, expires))
+}
+
+// parse splits a token without checking its signature.
+func (v emailVerification) parse(token string) (userID int, expires int64, signature string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, 0, "", errInvalidVerificationToken
+	}
+	userID, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, "", errInvalidVerificationToken
+	}
+	expires, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, "", errInvalidVerificationToken
+	}
+	return userID, expires, parts[2], nil
+}
+
+// sendVerificationEmail mails the user a link verifying their address.
+func (h *AuthHandler) sendVerificationEmail(c *gin.Context, userID int, email string) error {
+	expiresAt := time.Now().Add(h.verification.ttl)
+	token := h.verification.token(userID, email, expiresAt.Unix())
+	link := middleware.ExternalURL(c, "/api/auth/verify?token="+url.QueryEscape(token))
+	body := "Confirm your email address by opening this link:\n\n" + link + "\n\n" +
+		"The link is valid until " + expiresAt.UTC().Format("2006-01-02 15:04 MST") + ". " +
+		"If you did not create an account, you can ignore this email.\n"
+	return h.mailer.Send(email, "Confirm your email address", body)
+}
+
+// VerifyEmail marks the account a verification link was sent for as
+// verified. Using a link again is harmless.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	userID, expires, signature, err := h.verification.parse(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification link"})
+		return
+	}
+
+	var email string
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&email)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		respondDBError(c, err, "Failed to verify email")
+		return
+	}
+	email = normalizeEmail(email)
+	if err == sql.ErrNoRows || !hmac.Equal([]byte(signature), []byte(h.verification.sign(userID, email, expires))) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification link"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.JSON(http.StatusGone, gin.H{"error": "Verification link has expired; request a new one"})
+		return
+	}
+
+	_, err = h.db.Exec("UPDATE users SET email_verified = TRUE, updated_at = NOW() WHERE id = $1 AND NOT email_verified", userID)
+	if err != nil {
+		respondDBError(c, err, "Failed to verify email")
+		return
+	}
+	logging.FromContext(c).Info("email verified", "event", "email_verified", "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified", "email": email})
+}
+
+// ResendVerificationEmail sends the caller a new verification link.
+func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var email string
+	var verified bool
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT email, email_verified FROM users WHERE id = $1", userID).Scan(&email, &verified)
+	})
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		respondDBError(c, err, "Failed to send verification email")
+		return
+	}
+	if verified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if err := h.sendVerificationEmail(c, userID, normalizeEmail(email)); err != nil {
+		logging.FromContext(c).Error("sending verification email failed", "event", "email_failed", "user_id", userID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to send verification email, try again later"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// RequireVerifiedEmail stops users whose email is not verified when
+// REQUIRE_EMAIL_VERIFICATION is set. It must run after AuthMiddleware.
+func (h *AuthHandler) RequireVerifiedEmail(c *gin.Context) {
+	if !h.verification.required {
+		c.Next()
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var verified bool
+	err = h.db.Retry(func() error {
+		return h.db.QueryRow("SELECT email_verified FROM users WHERE id = $1", userID).Scan(&verified)
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to check email verification")
+		c.Abort()
+		return
+	}
+	if !verified {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Verify your email address first", "email_verified": false})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"file-sharing-backend/internal/database/dbtest"
+)
+
+// sentMail is an email the fake mailer was asked to send.
+type sentMail struct {
+	to, subject, body string
+}
+
+// fakeMailer records emails instead of delivering them, or fails every
+// delivery with err.
+type fakeMailer struct {
+	sent []sentMail
+	err  error
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, sentMail{to: to, subject: subject, body: body})
+	return nil
+}
+
+// verifyUser is user 7, the one signed in to a verification test.
+type verifyUser struct {
+	email    string
+	verified bool
+}
+
+func newVerificationTest(t *testing.T) (*verifyUser, *fakeMailer, *AuthHandler, *dbtest.Rule) {
+	t.Helper()
+	user := &verifyUser{email: "ada@example.com"}
+	env := dbtest.NewEnv(t)
+	env.On("SELECT email, email_verified FROM users WHERE id = $1").Do(func(args []driver.Value) (*dbtest.Result, error) {
+		if args[0] != int64(7) {
+			return nil, nil
+		}
+		return dbtest.Row(user.email, user.verified), nil
+	})
+	env.On("SELECT email FROM users WHERE id = $1").Do(func(args []driver.Value) (*dbtest.Result, error) {
+		if args[0] != int64(7) {
+			return nil, nil
+		}
+		return dbtest.Row(user.email), nil
+	})
+	update := env.On("UPDATE users SET email_verified = TRUE", "AND NOT email_verified")
+	update.Do(func(args []driver.Value) (*dbtest.Result, error) {
+		if args[0] != int64(7) || user.verified {
+			return dbtest.Affected(0), nil
+		}
+		user.verified = true
+		return dbtest.Affected(1), nil
+	})
+	mailer := &fakeMailer{}
+	h := newAuthHandler(env, mailer)
+	h.verification = emailVerification{secret: []byte("test secret"), ttl: time.Hour}
+	return user, mailer, h, update
+}
+
+func resendVerification(h *AuthHandler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/verify/resend", nil)
+	return serve(req, "/api/auth/verify/resend", asUser(7), h.ResendVerificationEmail)
+}
+
+func verifyEmail(h *AuthHandler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+url.QueryEscape(token), nil)
+	return serve(req, "/api/auth/verify", h.VerifyEmail)
+}
+
+var verificationLink = regexp.MustCompile(`https?://\S+/api/auth/verify\?token=\S+`)
+
+// mailedToken returns the token of the verification link in mail.
+func mailedToken(t *testing.T, mail sentMail) string {
+	t.Helper()
+	link, err := url.Parse(verificationLink.FindString(mail.body))
+	if err != nil || link.Query().Get("token") == "" {
+		t.Fatalf("no verification link in %q", mail.body)
+	}
+	return link.Query().Get("token")
+}
+
+func TestVerificationEmailIssued(t *testing.T) {
+	_, mailer, h, _ := newVerificationTest(t)
+
+	if w := resendVerification(h); w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(mailer.sent))
+	}
+	mail := mailer.sent[0]
+	if mail.to != "ada@example.com" || mail.subject != "Confirm your email address" {
+		t.Errorf("sent %q to %s", mail.subject, mail.to)
+	}
+	if token := mailedToken(t, mail); !strings.HasPrefix(token, "7.") {
+		t.Errorf("token %s is not for user 7", token)
+	}
+}
+
+func TestVerificationEmailNotIssued(t *testing.T) {
+	tests := []struct {
+		name       string
+		verified   bool
+		mailErr    error
+		wantStatus int
+	}{
+		{name: "already verified", verified: true, wantStatus: http.StatusConflict},
+		{name: "mail server down", mailErr: errors.New("connection refused"), wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, mailer, h, _ := newVerificationTest(t)
+			user.verified, mailer.err = tt.verified, tt.mailErr
+			if w := resendVerification(h); w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if len(mailer.sent) != 0 {
+				t.Errorf("%d emails sent", len(mailer.sent))
+			}
+		})
+	}
+}
+
+// The mailed link verifies the address once; using it again changes
+// nothing, and a tampered link or one for an old address is refused.
+func TestVerificationLinkConsumed(t *testing.T) {
+	user, mailer, h, _ := newVerificationTest(t)
+	resendVerification(h)
+	token := mailedToken(t, mailer.sent[0])
+
+	tampered := token[:len(token)-1] + "0"
+	if strings.HasSuffix(token, "0") {
+		tampered = token[:len(token)-1] + "1"
+	}
+	for name, bad := range map[string]string{"tampered": tampered, "malformed": "7.abc", "empty": ""} {
+		if w := verifyEmail(h, bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%s token: status %d, want 400", name, w.Code)
+		}
+	}
+	if user.verified {
+		t.Fatal("a bad token verified the address")
+	}
+
+	if w := verifyEmail(h, token); w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+	}
+	if !user.verified {
+		t.Fatal("the address is not verified")
+	}
+	if w := verifyEmail(h, token); w.Code != http.StatusOK {
+		t.Errorf("second use: status %d, want 200", w.Code)
+	}
+
+	// Changing the address invalidates links sent to the old one
+	user.email, user.verified = "ada@example.org", false
+	if w := verifyEmail(h, token); w.Code != http.StatusBadRequest {
+		t.Errorf("link for the old address: status %d, want 400", w.Code)
+	}
+	if user.verified {
+		t.Error("a link for the old address verified the new one")
+	}
+}
+
+func TestVerificationLinkExpires(t *testing.T) {
+	user, mailer, h, update := newVerificationTest(t)
+	// Issue a link that is already past its expiry
+	h.verification.ttl = -time.Minute
+	resendVerification(h)
+	token := mailedToken(t, mailer.sent[0])
+
+	if w := verifyEmail(h, token); w.Code != http.StatusGone {
+		t.Errorf("status %d, want 410", w.Code)
+	}
+	if user.verified || update.Calls() != 0 {
+		t.Error("an expired link verified the address")
+	}
+
+	// A new link works again
+	h.verification.ttl = time.Hour
+	resendVerification(h)
+	token = mailedToken(t, mailer.sent[1])
+	if w := verifyEmail(h, token); w.Code != http.StatusOK || !user.verified {
+		t.Errorf("new link: status %d, verified %v; want 200 and verified", w.Code, user.verified)
+	}
+}
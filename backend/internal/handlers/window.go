@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"file-sharing-backend/internal/models"
+	"file-sharing-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// downloadEnabled reports whether the share of an unexpired file still
+// serves downloads.
+func downloadEnabled(file *models.File) bool {
+	return file.DownloadEnabledUntil == nil || time.Now().Before(*file.DownloadEnabledUntil)
+}
+
+// downloadsExhausted reports whether a file has used up its download cap.
+func downloadsExhausted(file *models.File) bool {
+	return file.MaxDownloads != nil && file.DownloadCount >= *file.MaxDownloads
+}
+
+// downloadsRemaining returns how many downloads a capped file has left, or
+// nil when it is unlimited.
+func downloadsRemaining(file *models.File) *int {
+	if file.MaxDownloads == nil {
+		return nil
+	}
+	remaining := max(*file.MaxDownloads-file.DownloadCount, 0)
+	return &remaining
+}
+
+// burnFile deletes a one-time file once its download has been served. The
+// download gate was already consumed, so the file is deleted even if the
+// client went away mid-transfer; anything left behind is swept by cleanup.
+func (h *FileHandler) burnFile(logger *slog.Logger, fileID int, path, fileUUID string) {
+	if err := services.RemoveImageVariants(h.db, h.storage, fileID); err != nil {
+		logger.Error("deleting image variants failed", "event", "blob_delete_failed", "file_uuid", fileUUID, "error", err)
+	}
+	if err := h.history.DeleteFileRecord(fileID); err != nil {
+		logger.Error("deleting one-time file failed", "event", "one_time_delete_failed", "file_uuid", fileUUID, "error", err)
+		return
+	}
+	if err := services.ReleaseBlob(h.db, h.storage, fileID, path); err != nil {
+		logger.Error("deleting blob failed", "event", "blob_delete_failed", "file_uuid", fileUUID, "path", path, "error", err)
+	}
+	logger.Info("one-time file deleted after download", "event", "one_time_deleted", "file_uuid", fileUUID)
+	h.events.Publish("delete", "One-time file deleted after download", map[string]interface{}{
+		"file_uuid": fileUUID,
+	})
+}
+
+// UpdateDownloadWindow lets an owner close, reopen or extend the share of a
+// file independently of its expiry. The body is either
+// {"download_enabled_until": "<RFC 3339 time>" | null} or {"enabled": bool};
+// null and true keep downloads enabled until the file expires.
+func (h *FileHandler) UpdateDownloadWindow(c *gin.Context) {
+	var req struct {
+		DownloadEnabledUntil *time.Time `json:"download_enabled_until"`
+		Enabled              *bool      `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	until := req.DownloadEnabledUntil
+	if req.Enabled != nil {
+		if req.DownloadEnabledUntil != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Send either enabled or download_enabled_until"})
+			return
+		}
+		if !*req.Enabled {
+			now := time.Now()
+			until = &now
+		}
+	}
+
+	fileID, ok := h.lookupOwnedFile(c)
+	if !ok {
+		return
+	}
+
+	var expiresAt time.Time
+	err := h.db.QueryRow(
+		"UPDATE files SET download_enabled_until = $1, updated_at = NOW() WHERE id = $2 RETURNING expires_at",
+		until, fileID,
+	).Scan(&expiresAt)
+	if err != nil {
+		respondDBError(c, err, "Failed to update download window")
+		return
+	}
+
+	file := models.File{DownloadEnabledUntil: until}
+	c.JSON(http.StatusOK, gin.H{
+		"download_enabled_until": until,
+		"download_enabled":       downloadEnabled(&file),
+		"expires_at":             expiresAt,
+	})
+}
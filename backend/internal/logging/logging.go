@@ -0,0 +1,48 @@
+// Package logging provides the structured JSON logger shared by the server,
+// with a per-request logger carried in the gin context.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const contextKey = "logger"
+
+// New returns a JSON logger writing to w at the level in LOG_LEVEL (debug,
+// info, warn or error; default info).
+func New(w io.Writer) *slog.Logger {
+	var level slog.Level
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// WithContext makes l the logger of the request, so later middleware and
+// handlers log with the fields it carries.
+func WithContext(c *gin.Context, l *slog.Logger) {
+	c.Set(contextKey, l)
+}
+
+// FromContext returns the logger of the request, falling back to the
+// default logger outside of RequestIDMiddleware.
+func FromContext(c *gin.Context) *slog.Logger {
+	if v, ok := c.Get(contextKey); ok {
+		if l, ok := v.(*slog.Logger); ok {
+			return l
+		}
+	}
+	return slog.Default()
+}
@@ -0,0 +1,99 @@
+// Package metrics exposes Prometheus metrics for uploads, downloads, logins,
+// cleanup runs and request latency at /metrics.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	Uploads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "filesharing_uploads_total",
+		Help: "Files stored by uploads.",
+	})
+	Downloads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "filesharing_downloads_total",
+		Help: "Shared file downloads started.",
+	})
+	BytesServed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "filesharing_download_bytes_total",
+		Help: "Bytes of shared files sent to downloaders.",
+	})
+	FailedLogins = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "filesharing_failed_logins_total",
+		Help: "Login attempts refused for wrong credentials or a lockout.",
+	})
+	CleanupRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "filesharing_cleanup_removed_files_total",
+		Help: "Expired files removed by cleanup runs.",
+	})
+	CleanupLastRemoved = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "filesharing_cleanup_last_run_removed_files",
+		Help: "Expired files removed by the most recent cleanup run.",
+	})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "filesharing_http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// RegisterActiveFiles adds a gauge of the unexpired files outside the
+// trash, counted when metrics are scraped.
+func RegisterActiveFiles(db *database.DB) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "filesharing_active_files",
+		Help: "Files that have not expired yet.",
+	}, func() float64 {
+		var n int64
+		if err := db.QueryRow("SELECT COUNT(*) FROM files WHERE expires_at > NOW() AND deleted_at IS NULL").Scan(&n); err != nil {
+			return -1
+		}
+		return float64(n)
+	})
+}
+
+// Middleware observes the duration and status of every request. Requests
+// matching no route are grouped under an empty route to bound cardinality.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		requestDuration.
+			WithLabelValues(c.Request.Method, c.FullPath(), strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveDownload counts the body bytes written for a download. It is
+// deferred by the download handlers, so partial transfers count what was
+// actually sent.
+func ObserveDownload(w gin.ResponseWriter) {
+	if size := w.Size(); size > 0 {
+		BytesServed.Add(float64(size))
+	}
+}
+
+// Handler serves the metrics. With a non-empty token, scrapers must send it
+// as a bearer token.
+func Handler(token string) gin.HandlerFunc {
+	h := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid metrics token"})
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
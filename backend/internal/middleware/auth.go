@@ -2,22 +2,57 @@ package middleware
 
 import (
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 
+	"file-sharing-backend/internal/logging"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 )
 
 type Claims struct {
-	UserID  int  `json:"user_id"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+	// IsAdmin mirrors Role for clients reading the token
 	IsAdmin bool `json:"is_admin"`
 	jwt.StandardClaims
 }
 
-func AuthMiddleware() gin.HandlerFunc {
+// SessionCheck reports whether access tokens issued to a user at issuedAt
+// (Unix seconds) are still honoured, letting a password change or account
+// deletion end sessions before their tokens expire.
+type SessionCheck func(userID int, issuedAt int64) (bool, error)
+
+// APIKeyResolver returns the user a long-lived API key belongs to and
+// their role; ok is false for unknown keys.
+type APIKeyResolver func(key string) (userID int, role string, ok bool, err error)
+
+// AuthMiddleware authenticates requests by their bearer token or, when
+// apiKeys is non-nil, an X-API-Key header. A non-nil check is consulted for
+// every valid bearer token.
+func AuthMiddleware(check SessionCheck, apiKeys APIKeyResolver) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" && apiKeys != nil {
+			userID, role, ok, err := apiKeys(key)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to verify API key"})
+				c.Abort()
+				return
+			}
+			if !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				c.Abort()
+				return
+			}
+			c.Set("user_id", userID)
+			c.Set("role", role)
+			c.Set("is_admin", role == RoleAdmin)
+			logging.WithContext(c, logging.FromContext(c).With("user_id", userID))
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -33,7 +68,11 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		token, err := jwt.ParseWithClaims(bearerToken[1], &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
+			// Only accept the HMAC tokens this server signs itself
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return JWTSecret(), nil
 		})
 
 		if err != nil || !token.Valid {
@@ -49,22 +88,32 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if check != nil {
+			valid, err := check(claims.UserID, claims.IssuedAt)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to verify session"})
+				c.Abort()
+				return
+			}
+			if !valid {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+		}
+
+		role := claimsRole(claims)
 		c.Set("user_id", claims.UserID)
-		c.Set("is_admin", claims.IsAdmin)
+		c.Set("role", role)
+		c.Set("is_admin", role == RoleAdmin)
+		logging.WithContext(c, logging.FromContext(c).With("user_id", claims.UserID))
 		c.Next()
 	}
 }
 
+// AdminMiddleware is RequireRole(RoleAdmin).
 func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		isAdmin, exists := c.Get("is_admin")
-		if !exists || !isAdmin.(bool) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
-			return
-		}
-		c.Next()
-	}
+	return RequireRole(RoleAdmin)
 }
 
 func GetUserID(c *gin.Context) (int, error) {
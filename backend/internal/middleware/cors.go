@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAllowedOrigin is the development frontend, used when neither
+// ALLOWED_ORIGINS nor FRONTEND_URL is set.
+const defaultAllowedOrigin = "http://localhost:3000"
+
+// CORSMiddleware answers cross-origin requests from the origins listed in
+// ALLOWED_ORIGINS (comma-separated, e.g. "https://app.example.com"), which
+// default to the origin of FRONTEND_URL. Listed origins may send
+// credentials. "*" allows any origin, but then without credentials, as
+// browsers reject credentialed responses to a wildcard.
+func CORSMiddleware() (gin.HandlerFunc, error) {
+	origins, err := allowedOrigins()
+	if err != nil {
+		return nil, err
+	}
+
+	config := cors.Config{
+		AllowMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization",
+			"X-API-Key", "X-Request-ID", "Idempotency-Key", "If-Match", "Upload-Offset"},
+		ExposeHeaders: []string{"Content-Disposition", "ETag", "Location", "Retry-After", "X-Request-ID", "Upload-Offset", "Upload-Length"},
+		MaxAge:        12 * time.Hour,
+	}
+	if len(origins) == 1 && origins[0] == "*" {
+		config.AllowAllOrigins = true
+	} else {
+		config.AllowOrigins = origins
+		config.AllowCredentials = true
+	}
+	return cors.New(config), nil
+}
+
+func allowedOrigins() ([]string, error) {
+	value := os.Getenv("ALLOWED_ORIGINS")
+	if strings.TrimSpace(value) == "" {
+		origin := defaultAllowedOrigin
+		if u, err := url.Parse(os.Getenv("FRONTEND_URL")); err == nil && u.Scheme != "" && u.Host != "" {
+			origin = u.Scheme + "://" + u.Host
+		}
+		return []string{origin}, nil
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+				return nil, fmt.Errorf("%q is not an origin such as https://app.example.com", origin)
+			}
+		}
+		origins = append(origins, origin)
+	}
+	if len(origins) > 1 {
+		for _, origin := range origins {
+			if origin == "*" {
+				return nil, fmt.Errorf("\"*\" cannot be combined with other origins")
+			}
+		}
+	}
+	return origins, nil
+}
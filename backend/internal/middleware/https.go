@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const schemeKey = "scheme"
+
+// TrustedProxies parses TRUSTED_PROXIES, a comma-separated list of IPs or
+// CIDRs. An empty list means forwarded headers are trusted from any peer.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+func parseNetworks(proxies []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %q: %v", p, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// HTTPSMiddleware resolves the scheme clients used to reach the service and
+// records it for URL generation and HSTS. EXTERNAL_SCHEME forces the scheme;
+// otherwise it is taken from TLS or from X-Forwarded-Proto sent by a trusted
+// proxy. ENFORCE_HTTPS=redirect sends insecure requests to the https URL and
+// ENFORCE_HTTPS=reject refuses them. Health checks are never enforced.
+func HTTPSMiddleware() gin.HandlerFunc {
+	externalScheme := strings.ToLower(os.Getenv("EXTERNAL_SCHEME"))
+	if externalScheme != "" && externalScheme != "http" && externalScheme != "https" {
+		log.Fatalf("EXTERNAL_SCHEME must be http or https, got %q", externalScheme)
+	}
+	enforce := strings.ToLower(os.Getenv("ENFORCE_HTTPS"))
+	if enforce != "" && enforce != "off" && enforce != "redirect" && enforce != "reject" {
+		log.Fatalf("ENFORCE_HTTPS must be off, redirect or reject, got %q", enforce)
+	}
+	proxies := TrustedProxies()
+	networks := parseNetworks(proxies)
+
+	trusted := func(c *gin.Context) bool {
+		if len(proxies) == 0 {
+			return true
+		}
+		ip := net.ParseIP(c.RemoteIP())
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(c *gin.Context) {
+		scheme := "http"
+		switch {
+		case externalScheme != "":
+			scheme = externalScheme
+		case c.Request.TLS != nil:
+			scheme = "https"
+		case trusted(c) && strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https"):
+			scheme = "https"
+		}
+		c.Set(schemeKey, scheme)
+
+		if scheme != "https" && c.Request.URL.Path != "/health" {
+			switch enforce {
+			case "redirect":
+				target := "https://" + c.Request.Host + c.Request.URL.RequestURI()
+				c.Redirect(http.StatusPermanentRedirect, target)
+				c.Abort()
+				return
+			case "reject":
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "HTTPS is required"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequestScheme returns the external scheme of the request as resolved by
+// HTTPSMiddleware, falling back to the connection itself.
+func RequestScheme(c *gin.Context) string {
+	if scheme := c.GetString(schemeKey); scheme != "" {
+		return scheme
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// ExternalURL turns a path into an absolute URL as seen by the client.
+func ExternalURL(c *gin.Context, path string) string {
+	return RequestScheme(c) + "://" + c.Request.Host + path
+}
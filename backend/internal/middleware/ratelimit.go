@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimitMiddleware allows each client IP at most limit requests per
+// window and answers the rest with 429. Counters are kept in memory, so the
+// limit applies per server instance.
+func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
+	return rateLimit(limit, window, func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// UserRateLimitMiddleware is RateLimitMiddleware keyed on the authenticated
+// user instead of the IP. It must run after AuthMiddleware.
+func UserRateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
+	return rateLimit(limit, window, func(c *gin.Context) string {
+		userID, err := GetUserID(c)
+		if err != nil {
+			return "ip:" + c.ClientIP()
+		}
+		return "user:" + strconv.Itoa(userID)
+	})
+}
+
+func rateLimit(limit int, window time.Duration, key func(c *gin.Context) string) gin.HandlerFunc {
+	var mu sync.Mutex
+	clients := make(map[string]*rateWindow)
+	lastSweep := time.Now()
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		k := key(c)
+
+		mu.Lock()
+		// Drop finished windows now and then so the map does not grow forever
+		if now.Sub(lastSweep) > window {
+			for key, w := range clients {
+				if now.Sub(w.start) >= window {
+					delete(clients, key)
+				}
+			}
+			lastSweep = now
+		}
+
+		w, ok := clients[k]
+		if !ok || now.Sub(w.start) >= window {
+			w = &rateWindow{start: now}
+			clients[k] = w
+		}
+		w.count++
+		count, resetIn := w.count, window-now.Sub(w.start)
+		mu.Unlock()
+
+		if count > limit {
+			c.Header("Retry-After", strconv.Itoa(int(resetIn.Seconds())+1))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TokenBucketMiddleware lets each client IP make bursts of up to perMinute
+// requests, refilled continuously at perMinute per minute, and answers the
+// rest with 429 and a Retry-After header. Unlike the fixed windows of
+// RateLimitMiddleware, clients cannot double their rate at a window boundary.
+// Buckets are kept in memory, so the limit applies per server instance.
+func TokenBucketMiddleware(perMinute int) gin.HandlerFunc {
+	return tokenBucketLimit(perMinute, func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// UserTokenBucketMiddleware is TokenBucketMiddleware keyed on the
+// authenticated user instead of the IP. It must run after AuthMiddleware.
+func UserTokenBucketMiddleware(perMinute int) gin.HandlerFunc {
+	return tokenBucketLimit(perMinute, func(c *gin.Context) string {
+		userID, err := GetUserID(c)
+		if err != nil {
+			return "ip:" + c.ClientIP()
+		}
+		return "user:" + strconv.Itoa(userID)
+	})
+}
+
+func tokenBucketLimit(perMinute int, key func(c *gin.Context) string) gin.HandlerFunc {
+	var mu sync.Mutex
+	buckets := make(map[string]*tokenBucket)
+	lastSweep := time.Now()
+	capacity := float64(perMinute)
+	perSecond := capacity / 60
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		k := key(c)
+
+		mu.Lock()
+		// A bucket idle for a minute is full again, the same as a new one
+		if now.Sub(lastSweep) > time.Minute {
+			for key, b := range buckets {
+				if now.Sub(b.last) >= time.Minute {
+					delete(buckets, key)
+				}
+			}
+			lastSweep = now
+		}
+
+		b, ok := buckets[k]
+		if !ok {
+			b = &tokenBucket{tokens: capacity, last: now}
+			buckets[k] = b
+		}
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+		b.last = now
+		allowed := b.tokens >= 1
+		if allowed {
+			b.tokens--
+		}
+		wait := (1 - b.tokens) / perSecond
+		mu.Unlock()
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			return
+		}
+		c.Next()
+	}
+}
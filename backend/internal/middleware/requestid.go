@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"file-sharing-backend/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validRequestID limits the IDs accepted from clients or proxies, so log
+// lines cannot be forged through the header.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware tags each request with an ID, taken from a valid
+// X-Request-ID header or generated, and echoes it in the response. The
+// request's logger carries the ID, and one line is logged per request once
+// it has been handled.
+func RequestIDMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set("request_id", id)
+		c.Header("X-Request-ID", id)
+		logging.WithContext(c, logger.With("request_id", id))
+
+		start := time.Now()
+		c.Next()
+
+		// The logger may have gained fields such as user_id on the way
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logging.FromContext(c).Log(c.Request.Context(), level, "request handled",
+			"event", "request",
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
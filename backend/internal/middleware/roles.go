@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a user can have, each allowed everything the ones before it are
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// claimsRole returns the role of an access token. Tokens issued before
+// roles existed only carry is_admin.
+func claimsRole(claims *Claims) string {
+	if ValidRole(claims.Role) {
+		return claims.Role
+	}
+	if claims.IsAdmin {
+		return RoleAdmin
+	}
+	return RoleUser
+}
+
+// GetRole returns the role of the authenticated user.
+func GetRole(c *gin.Context) string {
+	if role, ok := c.Get("role"); ok {
+		if s, ok := role.(string); ok && ValidRole(s) {
+			return s
+		}
+	}
+	return RoleUser
+}
+
+// RequireRole lets through users with role or a higher one. It must run
+// after AuthMiddleware.
+func RequireRole(role string) gin.HandlerFunc {
+	rank, ok := roleRanks[role]
+	if !ok {
+		panic("middleware: unknown role " + role)
+	}
+	return func(c *gin.Context) {
+		if roleRanks[GetRole(c)] < rank {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "required_role": role})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
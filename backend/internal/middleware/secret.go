@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+)
+
+// minJWTSecretLength is the shortest JWT_SECRET accepted, matching the
+// 256-bit key size of HS256.
+const minJWTSecretLength = 32
+
+var jwtSecret []byte
+
+// LoadJWTSecret validates JWT_SECRET and makes it available through
+// JWTSecret. It must be called at startup, before any token is signed or
+// checked, so the server never runs with an empty or guessable key.
+func LoadJWTSecret() error {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return fmt.Errorf("JWT_SECRET is not set")
+	}
+	if len(secret) < minJWTSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes, got %d", minJWTSecretLength, len(secret))
+	}
+	jwtSecret = []byte(secret)
+	return nil
+}
+
+// JWTSecret returns the key tokens are signed and verified with.
+func JWTSecret() []byte {
+	return jwtSecret
+}
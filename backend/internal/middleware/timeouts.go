@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LongRunningMiddleware lifts the server's read and write timeouts for the
+// current request. It is applied to routes that legitimately outlive them:
+// large uploads, downloads and event streams. Header reads stay bounded by
+// ReadHeaderTimeout, which is what protects against Slowloris.
+func LongRunningMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		// Errors only mean the writer cannot change deadlines
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		c.Next()
+	}
+}
@@ -5,12 +5,14 @@ import (
 )
 
 type User struct {
-	ID           int       `json:"id" db:"id"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	IsAdmin      bool      `json:"is_admin" db:"is_admin"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID            int       `json:"id" db:"id"`
+	Email         string    `json:"email" db:"email"`
+	PasswordHash  string    `json:"-" db:"password_hash"`
+	Role          string    `json:"role" db:"role"`
+	IsAdmin       bool      `json:"is_admin" db:"is_admin"`
+	EmailVerified bool      `json:"email_verified" db:"email_verified"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
 }
 
 type File struct {
@@ -24,10 +26,30 @@ type File struct {
 	PasswordHash *string   `json:"-" db:"password_hash"`
 	HasPassword  bool      `json:"has_password"`
 	DownloadCount int      `json:"download_count" db:"download_count"`
+	ViewCount    int       `json:"view_count" db:"view_count"`
 	ExpiresAt    time.Time `json:"expires_at" db:"expires_at"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 	IsExpired    bool      `json:"is_expired"`
+	IsEncrypted      bool    `json:"is_encrypted" db:"is_encrypted"`
+	EncryptionParams *string `json:"-" db:"encryption_params"`
+	KeyVerifierHash  *string `json:"-" db:"key_verifier_hash"`
+	FolderUUID       *string `json:"folder_uuid,omitempty" db:"folder_uuid"`
+	RelativePath     *string `json:"relative_path,omitempty" db:"relative_path"`
+	Metadata         map[string]string `json:"metadata,omitempty" db:"metadata"`
+	Tags             []string          `json:"tags,omitempty"`
+	DownloadRateLimit *int64           `json:"download_rate_limit,omitempty" db:"download_rate_limit"`
+	DownloadEnabledUntil *time.Time    `json:"download_enabled_until" db:"download_enabled_until"`
+	PublicListed         bool          `json:"public_listed" db:"public_listed"`
+	MaxConcurrentDownloads *int        `json:"max_concurrent_downloads,omitempty" db:"max_concurrent_downloads"`
+	MaxDownloads         *int          `json:"max_downloads,omitempty" db:"max_downloads"`
+	Checksum             *string       `json:"checksum,omitempty" db:"checksum"`
+	OneTime              bool          `json:"one_time" db:"one_time"`
+	ScanStatus           string        `json:"scan_status" db:"scan_status"`
+	Slug                 *string       `json:"slug,omitempty" db:"slug"`
+	DeletedAt            *time.Time    `json:"deleted_at,omitempty" db:"deleted_at"`
+	DisabledAt           *time.Time    `json:"disabled_at" db:"disabled_at"`
+	ThumbnailStatus      *string       `json:"thumbnail_status,omitempty" db:"thumbnail_status"`
 }
 
 type Download struct {
@@ -45,7 +67,7 @@ type LoginRequest struct {
 
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 }
 
 type UploadResponse struct {
@@ -55,6 +77,11 @@ type UploadResponse struct {
 	FileSize    int64  `json:"file_size"`
 	ExpiresAt   time.Time `json:"expires_at"`
 	HasPassword bool   `json:"has_password"`
+	IsEncrypted bool   `json:"is_encrypted"`
+	RelativePath string `json:"relative_path,omitempty"`
+	Checksum    string `json:"checksum,omitempty"`
+	Slug        string `json:"slug,omitempty"`
+	SlugURL     string `json:"slug_url,omitempty"`
 }
 
 type Stats struct {
@@ -64,4 +91,24 @@ type Stats struct {
 	TotalDownloads int `json:"total_downloads"`
 	TodayDownloads int `json:"today_downloads"`
 	TotalSize      int64 `json:"total_size"`
+	// Range is only reported when a date range was asked for
+	Range *StatsRange `json:"range,omitempty"`
+}
+
+// StatsRange sums activity between two days, inclusive. Uploads only count
+// files that still exist.
+type StatsRange struct {
+	From          string       `json:"from"`
+	To            string       `json:"to"`
+	Uploads       int          `json:"uploads"`
+	UploadedBytes int64        `json:"uploaded_bytes"`
+	Downloads     int          `json:"downloads"`
+	Series        []DailyStats `json:"series"`
+}
+
+type DailyStats struct {
+	Day           string `json:"day"`
+	Uploads       int    `json:"uploads"`
+	UploadedBytes int64  `json:"uploaded_bytes"`
+	Downloads     int    `json:"downloads"`
 }
\ No newline at end of file
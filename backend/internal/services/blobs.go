@@ -0,0 +1,57 @@
+package services
+
+import (
+	"database/sql"
+	"os"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/storage"
+
+	"github.com/lib/pq"
+)
+
+// LockBlob takes a transaction-scoped lock on a blob path. Uploads reusing
+// a blob and deletions releasing it hold the lock, so a blob is never
+// removed between an upload finding it and its row being committed.
+func LockBlob(tx *sql.Tx, path string) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", path)
+	return err
+}
+
+// ReleaseBlob removes the blob of a file unless another file still
+// references it, which happens when identical uploads share a blob
+// (STORAGE_NAMING=content) or after an interrupted cleanup freed a name
+// (STORAGE_NAMING=original). A blob already gone is not an error, so it can
+// be called before or after the file's row is deleted.
+func ReleaseBlob(db *database.DB, store storage.Storage, fileID int, path string) error {
+	return ReleaseBlobOfFiles(db, store, []int{fileID}, path)
+}
+
+// ReleaseBlobOfFiles is ReleaseBlob for a blob shared by several files that
+// are all being deleted, removing it unless a file outside of them still
+// references it.
+func ReleaseBlobOfFiles(db *database.DB, store storage.Storage, fileIDs []int, path string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := LockBlob(tx, path); err != nil {
+		return err
+	}
+	var shared bool
+	err = tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM files WHERE file_path = $1 AND id <> ALL($2))",
+		path, pq.Array(fileIDs),
+	).Scan(&shared)
+	if err != nil {
+		return err
+	}
+	if !shared {
+		if err := store.Delete(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -1,48 +1,138 @@
 package services
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
 	"time"
 
 	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/metrics"
+	"file-sharing-backend/internal/storage"
+)
+
+// Names of the jobs the cleanup service registers
+const (
+	JobCleanupExpiredFiles = "cleanup_expired_files"
+	JobPruneDownloadLogs   = "prune_download_logs"
+	JobCleanupStaleUploads = "cleanup_stale_uploads"
+	JobPurgePasswordResets = "purge_password_resets"
 )
 
 type CleanupService struct {
-	db *database.DB
+	db      *database.DB
+	events  *EventBus
+	history *DownloadHistory
+	jobs    *JobRegistry
+	store   storage.Storage
+	logger  *slog.Logger
+	// logRetention is how long individual download rows are kept; zero
+	// keeps them forever
+	logRetention time.Duration
+	// uploadTTL is how long a resumable upload may go without a new chunk
+	// before it is abandoned
+	uploadTTL time.Duration
+	// trashRetention is how long deleted files can be restored
+	trashRetention time.Duration
+	// unverifiedLifetime is how long files of accounts that have not
+	// verified their email address are kept; zero keeps them until they
+	// expire
+	unverifiedLifetime time.Duration
 }
 
-func NewCleanupService(db *database.DB) *CleanupService {
-	return &CleanupService{db: db}
+// NewCleanupService reads DOWNLOAD_LOG_RETENTION_DAYS (default 365, 0
+// disables pruning), UPLOAD_RESUME_TTL (default 24h),
+// TRASH_RETENTION_DAYS and UNVERIFIED_FILE_LIFETIME, and registers its
+// jobs with jobs so they can be monitored and triggered manually.
+func NewCleanupService(db *database.DB, events *EventBus, history *DownloadHistory, jobs *JobRegistry, store storage.Storage, logger *slog.Logger) *CleanupService {
+	days := 365
+	if v, err := strconv.Atoi(os.Getenv("DOWNLOAD_LOG_RETENTION_DAYS")); err == nil && v >= 0 {
+		days = v
+	}
+	cs := &CleanupService{
+		db:           db,
+		events:       events,
+		history:      history,
+		jobs:         jobs,
+		store:        store,
+		logger:       logger,
+		logRetention: time.Duration(days) * 24 * time.Hour,
+		uploadTTL:    24 * time.Hour,
+
+		trashRetention: TrashRetention(),
+	}
+	if d, err := time.ParseDuration(os.Getenv("UPLOAD_RESUME_TTL")); err == nil && d > 0 {
+		cs.uploadTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("UNVERIFIED_FILE_LIFETIME")); err == nil && d > 0 {
+		cs.unverifiedLifetime = d
+	}
+	jobs.Register(JobCleanupExpiredFiles, cs.CleanupExpiredFiles)
+	jobs.Register(JobPruneDownloadLogs, cs.PruneDownloadLogs)
+	jobs.Register(JobCleanupStaleUploads, cs.CleanupStaleUploads)
+	jobs.Register(JobPurgePasswordResets, cs.PurgePasswordResets)
+	return cs
 }
 
-func (cs *CleanupService) StartCleanupRoutine() {
+// StartCleanupRoutine runs the cleanup jobs every hour until ctx is done. A
+// run in progress at that point is finished, not interrupted.
+func (cs *CleanupService) StartCleanupRoutine(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Hour)
 	go func() {
-		for range ticker.C {
-			cs.CleanupExpiredFiles()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// A run an admin started manually may still be going
+			for _, name := range []string{JobCleanupExpiredFiles, JobPruneDownloadLogs, JobCleanupStaleUploads, JobPurgePasswordResets} {
+				if _, err := cs.jobs.Run(name); err != nil {
+					cs.logger.Warn("skipping scheduled job", "event", "job_skipped", "job", name, "error", err)
+				}
+			}
 		}
 	}()
 }
 
-func (cs *CleanupService) CleanupExpiredFiles() {
-	log.Println("Starting cleanup of expired files...")
+// CleanupExpiredFiles deletes expired files, files that reached their
+// download cap, files in the trash past its retention and, with
+// UNVERIFIED_FILE_LIFETIME, old files of unverified accounts, and returns
+// how many were removed. Failures on single files are logged and reported
+// together.
+//
+// Blobs are removed before their row, and a row is only deleted once its
+// blobs are gone, so a run interrupted by a crash or an I/O error leaves the
+// row in place and the next run finishes the job. Blobs found missing count
+// as deleted. The rows of all files whose blobs are gone are then deleted in
+// a single statement.
+func (cs *CleanupService) CleanupExpiredFiles() (int, error) {
+	cs.logger.Info("starting cleanup of expired files", "event", "cleanup_started")
 
 	query := `
-		SELECT id, file_path, original_name 
+		SELECT id, uuid, file_path, original_name 
 		FROM files 
-		WHERE expires_at < NOW()
+		WHERE expires_at < NOW() OR download_count >= max_downloads OR deleted_at < NOW() - $1 * INTERVAL '1 second'
+		   OR ($2 > 0 AND created_at < NOW() - $2 * INTERVAL '1 second'
+		       AND NOT COALESCE((SELECT email_verified FROM users WHERE id = files.user_id), TRUE))
 	`
 	
-	rows, err := cs.db.Query(query)
+	rows, err := cs.db.Query(query, int(cs.trashRetention.Seconds()), int(cs.unverifiedLifetime.Seconds()))
 	if err != nil {
-		log.Printf("Error querying expired files: %v", err)
-		return
+		cs.logger.Error("querying expired files failed", "event", "cleanup_failed", "error", err)
+		cs.events.Publish("error", "Cleanup failed to query expired files", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return 0, err
 	}
 	defer rows.Close()
 
 	var expiredFiles []struct {
 		ID       int
+		UUID     string
 		FilePath string
 		Name     string
 	}
@@ -50,30 +140,181 @@ func (cs *CleanupService) CleanupExpiredFiles() {
 	for rows.Next() {
 		var file struct {
 			ID       int
+			UUID     string
 			FilePath string
 			Name     string
 		}
-		if err := rows.Scan(&file.ID, &file.FilePath, &file.Name); err != nil {
-			log.Printf("Error scanning expired file: %v", err)
+		if err := rows.Scan(&file.ID, &file.UUID, &file.FilePath, &file.Name); err != nil {
+			cs.logger.Error("scanning expired file failed", "event", "cleanup_failed", "error", err)
 			continue
 		}
 		expiredFiles = append(expiredFiles, file)
 	}
 
+	// Expired files sharing a blob release it together, or each would keep
+	// it for the other
+	idsByPath := make(map[string][]int)
 	for _, file := range expiredFiles {
-		// Delete file from filesystem
-		if err := os.Remove(file.FilePath); err != nil {
-			log.Printf("Error deleting file %s: %v", file.FilePath, err)
+		idsByPath[file.FilePath] = append(idsByPath[file.FilePath], file.ID)
+	}
+
+	var removed, failed int
+	var lastErr error
+	released := make(map[string]error)
+	var ids []int
+	for _, file := range expiredFiles {
+		err, done := released[file.FilePath]
+		if !done {
+			err = ReleaseBlobOfFiles(cs.db, cs.store, idsByPath[file.FilePath], file.FilePath)
+			released[file.FilePath] = err
+		}
+		if err != nil {
+			cs.logger.Error("deleting blob failed, keeping the record for the next run",
+				"event", "cleanup_file_failed", "file_uuid", file.UUID, "path", file.FilePath, "error", err)
+			failed, lastErr = failed+1, err
+			continue
+		}
+		if err := RemoveImageVariants(cs.db, cs.store, file.ID); err != nil {
+			failed, lastErr = failed+1, err
+			continue
+		}
+		ids = append(ids, file.ID)
+	}
+
+	if len(ids) > 0 {
+		if err := cs.history.DeleteFileRecords(ids); err != nil {
+			cs.logger.Error("deleting file records failed, keeping them for the next run",
+				"event", "cleanup_failed", "files", len(ids), "error", err)
+			failed, lastErr = failed+len(ids), err
 		} else {
-			log.Printf("Deleted expired file: %s", file.Name)
+			removed = len(ids)
+			for _, file := range expiredFiles {
+				if released[file.FilePath] == nil {
+					cs.logger.Info("deleted expired file", "event", "file_expired", "file_uuid", file.UUID, "file_name", file.Name)
+				}
+			}
 		}
+	}
 
-		// Delete file record from database
-		_, err := cs.db.Exec("DELETE FROM files WHERE id = $1", file.ID)
+	cs.logger.Info("cleanup completed", "event", "cleanup_completed", "removed", removed, "failed", failed)
+	metrics.CleanupRemoved.Add(float64(removed))
+	metrics.CleanupLastRemoved.Set(float64(removed))
+	cs.events.Publish("cleanup", "Cleanup run completed", map[string]interface{}{
+		"removed": removed,
+		"failed":  failed,
+	})
+	if lastErr != nil {
+		return removed, fmt.Errorf("%d deletions failed, last: %w", failed, lastErr)
+	}
+	return removed, nil
+}
+
+// PruneDownloadLogs deletes download rows older than the retention period,
+// files still existing or not. Their counts are first folded into daily
+// per-file rollups so totals and daily statistics stay correct. It returns
+// the number of rows pruned.
+func (cs *CleanupService) PruneDownloadLogs() (int, error) {
+	if cs.logRetention == 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-cs.logRetention)
+
+	var pruned int64
+	err := func() error {
+		tx, err := cs.db.Begin()
 		if err != nil {
-			log.Printf("Error deleting file record %d: %v", file.ID, err)
+			return err
 		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`
+			INSERT INTO download_rollups (day, file_id, count)
+			SELECT downloaded_at::date, file_id, COUNT(*)
+			FROM downloads
+			WHERE downloaded_at < $1
+			GROUP BY downloaded_at::date, file_id`,
+			cutoff,
+		)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("DELETE FROM downloads WHERE downloaded_at < $1", cutoff)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		pruned, _ = res.RowsAffected()
+		if pruned > 0 {
+			cs.logger.Info("pruned download log entries", "event", "download_logs_pruned", "pruned", pruned, "cutoff", cutoff)
+		}
+		return nil
+	}()
+	if err != nil {
+		cs.logger.Error("pruning download logs failed", "event", "download_logs_prune_failed", "error", err)
+		cs.events.Publish("error", "Cleanup failed to prune download logs", map[string]interface{}{
+			"error": err.Error(),
+		})
 	}
+	return int(pruned), err
+}
 
-	log.Printf("Cleanup completed. Removed %d expired files", len(expiredFiles))
+// CleanupStaleUploads deletes resumable uploads that received no chunk
+// within the resume TTL, and those left behind by deleted accounts, and
+// returns how many were removed.
+func (cs *CleanupService) CleanupStaleUploads() (int, error) {
+	rows, err := cs.db.Query(
+		"SELECT id FROM resumable_uploads WHERE updated_at < $1 OR user_id IS NULL",
+		time.Now().Add(-cs.uploadTTL),
+	)
+	if err != nil {
+		cs.logger.Error("querying stale uploads failed", "event", "cleanup_failed", "error", err)
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var removed, failed int
+	var lastErr error
+	for _, id := range ids {
+		if err := DeleteResumableUpload(cs.db, cs.store, id); err != nil {
+			cs.logger.Error("deleting stale upload failed, keeping it for the next run",
+				"event", "cleanup_upload_failed", "upload_id", id, "error", err)
+			failed, lastErr = failed+1, err
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		cs.logger.Info("deleted stale uploads", "event", "uploads_expired", "removed", removed)
+	}
+	if lastErr != nil {
+		return removed, fmt.Errorf("%d deletions failed, last: %w", failed, lastErr)
+	}
+	return removed, nil
+}
+
+// PurgePasswordResets deletes password reset tokens that were used or have
+// expired and returns how many were deleted.
+func (cs *CleanupService) PurgePasswordResets() (int, error) {
+	res, err := cs.db.Exec("DELETE FROM password_resets WHERE used_at IS NOT NULL OR expires_at < NOW()")
+	if err != nil {
+		cs.logger.Error("purging password resets failed", "event", "cleanup_failed", "error", err)
+		return 0, err
+	}
+	purged, _ := res.RowsAffected()
+	return int(purged), nil
 }
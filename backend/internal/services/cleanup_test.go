@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"file-sharing-backend/internal/database/dbtest"
+	"file-sharing-backend/internal/storage"
+)
+
+// failingStore fails deleting one blob, like a storage outage would.
+type failingStore struct {
+	storage.Storage
+	failPath string
+}
+
+func (s *failingStore) Delete(path string) error {
+	if path == s.failPath {
+		return errors.New("input/output error")
+	}
+	return s.Storage.Delete(path)
+}
+
+// newCleanupTest holds files 1-8, of which 1-3 have expired; 4 and 5 share
+// one blob (content naming) with 5 still live, and 6 and 7 share one with
+// both expired.
+func newCleanupTest(t *testing.T) (*dbtest.Env, *CleanupService, *failingStore) {
+	t.Helper()
+	env := dbtest.NewEnv(t)
+	env.AddFile(t, "a", "a").Expired = true
+	env.AddFile(t, "b", "b").Expired = true
+	env.AddFile(t, "c", "c").Expired = true
+	shared := env.AddFile(t, "shared", "shared")
+	shared.Expired = true
+	env.AddRow("shared", shared.Path)
+	both := env.AddFile(t, "both", "both")
+	both.Expired = true
+	env.AddRow("both", both.Path).Expired = true
+	env.AddFile(t, "live", "live")
+
+	store := &failingStore{Storage: env.Store}
+	cs := NewCleanupService(env.DB, NewEventBus(0), NewDownloadHistory(env.DB), NewJobRegistry(), store, env.Logger)
+	return env, cs, store
+}
+
+// assertConsistent checks that only live files remain, every row has its
+// blob and no blob is left without a row.
+func assertConsistent(t *testing.T, env *dbtest.Env) {
+	t.Helper()
+	referenced := make(map[string]bool)
+	for id, file := range env.Files {
+		if file.Expired {
+			t.Errorf("expired file %d is still there", id)
+		}
+		if !env.HasBlob(file.Path) {
+			t.Errorf("file %d lost its blob", id)
+		}
+		referenced[file.Path] = true
+	}
+	blobs, err := env.Store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blob := range blobs {
+		if !referenced[blob.Path] {
+			t.Errorf("orphaned blob %s", blob.Path)
+		}
+	}
+}
+
+// A run that dies after releasing blobs but before deleting rows leaves
+// rows without blobs; the next run treats the missing blobs as deleted and
+// removes the rows.
+func TestCleanupRecoversFromCrashBeforeRowDelete(t *testing.T) {
+	env, cs, _ := newCleanupTest(t)
+
+	deleteRows := env.On("DELETE FROM files WHERE id = ANY($1)")
+	deleteRows.Fail(errors.New("server closed the connection unexpectedly"))
+	removed, err := cs.CleanupExpiredFiles()
+	if err == nil || removed != 0 {
+		t.Fatalf("interrupted run = %d, %v; want 0 removed and an error", removed, err)
+	}
+	if len(env.Files) != 8 {
+		t.Fatalf("%d rows left after the interrupted run, want all 8", len(env.Files))
+	}
+	if env.HasBlob(env.Files[1].Path) {
+		t.Fatal("the interrupted run should have released the blobs first")
+	}
+
+	deleteRows.Clear()
+	removed, err = cs.CleanupExpiredFiles()
+	if err != nil || removed != 6 {
+		t.Fatalf("recovery run = %d, %v; want 6 removed", removed, err)
+	}
+	assertConsistent(t, env)
+
+	// Nothing is left to do
+	if removed, err := cs.CleanupExpiredFiles(); err != nil || removed != 0 {
+		t.Errorf("third run = %d, %v; want nothing to do", removed, err)
+	}
+}
+
+// A blob that cannot be deleted keeps its row for the next run, while the
+// other files are cleaned up.
+func TestCleanupKeepsRowWhenBlobDeleteFails(t *testing.T) {
+	env, cs, store := newCleanupTest(t)
+
+	store.failPath = env.Files[2].Path
+	removed, err := cs.CleanupExpiredFiles()
+	if err == nil || removed != 5 {
+		t.Fatalf("run with a failing blob = %d, %v; want 5 removed and an error", removed, err)
+	}
+	if _, ok := env.Files[2]; !ok {
+		t.Fatal("the row of the blob that could not be deleted is gone")
+	}
+
+	store.failPath = ""
+	if removed, err := cs.CleanupExpiredFiles(); err != nil || removed != 1 {
+		t.Fatalf("next run = %d, %v; want the remaining file removed", removed, err)
+	}
+	assertConsistent(t, env)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a significant occurrence (upload, download, cleanup run, error)
+// published for live monitoring.
+type Event struct {
+	ID      int64                  `json:"id"`
+	Type    string                 `json:"type"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Time    time.Time              `json:"time"`
+}
+
+// EventBus fans events out to live subscribers and keeps the most recent
+// ones so new subscribers can catch up.
+type EventBus struct {
+	mu          sync.Mutex
+	backlog     []Event
+	backlogSize int
+	nextID      int64
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func NewEventBus(backlogSize int) *EventBus {
+	if backlogSize <= 0 {
+		backlogSize = 100
+	}
+	return &EventBus{
+		backlogSize: backlogSize,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish records an event and delivers it to every subscriber. Slow
+// subscribers whose buffer is full miss the event rather than blocking
+// the publisher.
+func (b *EventBus) Publish(eventType, message string, data map[string]interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event := Event{
+		ID:      b.nextID,
+		Type:    eventType,
+		Message: message,
+		Data:    data,
+		Time:    time.Now(),
+	}
+
+	b.backlog = append(b.backlog, event)
+	if len(b.backlog) > b.backlogSize {
+		b.backlog = b.backlog[len(b.backlog)-b.backlogSize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the current backlog and a channel receiving every event
+// published afterwards. The returned function must be called to unsubscribe.
+func (b *EventBus) Subscribe() ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, 64)
+	backlog := append([]Event(nil), b.backlog...)
+	if b.closed {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, unsubscribe
+}
+
+// Close ends every subscription, letting long-lived streams finish during
+// shutdown. Later subscribers get the backlog and a closed channel.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+
+	"file-sharing-backend/internal/database"
+
+	"github.com/lib/pq"
+)
+
+// DownloadHistory applies the download history retention policy when file
+// records are deleted. With RETAIN_DOWNLOAD_HISTORY enabled, download rows
+// outlive their file with the file reference, IP address and user agent
+// cleared, so aggregate download statistics stay accurate. Otherwise they are
+// removed along with the file.
+type DownloadHistory struct {
+	db     *database.DB
+	retain bool
+}
+
+func NewDownloadHistory(db *database.DB) *DownloadHistory {
+	retain, _ := strconv.ParseBool(os.Getenv("RETAIN_DOWNLOAD_HISTORY"))
+	return &DownloadHistory{db: db, retain: retain}
+}
+
+// Retained reports whether download rows outlive their file.
+func (h *DownloadHistory) Retained() bool {
+	return h.retain
+}
+
+// DeleteFileRecord deletes a file row and handles its download history in
+// the same transaction.
+func (h *DownloadHistory) DeleteFileRecord(fileID int) error {
+	return h.DeleteFileRecords([]int{fileID})
+}
+
+// DeleteFileRecords deletes several file rows and handles their download
+// history in a single transaction, so either all of them are gone or none.
+func (h *DownloadHistory) DeleteFileRecords(fileIDs []int) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := h.deleteFiles(tx, fileIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteFiles deletes file rows inside tx, first applying the retention
+// policy to their download history.
+func (h *DownloadHistory) deleteFiles(tx *sql.Tx, fileIDs []int) error {
+	var err error
+	ids := pq.Array(fileIDs)
+	if h.retain {
+		// file_id is cleared by ON DELETE SET NULL, here and in download_rollups
+		_, err = tx.Exec("UPDATE downloads SET ip_address = NULL, user_agent = NULL WHERE file_id = ANY($1)", ids)
+	} else {
+		_, err = tx.Exec("DELETE FROM downloads WHERE file_id = ANY($1)", ids)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM download_rollups WHERE file_id = ANY($1)", ids)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM files WHERE id = ANY($1)", ids)
+	return err
+}
+
+// DeletedFile identifies the blobs of a deleted file row.
+type DeletedFile struct {
+	ID       int
+	Path     string
+	Variants []string
+}
+
+// DeleteUser deletes a user together with their files and the files'
+// download history in one transaction, returning the deleted files so their
+// blobs can be released. Rows referencing the user, such as refresh tokens,
+// go with it through ON DELETE CASCADE. The user row is locked first, so
+// uploads racing the deletion either finish before it or fail.
+func (h *DownloadHistory) DeleteUser(userID int) ([]DeletedFile, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow("SELECT id FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT id, file_path FROM files WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	var files []DeletedFile
+	var ids []int
+	for rows.Next() {
+		var f DeletedFile
+		if err := rows.Scan(&f.ID, &f.Path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, f)
+		ids = append(ids, f.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) > 0 {
+		// Variant rows go with their files, so their paths are read first
+		rows, err := tx.Query("SELECT file_id, file_path FROM file_variants WHERE file_id = ANY($1)", pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		index := make(map[int]int, len(files))
+		for i, f := range files {
+			index[f.ID] = i
+		}
+		for rows.Next() {
+			var fileID int
+			var path string
+			if err := rows.Scan(&fileID, &path); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			files[index[fileID]].Variants = append(files[index[fileID]].Variants, path)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := h.deleteFiles(tx, ids); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+		return nil, err
+	}
+	return files, tx.Commit()
+}
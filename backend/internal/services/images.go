@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/storage"
+)
+
+// imageFormat describes a web-friendly variant format and the external
+// encoder used to produce it.
+type imageFormat struct {
+	name           string
+	mimeType       string
+	encoderEnv     string
+	defaultEncoder string
+	args           func(quality int, in, out string) []string
+}
+
+var imageFormats = map[string]imageFormat{
+	"webp": {
+		name:           "webp",
+		mimeType:       "image/webp",
+		encoderEnv:     "CWEBP_PATH",
+		defaultEncoder: "cwebp",
+		args: func(quality int, in, out string) []string {
+			return []string{"-quiet", "-metadata", "none", "-q", strconv.Itoa(quality), in, "-o", out}
+		},
+	},
+	"avif": {
+		name:           "avif",
+		mimeType:       "image/avif",
+		encoderEnv:     "AVIFENC_PATH",
+		defaultEncoder: "avifenc",
+		args: func(quality int, in, out string) []string {
+			return []string{"-q", strconv.Itoa(quality), in, out}
+		},
+	},
+}
+
+// convertibleTypes are the source types both encoders accept.
+var convertibleTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+type imageJob struct {
+	fileID int
+	path   string
+}
+
+// ImageVariantService converts uploaded images to smaller web-friendly
+// formats in the background. It is disabled unless IMAGE_VARIANTS lists at
+// least one format.
+type ImageVariantService struct {
+	db       *database.DB
+	formats  []imageFormat
+	encoders map[string]string
+	quality  int
+	timeout  time.Duration
+	queue    chan imageJob
+	jobs     *JobRegistry
+	store    storage.Storage
+	logger   *slog.Logger
+}
+
+// JobImageVariants is the job each conversion is recorded under.
+const JobImageVariants = "image_variants"
+
+func NewImageVariantService(db *database.DB, jobs *JobRegistry, store storage.Storage, logger *slog.Logger) *ImageVariantService {
+	s := &ImageVariantService{
+		db:       db,
+		jobs:     jobs,
+		store:    store,
+		logger:   logger,
+		encoders: make(map[string]string),
+		quality:  80,
+		timeout:  2 * time.Minute,
+		queue:    make(chan imageJob, 100),
+	}
+
+	for _, name := range strings.Split(os.Getenv("IMAGE_VARIANTS"), ",") {
+		format, ok := imageFormats[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			continue
+		}
+		encoder := os.Getenv(format.encoderEnv)
+		if encoder == "" {
+			encoder = format.defaultEncoder
+		}
+		s.formats = append(s.formats, format)
+		s.encoders[format.name] = encoder
+	}
+	if q, err := strconv.Atoi(os.Getenv("IMAGE_VARIANT_QUALITY")); err == nil && q > 0 && q <= 100 {
+		s.quality = q
+	}
+	if s.Enabled() {
+		jobs.Register(JobImageVariants, nil)
+	}
+	return s
+}
+
+func (s *ImageVariantService) Enabled() bool {
+	return len(s.formats) > 0
+}
+
+// StartWorker processes queued conversions one at a time.
+func (s *ImageVariantService) StartWorker() {
+	if !s.Enabled() {
+		return
+	}
+	go func() {
+		for job := range s.queue {
+			// The worker runs one conversion at a time, so Start cannot fail
+			done, _ := s.jobs.Start(JobImageVariants)
+			done(s.convert(job))
+		}
+	}()
+}
+
+// Enqueue schedules variant generation for an uploaded file. Unsupported
+// types are ignored, and jobs are dropped if the queue is full since
+// variants are an optimization only.
+func (s *ImageVariantService) Enqueue(fileID int, path, mimeType string) {
+	if !s.Enabled() || !convertibleTypes[mimeType] {
+		return
+	}
+	select {
+	case s.queue <- imageJob{fileID: fileID, path: path}:
+	default:
+		s.logger.Warn("image variant queue full, skipping file", "event", "image_queue_full", "file_id", fileID)
+	}
+}
+
+// convert generates the variants of one image and returns how many were
+// stored along with the last error encountered.
+func (s *ImageVariantService) convert(job imageJob) (int, error) {
+	original, err := s.store.Stat(job.path)
+	if err != nil {
+		s.logger.Error("reading image for conversion failed", "event", "image_variant_failed", "file_id", job.fileID, "path", job.path, "error", err)
+		return 0, err
+	}
+	// The encoders only read and write local files
+	in, release, err := storage.LocalFile(s.store, job.path)
+	if err != nil {
+		s.logger.Error("reading image for conversion failed", "event", "image_variant_failed", "file_id", job.fileID, "path", job.path, "error", err)
+		return 0, err
+	}
+	defer release()
+
+	var stored int
+	var lastErr error
+	for _, format := range s.formats {
+		name := filepath.Base(job.path) + "." + format.name
+		// With original-name storage another upload may own this name
+		if _, err := s.store.Stat(s.store.Path(name)); err == nil {
+			s.logger.Warn("skipping variant, its name is taken", "event", "image_variant_skipped", "file_id", job.fileID, "format", format.name, "name", name)
+			continue
+		}
+		path, size, err := s.encode(format, in, name, original.Size)
+		if err != nil {
+			s.logger.Error("converting image failed", "event", "image_variant_failed", "file_id", job.fileID, "format", format.name, "error", err)
+			lastErr = err
+			continue
+		}
+		if path == "" {
+			continue
+		}
+
+		_, err = s.db.Exec(`
+			INSERT INTO file_variants (file_id, format, mime_type, file_path, file_size)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (file_id, format) DO NOTHING`,
+			job.fileID, format.name, format.mimeType, path, size,
+		)
+		if err != nil {
+			// The file was most likely deleted while converting
+			s.logger.Error("saving image variant failed", "event", "image_variant_failed", "file_id", job.fileID, "format", format.name, "error", err)
+			s.store.Delete(path)
+			lastErr = err
+			continue
+		}
+		stored++
+	}
+	return stored, lastErr
+}
+
+// encode converts the local file in to format and stores the result as the
+// blob name. It returns "" without an error when the variant would not be
+// smaller than the original or name was taken meanwhile.
+func (s *ImageVariantService) encode(format imageFormat, in, name string, originalSize int64) (string, int64, error) {
+	// The encoders pick the output format by extension
+	tmp, err := os.CreateTemp("", "variant-*."+format.name)
+	if err != nil {
+		return "", 0, err
+	}
+	out := tmp.Name()
+	tmp.Close()
+	defer os.Remove(out)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	cmd := exec.CommandContext(ctx, s.encoders[format.name], format.args(s.quality, in, out)...)
+	output, err := cmd.CombinedOutput()
+	cancel()
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %s", err, output)
+	}
+
+	// A variant is only worth serving if it saves bandwidth
+	variant, err := os.Open(out)
+	if err != nil {
+		return "", 0, err
+	}
+	defer variant.Close()
+	if info, err := variant.Stat(); err != nil || info.Size() >= originalSize {
+		return "", 0, err
+	}
+
+	path, size, err := s.store.Save(name, variant, storage.CollisionError)
+	if errors.Is(err, storage.ErrExists) {
+		return "", 0, nil
+	}
+	return path, size, err
+}
+
+// RemoveImageVariants deletes the variant blobs of a file, returning the
+// last error for the caller to log. Variants already gone are not an error,
+// so it can be repeated.
+// The rows go away with the file through ON DELETE CASCADE.
+func RemoveImageVariants(db *database.DB, store storage.Storage, fileID int) error {
+	rows, err := db.Query("SELECT file_path FROM file_variants WHERE file_id = $1", fileID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var lastErr error
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			continue
+		}
+		if err := store.Delete(path); err != nil && !os.IsNotExist(err) {
+			lastErr = fmt.Errorf("deleting variant %s: %w", path, err)
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/storage"
+)
+
+// Names of the integrity jobs: a read-only check and a check that repairs
+// what it safely can
+const (
+	JobIntegrityCheck  = "integrity_check"
+	JobIntegrityRepair = "integrity_repair"
+)
+
+// IntegrityIssue is a file found inconsistent by an integrity run.
+type IntegrityIssue struct {
+	FileID   int    `json:"file_id"`
+	UUID     string `json:"uuid"`
+	FilePath string `json:"file_path"`
+	Detail   string `json:"detail,omitempty"`
+	Fixed    bool   `json:"fixed"`
+}
+
+// IntegrityReport is the outcome of one integrity run.
+type IntegrityReport struct {
+	Fix          bool      `json:"fix"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	FilesChecked int       `json:"files_checked"`
+	// Download rows and rollups pointing at no file although download
+	// history is not retained, or at a file that no longer exists
+	OrphanedDownloads int64 `json:"orphaned_downloads"`
+	OrphanedRollups   int64 `json:"orphaned_rollups"`
+	// Files whose blob is gone; repairing deletes their records
+	MissingBlobs []IntegrityIssue `json:"missing_blobs"`
+	// Files whose content no longer matches their stored digest. These are
+	// only reported: the original content cannot be restored
+	ChecksumMismatches []IntegrityIssue `json:"checksum_mismatches"`
+}
+
+// IntegrityService checks the database against itself and the stored
+// blobs. Runs go through the job registry so they can be watched.
+type IntegrityService struct {
+	db      *database.DB
+	history *DownloadHistory
+	store   storage.Storage
+
+	mu   sync.Mutex
+	last *IntegrityReport
+}
+
+func NewIntegrityService(db *database.DB, history *DownloadHistory, jobs *JobRegistry, store storage.Storage) *IntegrityService {
+	s := &IntegrityService{db: db, history: history, store: store}
+	jobs.Register(JobIntegrityCheck, func() (int, error) { return s.run(false) })
+	jobs.Register(JobIntegrityRepair, func() (int, error) { return s.run(true) })
+	return s
+}
+
+// LastReport returns the report of the most recent finished run, or nil.
+func (s *IntegrityService) LastReport() *IntegrityReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// run performs all checks, repairing when fix is set, and returns the
+// number of files checked.
+func (s *IntegrityService) run(fix bool) (int, error) {
+	report := &IntegrityReport{
+		Fix:                fix,
+		StartedAt:          time.Now(),
+		MissingBlobs:       []IntegrityIssue{},
+		ChecksumMismatches: []IntegrityIssue{},
+	}
+
+	err := s.checkOrphans(report)
+	if err == nil {
+		err = s.checkFiles(report)
+	}
+	report.FinishedAt = time.Now()
+
+	s.mu.Lock()
+	s.last = report
+	s.mu.Unlock()
+	return report.FilesChecked, err
+}
+
+func (s *IntegrityService) checkOrphans(report *IntegrityReport) error {
+	// With retained history, rows without a file are expected
+	orphaned := "file_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM files f WHERE f.id = t.file_id)"
+	if !s.history.Retained() {
+		orphaned = "file_id IS NULL OR " + orphaned
+	}
+
+	for _, check := range []struct {
+		table string
+		count *int64
+	}{{"downloads", &report.OrphanedDownloads}, {"download_rollups", &report.OrphanedRollups}} {
+		if report.Fix {
+			res, err := s.db.Exec("DELETE FROM " + check.table + " t WHERE " + orphaned)
+			if err != nil {
+				return err
+			}
+			*check.count, _ = res.RowsAffected()
+			continue
+		}
+		err := s.db.Retry(func() error {
+			return s.db.QueryRow("SELECT COUNT(*) FROM " + check.table + " t WHERE " + orphaned).Scan(check.count)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *IntegrityService) checkFiles(report *IntegrityReport) error {
+	// The checksum taken at upload is authoritative; older files fall back
+	// to a digest cached since
+	rows, err := s.db.QueryRetry(`
+		SELECT f.id, f.uuid, f.file_path,
+		       COALESCE(encode(decode(f.checksum, 'hex'), 'base64'),
+		                (SELECT digest FROM file_digests WHERE file_id = f.id LIMIT 1))
+		FROM files f
+		WHERE f.scan_status <> 'infected'
+		ORDER BY f.id`)
+	if err != nil {
+		return err
+	}
+	var files []IntegrityIssue
+	var digests []*string
+	for rows.Next() {
+		var file IntegrityIssue
+		var digest *string
+		if err := rows.Scan(&file.FileID, &file.UUID, &file.FilePath, &digest); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, file)
+		digests = append(digests, digest)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, file := range files {
+		report.FilesChecked++
+		sum, err := blobSHA256(s.store, file.FilePath)
+		if os.IsNotExist(err) {
+			if report.Fix {
+				if err := RemoveImageVariants(s.db, s.store, file.FileID); err != nil {
+					log.Printf("Error deleting variants of file %d with missing blob: %v", file.FileID, err)
+				}
+				if err := s.history.DeleteFileRecord(file.FileID); err != nil {
+					log.Printf("Error deleting record of file %d with missing blob: %v", file.FileID, err)
+				} else {
+					file.Fixed = true
+				}
+			}
+			report.MissingBlobs = append(report.MissingBlobs, file)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error reading %s during integrity check: %v", file.FilePath, err)
+			continue
+		}
+		if digests[i] != nil && *digests[i] != sum {
+			file.Detail = "expected sha-256 " + *digests[i] + ", found " + sum
+			report.ChecksumMismatches = append(report.ChecksumMismatches, file)
+		}
+	}
+	return nil
+}
+
+// blobSHA256 returns the base64 SHA-256 of a blob, the form stored in
+// file_digests.
+func blobSHA256(store storage.Storage, path string) (string, error) {
+	f, err := store.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
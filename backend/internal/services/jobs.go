@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownJob        = errors.New("unknown job")
+	ErrJobNotRunnable    = errors.New("job cannot be triggered manually")
+	ErrJobAlreadyRunning = errors.New("job is already running")
+)
+
+// JobStatus describes the state of a background job as of its last run.
+type JobStatus struct {
+	Name           string     `json:"name"`
+	Running        bool       `json:"running"`
+	Triggerable    bool       `json:"triggerable"`
+	Runs           int        `json:"runs"`
+	LastStartedAt  *time.Time `json:"last_started_at"`
+	LastFinishedAt *time.Time `json:"last_finished_at"`
+	LastDurationMs int64      `json:"last_duration_ms"`
+	LastProcessed  int        `json:"last_processed"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+type job struct {
+	status JobStatus
+	run    func() (int, error)
+}
+
+// JobRegistry records the runs of background jobs so admins can see what
+// they did and when, and lets some of them be started on demand. State is
+// kept in memory and starts empty on every boot.
+type JobRegistry struct {
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string
+	// finished is signalled whenever a run ends
+	finished *sync.Cond
+}
+
+func NewJobRegistry() *JobRegistry {
+	r := &JobRegistry{jobs: make(map[string]*job)}
+	r.finished = sync.NewCond(&r.mu)
+	return r
+}
+
+// Register adds a job. run returns the number of items processed; a job
+// registered with a nil run can only report its runs through Start.
+func (r *JobRegistry) Register(name string, run func() (int, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.jobs[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.jobs[name] = &job{status: JobStatus{Name: name, Triggerable: run != nil}, run: run}
+}
+
+// Start marks a run of a registered job as begun and returns the function
+// recording its outcome, which returns the job's resulting status. It fails
+// if the job is already running.
+func (r *JobRegistry) Start(name string) (func(processed int, err error) JobStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[name]
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+	if j.status.Running {
+		return nil, ErrJobAlreadyRunning
+	}
+	started := time.Now()
+	j.status.Running = true
+	j.status.LastStartedAt = &started
+
+	return func(processed int, err error) JobStatus {
+		finished := time.Now()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		j.status.Running = false
+		j.status.Runs++
+		j.status.LastFinishedAt = &finished
+		j.status.LastDurationMs = finished.Sub(started).Milliseconds()
+		j.status.LastProcessed = processed
+		j.status.LastError = ""
+		if err != nil {
+			j.status.LastError = err.Error()
+		}
+		r.finished.Broadcast()
+		return j.status
+	}, nil
+}
+
+// Wait blocks until no job is running or ctx is done, so shutdown does not
+// cut a run short.
+func (r *JobRegistry) Wait(ctx context.Context) error {
+	idle := make(chan struct{})
+	go func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for r.anyRunning() {
+			r.finished.Wait()
+		}
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// anyRunning reports whether a job is running; r.mu must be held.
+func (r *JobRegistry) anyRunning() bool {
+	for _, j := range r.jobs {
+		if j.status.Running {
+			return true
+		}
+	}
+	return false
+}
+
+// Run executes a triggerable job now, on the calling goroutine, and returns
+// its status after the run. The error only reports why the job could not be
+// started; the outcome of the run is in the status.
+func (r *JobRegistry) Run(name string) (JobStatus, error) {
+	run, done, err := r.begin(name)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return done(run()), nil
+}
+
+// Trigger starts a triggerable job in the background.
+func (r *JobRegistry) Trigger(name string) error {
+	run, done, err := r.begin(name)
+	if err != nil {
+		return err
+	}
+	go func() { done(run()) }()
+	return nil
+}
+
+func (r *JobRegistry) begin(name string) (func() (int, error), func(int, error) JobStatus, error) {
+	r.mu.Lock()
+	j, ok := r.jobs[name]
+	r.mu.Unlock()
+	if !ok {
+		return nil, nil, ErrUnknownJob
+	}
+	if j.run == nil {
+		return nil, nil, ErrJobNotRunnable
+	}
+	done, err := r.Start(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return j.run, done, nil
+}
+
+// Statuses returns every job's status in registration order.
+func (r *JobRegistry) Statuses() []JobStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(r.order))
+	for _, name := range r.order {
+		statuses = append(statuses, r.jobs[name].status)
+	}
+	return statuses
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"file-sharing-backend/internal/storage"
+)
+
+// OrphanReport lists where the database and the blob store disagree.
+type OrphanReport struct {
+	CheckedAt    time.Time `json:"checked_at"`
+	FilesChecked int       `json:"files_checked"`
+	BlobsChecked int       `json:"blobs_checked"`
+	// Files whose blob is gone
+	MissingBlobs []IntegrityIssue `json:"missing_blobs"`
+	// Blobs in storage no file or image variant refers to
+	UntrackedBlobs []storage.Blob `json:"untracked_blobs"`
+}
+
+// FindOrphans compares the file and variant records against the blobs in
+// storage without reading or changing anything. It is a snapshot: a blob
+// committed by an upload whose record is not yet saved shows as untracked.
+func (s *IntegrityService) FindOrphans() (*OrphanReport, error) {
+	report := &OrphanReport{
+		CheckedAt:      time.Now(),
+		MissingBlobs:   []IntegrityIssue{},
+		UntrackedBlobs: []storage.Blob{},
+	}
+
+	// Listing the store first means blobs of files created meanwhile are
+	// not reported as untracked
+	blobs, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool)
+	rows, err := s.db.QueryRetry(`
+		SELECT id, uuid, file_path, TRUE FROM files WHERE scan_status <> 'infected'
+		UNION ALL
+		SELECT file_id, '', file_path, FALSE FROM file_variants`)
+	if err != nil {
+		return nil, err
+	}
+	var files []IntegrityIssue
+	for rows.Next() {
+		var file IntegrityIssue
+		var isFile bool
+		if err := rows.Scan(&file.FileID, &file.UUID, &file.FilePath, &isFile); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tracked[cleanBlobPath(file.FilePath)] = true
+		if isFile {
+			files = append(files, file)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		report.FilesChecked++
+		if _, err := s.store.Stat(file.FilePath); os.IsNotExist(err) {
+			report.MissingBlobs = append(report.MissingBlobs, file)
+		}
+	}
+	for _, blob := range blobs {
+		report.BlobsChecked++
+		if !tracked[cleanBlobPath(blob.Path)] {
+			report.UntrackedBlobs = append(report.UntrackedBlobs, blob)
+		}
+	}
+	return report, nil
+}
+
+// cleanBlobPath normalizes a stored path so records written with a relative
+// and an absolute UPLOAD_PATH compare equal.
+func cleanBlobPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
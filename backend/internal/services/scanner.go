@@ -0,0 +1,263 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/storage"
+)
+
+// Scan verdicts recorded in files.scan_status. Only clean files are served.
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+// JobScanPendingFiles scans every file still waiting for a verdict.
+const JobScanPendingFiles = "scan_pending_files"
+
+// scanChunkSize is the size of the chunks streamed to clamd.
+const scanChunkSize = 64 << 10
+
+// ScanService checks uploads for malware with a ClamAV daemon over TCP. It
+// is disabled unless CLAMD_ADDRESS is set. In the default inline mode
+// uploads wait for the verdict and infected ones are rejected; with
+// SCAN_MODE=async files are stored as pending and scanned in the background.
+type ScanService struct {
+	db      *database.DB
+	events  *EventBus
+	store   storage.Storage
+	logger  *slog.Logger
+	addr    string
+	timeout time.Duration
+	async   bool
+	queue   chan int
+}
+
+func NewScanService(db *database.DB, events *EventBus, jobs *JobRegistry, store storage.Storage, logger *slog.Logger) *ScanService {
+	s := &ScanService{
+		db:      db,
+		events:  events,
+		store:   store,
+		logger:  logger,
+		addr:    os.Getenv("CLAMD_ADDRESS"),
+		timeout: 5 * time.Minute,
+		async:   os.Getenv("SCAN_MODE") == "async",
+		queue:   make(chan int, 100),
+	}
+	if d, err := time.ParseDuration(os.Getenv("SCAN_TIMEOUT")); err == nil && d > 0 {
+		s.timeout = d
+	}
+	if s.Enabled() {
+		jobs.Register(JobScanPendingFiles, s.ScanPending)
+	}
+	return s
+}
+
+func (s *ScanService) Enabled() bool {
+	return s.addr != ""
+}
+
+// Async reports whether uploads are scanned in the background.
+func (s *ScanService) Async() bool {
+	return s.Enabled() && s.async
+}
+
+// Scan streams r to clamd and returns the name of the threat found, or ""
+// when r is clean.
+func (s *ScanService) Scan(r io.Reader) (string, error) {
+	conn, err := net.DialTimeout("tcp", s.addr, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	// clamd stops reading and answers as soon as a stream exceeds its
+	// StreamMaxLength, so its reply is read even if sending failed
+	sendErr := s.send(conn, r)
+	reply, err := io.ReadAll(conn)
+	if len(reply) == 0 {
+		if sendErr != nil {
+			return "", sendErr
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errors.New("clamd closed the connection without a verdict")
+	}
+
+	result := strings.TrimSpace(strings.TrimRight(string(reply), "\x00"))
+	result = strings.TrimPrefix(result, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", result)
+	}
+}
+
+// send writes r as a clamd INSTREAM command: length-prefixed chunks ended
+// by a zero-length one.
+func (s *ScanService) send(w io.Writer, r io.Reader) error {
+	if _, err := w.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+	buf := make([]byte, 4+scanChunkSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := w.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// StartWorker scans queued files one at a time in async mode, after first
+// picking up files left pending by an earlier run of the server.
+func (s *ScanService) StartWorker() {
+	if !s.Async() {
+		return
+	}
+	go func() {
+		if _, err := s.ScanPending(); err != nil {
+			s.logger.Error("scanning pending files failed", "event", "scan_failed", "error", err)
+		}
+		for fileID := range s.queue {
+			if err := s.scanFile(fileID); err != nil {
+				s.logger.Error("scanning file failed, it stays pending", "event", "scan_failed", "file_id", fileID, "error", err)
+			}
+		}
+	}()
+}
+
+// Enqueue schedules a pending file for scanning. A file dropped because the
+// queue is full stays pending until the scan_pending_files job runs.
+func (s *ScanService) Enqueue(fileID int) {
+	select {
+	case s.queue <- fileID:
+	default:
+		s.logger.Warn("scan queue full, file stays pending", "event", "scan_queue_full", "file_id", fileID)
+	}
+}
+
+// ScanPending scans every pending file and returns how many got a verdict.
+func (s *ScanService) ScanPending() (int, error) {
+	rows, err := s.db.QueryRetry("SELECT id FROM files WHERE scan_status = $1 ORDER BY id", ScanPending)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var scanned int
+	var lastErr error
+	for _, id := range ids {
+		if err := s.scanFile(id); err != nil {
+			s.logger.Error("scanning file failed, it stays pending", "event", "scan_failed", "file_id", id, "error", err)
+			lastErr = err
+			continue
+		}
+		scanned++
+	}
+	return scanned, lastErr
+}
+
+// scanFile scans a pending file and records the verdict. A file found
+// infected keeps its record, so the status can be seen until it expires,
+// but its blob and image variants are deleted. Other files sharing the blob
+// are marked infected too.
+func (s *ScanService) scanFile(fileID int) error {
+	var fileUUID, path string
+	err := s.db.QueryRow("SELECT uuid, file_path FROM files WHERE id = $1 AND scan_status = $2", fileID, ScanPending).
+		Scan(&fileUUID, &path)
+	if err == sql.ErrNoRows {
+		// Deleted, or already scanned
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := s.store.Open(path)
+	if err != nil {
+		return err
+	}
+	threat, err := s.Scan(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if threat == "" {
+		_, err := s.db.Exec("UPDATE files SET scan_status = $1 WHERE id = $2 AND scan_status = $3", ScanClean, fileID, ScanPending)
+		return err
+	}
+
+	s.logger.Warn("malware found, deleting the blob", "event", "malware_found", "file_uuid", fileUUID, "threat", threat)
+	if err := s.quarantine(path); err != nil {
+		return err
+	}
+	if err := RemoveImageVariants(s.db, s.store, fileID); err != nil {
+		s.logger.Error("deleting image variants failed", "event", "blob_delete_failed", "file_id", fileID, "error", err)
+	}
+	s.events.Publish("infected", "Malware found in uploaded file", map[string]interface{}{
+		"file_uuid": fileUUID,
+		"threat":    threat,
+	})
+	return nil
+}
+
+// quarantine marks every file stored in the blob at path infected and
+// deletes the blob, holding the blob lock so no upload reuses it meanwhile.
+func (s *ScanService) quarantine(path string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := LockBlob(tx, path); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE files SET scan_status = $1 WHERE file_path = $2", ScanInfected, path); err != nil {
+		return err
+	}
+	if err := s.store.Delete(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"file-sharing-backend/internal/database"
+)
+
+// SettingsService stores admin-managed runtime settings as JSON values in
+// the settings table.
+type SettingsService struct {
+	db *database.DB
+}
+
+func NewSettingsService(db *database.DB) *SettingsService {
+	return &SettingsService{db: db}
+}
+
+// get decodes the setting stored under key into dest, reporting whether the
+// setting exists. dest is left untouched when it does not.
+func (s *SettingsService) get(key string, dest interface{}) (bool, error) {
+	var value string
+	err := s.db.Retry(func() error {
+		return s.db.QueryRow("SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+	})
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(value), dest); err != nil {
+		return false, fmt.Errorf("invalid value for setting %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (s *SettingsService) set(key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()`,
+		key, string(encoded),
+	)
+	return err
+}
+
+// PasswordPolicy holds the rules applied to account and file-share passwords.
+type PasswordPolicy struct {
+	MinLength     int      `json:"min_length"`
+	RequireUpper  bool     `json:"require_upper"`
+	RequireLower  bool     `json:"require_lower"`
+	RequireDigit  bool     `json:"require_digit"`
+	RequireSymbol bool     `json:"require_symbol"`
+	Denylist      []string `json:"denylist"`
+}
+
+// DefaultPasswordPolicy applies until an admin saves a policy.
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 6, Denylist: []string{}}
+
+const (
+	maxPasswordMinLength = 128
+	maxDenylistEntries   = 1000
+)
+
+// ErrInvalidPolicy is returned when an admin submits an unusable policy.
+var ErrInvalidPolicy = errors.New("invalid password policy")
+
+// Check validates the policy itself before it is stored.
+func (p PasswordPolicy) Check() error {
+	if p.MinLength < 1 || p.MinLength > maxPasswordMinLength {
+		return fmt.Errorf("%w: min_length must be between 1 and %d", ErrInvalidPolicy, maxPasswordMinLength)
+	}
+	if len(p.Denylist) > maxDenylistEntries {
+		return fmt.Errorf("%w: denylist may contain at most %d entries", ErrInvalidPolicy, maxDenylistEntries)
+	}
+	return nil
+}
+
+// Validate returns a user-facing error describing the first rule password
+// breaks, or nil if it satisfies the policy.
+func (p PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !hasUpper:
+		return errors.New("password must contain an uppercase letter")
+	case p.RequireLower && !hasLower:
+		return errors.New("password must contain a lowercase letter")
+	case p.RequireDigit && !hasDigit:
+		return errors.New("password must contain a digit")
+	case p.RequireSymbol && !hasSymbol:
+		return errors.New("password must contain a symbol")
+	}
+
+	for _, denied := range p.Denylist {
+		if strings.EqualFold(password, denied) {
+			return errors.New("password is too common")
+		}
+	}
+	return nil
+}
+
+const passwordPolicyKey = "password_policy"
+
+// PasswordPolicy returns the configured policy, or the default if none is set.
+func (s *SettingsService) PasswordPolicy() (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy
+	if _, err := s.get(passwordPolicyKey, &policy); err != nil {
+		return DefaultPasswordPolicy, err
+	}
+	if policy.Denylist == nil {
+		policy.Denylist = []string{}
+	}
+	return policy, nil
+}
+
+func (s *SettingsService) SetPasswordPolicy(policy PasswordPolicy) error {
+	if err := policy.Check(); err != nil {
+		return err
+	}
+	return s.set(passwordPolicyKey, policy)
+}
+
+const requireSharePasswordKey = "require_share_password"
+
+// RequireSharePassword reports whether new shares must be password protected.
+func (s *SettingsService) RequireSharePassword() (bool, error) {
+	required := false
+	if _, err := s.get(requireSharePasswordKey, &required); err != nil {
+		return false, err
+	}
+	return required, nil
+}
+
+func (s *SettingsService) SetRequireSharePassword(required bool) error {
+	return s.set(requireSharePasswordKey, required)
+}
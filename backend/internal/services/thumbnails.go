@@ -0,0 +1,298 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log/slog"
+	"os"
+	"strconv"
+
+	// Decoders for the source types thumbnails are made of
+	_ "image/gif"
+	_ "image/png"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/storage"
+)
+
+// Thumbnail states of a file. Files that are not images have none.
+const (
+	ThumbnailPending     = "pending"
+	ThumbnailReady       = "ready"
+	ThumbnailUnsupported = "unsupported"
+	ThumbnailFailed      = "failed"
+)
+
+// ThumbnailFormat is the file_variants format thumbnails are stored under.
+// They are always JPEG.
+const ThumbnailFormat = "thumbnail"
+
+// JobGenerateThumbnails generates every thumbnail still pending.
+const JobGenerateThumbnails = "generate_thumbnails"
+
+const (
+	defaultThumbnailSize      = 256
+	defaultThumbnailMaxPixels = 24_000_000
+	thumbnailQuality          = 80
+)
+
+// thumbnailTypes are the sniffed types the standard library can decode.
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// ThumbnailService generates small JPEG previews of uploaded images in the
+// background. It is enabled unless THUMBNAILS=false. Images larger than
+// THUMBNAIL_MAX_PIXELS are never decoded, so a small file expanding into a
+// huge bitmap cannot exhaust memory.
+type ThumbnailService struct {
+	db        *database.DB
+	store     storage.Storage
+	logger    *slog.Logger
+	enabled   bool
+	size      int
+	maxPixels int
+	queue     chan int
+}
+
+func NewThumbnailService(db *database.DB, jobs *JobRegistry, store storage.Storage, logger *slog.Logger) *ThumbnailService {
+	s := &ThumbnailService{
+		db:        db,
+		store:     store,
+		logger:    logger,
+		enabled:   os.Getenv("THUMBNAILS") != "false",
+		size:      defaultThumbnailSize,
+		maxPixels: defaultThumbnailMaxPixels,
+		queue:     make(chan int, 100),
+	}
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_SIZE")); err == nil && v >= 16 && v <= 1024 {
+		s.size = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("THUMBNAIL_MAX_PIXELS")); err == nil && v > 0 {
+		s.maxPixels = v
+	}
+	if s.Enabled() {
+		jobs.Register(JobGenerateThumbnails, s.GeneratePending)
+	}
+	return s
+}
+
+func (s *ThumbnailService) Enabled() bool {
+	return s.enabled
+}
+
+// StartWorker generates queued thumbnails one at a time, after first picking
+// up files left pending by an earlier run of the server.
+func (s *ThumbnailService) StartWorker() {
+	if !s.Enabled() {
+		return
+	}
+	go func() {
+		if _, err := s.GeneratePending(); err != nil {
+			s.logger.Error("generating pending thumbnails failed", "event", "thumbnail_failed", "error", err)
+		}
+		for fileID := range s.queue {
+			if err := s.generate(fileID); err != nil {
+				s.logger.Error("generating thumbnail failed", "event", "thumbnail_failed", "file_id", fileID, "error", err)
+			}
+		}
+	}()
+}
+
+// Enqueue marks an uploaded file pending and schedules its thumbnail.
+// Types that cannot be decoded are ignored. A file dropped because the
+// queue is full stays pending until the generate_thumbnails job runs.
+func (s *ThumbnailService) Enqueue(fileID int, mimeType string) {
+	if !s.Enabled() || !thumbnailTypes[mimeType] {
+		return
+	}
+	if _, err := s.db.Exec("UPDATE files SET thumbnail_status = $1 WHERE id = $2", ThumbnailPending, fileID); err != nil {
+		s.logger.Error("queueing thumbnail failed", "event", "thumbnail_failed", "file_id", fileID, "error", err)
+		return
+	}
+	select {
+	case s.queue <- fileID:
+	default:
+		s.logger.Warn("thumbnail queue full, file stays pending", "event", "thumbnail_queue_full", "file_id", fileID)
+	}
+}
+
+// GeneratePending generates the thumbnail of every pending file and returns
+// how many were processed.
+func (s *ThumbnailService) GeneratePending() (int, error) {
+	rows, err := s.db.QueryRetry("SELECT id FROM files WHERE thumbnail_status = $1 ORDER BY id", ThumbnailPending)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var generated int
+	var lastErr error
+	for _, id := range ids {
+		if err := s.generate(id); err != nil {
+			s.logger.Error("generating thumbnail failed", "event", "thumbnail_failed", "file_id", id, "error", err)
+			lastErr = err
+			continue
+		}
+		generated++
+	}
+	return generated, lastErr
+}
+
+// generate makes the thumbnail of a pending file and records the outcome.
+// Only database errors leave the file pending; an image that cannot be
+// thumbnailed is marked unsupported or failed.
+func (s *ThumbnailService) generate(fileID int) error {
+	var path string
+	err := s.db.QueryRow(`
+		SELECT file_path FROM files
+		WHERE id = $1 AND thumbnail_status = $2 AND scan_status <> $3`,
+		fileID, ThumbnailPending, ScanInfected,
+	).Scan(&path)
+	if err == sql.ErrNoRows {
+		// Deleted, infected or already done
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	status := ThumbnailReady
+	thumbPath, size, err := s.render(fileID, path)
+	switch {
+	case errors.Is(err, errThumbnailUnsupported):
+		s.logger.Info("no thumbnail for file", "event", "thumbnail_unsupported", "file_id", fileID, "error", err)
+		status = ThumbnailUnsupported
+	case err != nil:
+		s.logger.Error("rendering thumbnail failed", "event", "thumbnail_failed", "file_id", fileID, "error", err)
+		status = ThumbnailFailed
+	case thumbPath != "":
+		_, err = s.db.Exec(`
+			INSERT INTO file_variants (file_id, format, mime_type, file_path, file_size)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (file_id, format) DO NOTHING`,
+			fileID, ThumbnailFormat, "image/jpeg", thumbPath, size,
+		)
+		if err != nil {
+			// The file was most likely deleted while rendering
+			s.store.Delete(thumbPath)
+			return err
+		}
+	}
+
+	_, err = s.db.Exec("UPDATE files SET thumbnail_status = $1 WHERE id = $2 AND thumbnail_status = $3", status, fileID, ThumbnailPending)
+	return err
+}
+
+// errThumbnailUnsupported reports an image that is not decoded, because its
+// format is unknown or it has too many pixels.
+var errThumbnailUnsupported = errors.New("unsupported image")
+
+// render stores the thumbnail of the image at path and returns its blob
+// path and size. The path is "" without an error when another run of the
+// same file stored it meanwhile.
+func (s *ThumbnailService) render(fileID int, path string) (string, int64, error) {
+	f, err := s.store.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	config, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", errThumbnailUnsupported, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > s.maxPixels {
+		return "", 0, fmt.Errorf("%w: %dx%d pixels", errThumbnailUnsupported, config.Width, config.Height)
+	}
+
+	f, err = s.store.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", 0, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, s.size), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return "", 0, err
+	}
+	thumbPath, size, err := s.store.Save(fmt.Sprintf("thumbnail-%d.jpg", fileID), &buf, storage.CollisionError)
+	if errors.Is(err, storage.ErrExists) {
+		return "", 0, nil
+	}
+	return thumbPath, size, err
+}
+
+// scaleDown fits src into a square of max pixels by averaging the source
+// pixels covering each target pixel. Images already small enough keep their
+// size. Transparent areas are laid over white, as JPEG has no alpha.
+func scaleDown(src image.Image, max int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := sw, sh
+	if sw > max || sh > max {
+		if sw >= sh {
+			dw, dh = max, sh*max/sw
+		} else {
+			dw, dh = sw*max/sh, max
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	// Sums of premultiplied 16-bit channels per target pixel
+	sums := make([][4]uint64, dw*dh)
+	counts := make([]uint64, dw*dh)
+	for y := 0; y < sh; y++ {
+		row := (y * dh / sh) * dw
+		for x := 0; x < sw; x++ {
+			i := row + x*dw/sw
+			r, g, b, a := src.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			sums[i][0] += uint64(r)
+			sums[i][1] += uint64(g)
+			sums[i][2] += uint64(b)
+			sums[i][3] += uint64(a)
+			counts[i]++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for i, sum := range sums {
+		n := counts[i]
+		if n == 0 {
+			n = 1
+		}
+		white := 0xffff - sum[3]/n
+		o := i * 4
+		dst.Pix[o] = uint8((sum[0]/n + white) >> 8)
+		dst.Pix[o+1] = uint8((sum[1]/n + white) >> 8)
+		dst.Pix[o+2] = uint8((sum[2]/n + white) >> 8)
+		dst.Pix[o+3] = 0xff
+	}
+	return dst
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"file-sharing-backend/internal/database"
+
+	"github.com/lib/pq"
+)
+
+const defaultTrashRetentionDays = 7
+
+// TrashRetention reads TRASH_RETENTION_DAYS (default 7), how long deleted
+// files can be restored before the cleanup job purges them. With 0 they are
+// purged on its next run.
+func TrashRetention() time.Duration {
+	days := defaultTrashRetentionDays
+	if v, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS")); err == nil && v >= 0 {
+		days = v
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// TrashFiles moves files to the trash, hiding them from listings and
+// downloads while keeping their blobs, and returns how many were moved.
+// Files already in the trash keep their original deletion time. The update
+// is not retried: a retry after a commit that went unacknowledged would
+// report the files as not moved.
+func TrashFiles(db *database.DB, fileIDs []int) (int, error) {
+	res, err := db.Exec("UPDATE files SET deleted_at = NOW(), updated_at = NOW() WHERE id = ANY($1) AND deleted_at IS NULL", pq.Array(fileIDs))
+	if err != nil {
+		return 0, err
+	}
+	moved, err := res.RowsAffected()
+	return int(moved), err
+}
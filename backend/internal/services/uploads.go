@@ -0,0 +1,39 @@
+package services
+
+import (
+	"os"
+
+	"file-sharing-backend/internal/database"
+	"file-sharing-backend/internal/storage"
+)
+
+// DeleteResumableUpload removes an unfinished resumable upload with the
+// staged blobs of its chunks. Blobs are deleted before the rows, so an
+// upload whose blobs could not all be deleted is kept for another attempt.
+func DeleteResumableUpload(db *database.DB, store storage.Storage, uploadID int) error {
+	rows, err := db.Query("SELECT blob_path FROM resumable_upload_chunks WHERE upload_id = $1", uploadID)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return err
+		}
+		paths = append(paths, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := store.Delete(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	_, err = db.Exec("DELETE FROM resumable_uploads WHERE id = $1", uploadID)
+	return err
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"file-sharing-backend/internal/database"
+)
+
+// ViewCounter counts share page views. Repeated views of a file from the
+// same IP within the window are counted once; the dedup state lives in
+// memory, which keeps counting to a single UPDATE per new viewer.
+type ViewCounter struct {
+	db      *database.DB
+	enabled bool
+	window  time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewViewCounter reads VIEW_COUNTING (enabled unless "false") and
+// VIEW_DEDUP_WINDOW (default 30m).
+func NewViewCounter(db *database.DB) *ViewCounter {
+	enabled := true
+	if v, err := strconv.ParseBool(os.Getenv("VIEW_COUNTING")); err == nil {
+		enabled = v
+	}
+	window, err := time.ParseDuration(os.Getenv("VIEW_DEDUP_WINDOW"))
+	if err != nil || window < 0 {
+		window = 30 * time.Minute
+	}
+	return &ViewCounter{
+		db:        db,
+		enabled:   enabled,
+		window:    window,
+		seen:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Record counts a view of the file from ip and reports whether it was
+// counted.
+func (v *ViewCounter) Record(fileID int, ip string) bool {
+	if !v.enabled || !v.firstView(fileID, ip) {
+		return false
+	}
+	if _, err := v.db.Exec("UPDATE files SET view_count = view_count + 1 WHERE id = $1", fileID); err != nil {
+		log.Printf("Error counting view of file %d: %v", fileID, err)
+		return false
+	}
+	return true
+}
+
+func (v *ViewCounter) firstView(fileID int, ip string) bool {
+	now := time.Now()
+	key := strconv.Itoa(fileID) + "|" + ip
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastSweep) > v.window {
+		for k, t := range v.seen {
+			if now.Sub(t) >= v.window {
+				delete(v.seen, k)
+			}
+		}
+		v.lastSweep = now
+	}
+
+	if t, ok := v.seen[key]; ok && now.Sub(t) < v.window {
+		return false
+	}
+	v.seen[key] = now
+	return true
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CollisionMode controls what Save does when a blob with the requested name
+// already exists.
+type CollisionMode int
+
+const (
+	// CollisionError refuses to write and returns ErrExists.
+	CollisionError CollisionMode = iota
+	// CollisionOverwrite atomically replaces the existing blob.
+	CollisionOverwrite
+	// CollisionSuffix stores the blob under "name-1.ext", "name-2.ext", ...
+	CollisionSuffix
+)
+
+// maxSuffix bounds the number of names CollisionSuffix tries.
+const maxSuffix = 10000
+
+var (
+	ErrExists      = errors.New("storage: file already exists")
+	ErrInvalidName = errors.New("storage: invalid file name")
+)
+
+// Local stores blobs as files in a single directory on the local disk.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return &Local{root: root}, nil
+}
+
+// Save writes r to a blob called name and returns the path it was stored at
+// (which differs from name under CollisionSuffix) and the bytes written.
+// A partially written blob is removed if the copy fails.
+func (l *Local) Save(name string, r io.Reader, mode CollisionMode) (string, int64, error) {
+	if !validName(name) {
+		return "", 0, ErrInvalidName
+	}
+
+	switch mode {
+	case CollisionError:
+		return l.create(filepath.Join(l.root, name), r)
+
+	case CollisionOverwrite:
+		return l.replace(filepath.Join(l.root, name), r)
+
+	case CollisionSuffix:
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		candidate := name
+		for i := 1; i <= maxSuffix; i++ {
+			path, n, err := l.create(filepath.Join(l.root, candidate), r)
+			if !errors.Is(err, ErrExists) {
+				return path, n, err
+			}
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		return "", 0, ErrExists
+
+	default:
+		return "", 0, fmt.Errorf("storage: unknown collision mode %d", mode)
+	}
+}
+
+// Stage writes r to a temporary blob that is invisible under any name Save
+// or Commit would use, returning its path and the bytes written. The caller
+// must Commit or Delete it. A partially written blob is removed if the copy
+// fails.
+func (l *Local) Stage(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(l.root, ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, err
+	}
+	return tmp.Name(), n, nil
+}
+
+// Commit moves a staged blob to name without copying it, applying mode like
+// Save does, and returns the path it was stored at. The staged blob is left
+// in place if Commit fails.
+func (l *Local) Commit(staged, name string, mode CollisionMode) (string, error) {
+	if !validName(name) {
+		return "", ErrInvalidName
+	}
+
+	switch mode {
+	case CollisionError:
+		return l.link(staged, filepath.Join(l.root, name))
+
+	case CollisionOverwrite:
+		path := filepath.Join(l.root, name)
+		if err := os.Rename(staged, path); err != nil {
+			return "", err
+		}
+		return path, nil
+
+	case CollisionSuffix:
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		candidate := name
+		for i := 1; i <= maxSuffix; i++ {
+			path, err := l.link(staged, filepath.Join(l.root, candidate))
+			if !errors.Is(err, ErrExists) {
+				return path, err
+			}
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		return "", ErrExists
+
+	default:
+		return "", fmt.Errorf("storage: unknown collision mode %d", mode)
+	}
+}
+
+// link gives a staged blob its final path, failing with ErrExists if path is
+// taken. Unlike a rename, a hard link never replaces an existing blob; on
+// file systems without hard links the blob is copied instead.
+func (l *Local) link(staged, path string) (string, error) {
+	if err := os.Link(staged, path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", ErrExists
+		}
+		src, openErr := os.Open(staged)
+		if openErr != nil {
+			return "", err
+		}
+		_, _, err = l.create(path, src)
+		src.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	os.Remove(staged)
+	return path, nil
+}
+
+// validName reports whether name is a single path element.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
+
+// Path returns where a blob called name is stored.
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.root, name)
+}
+
+// List returns every blob in the store, leaving out staged blobs of uploads
+// still in progress.
+func (l *Local) List() ([]Blob, error) {
+	entries, err := os.ReadDir(l.root)
+	if err != nil {
+		return nil, err
+	}
+	var blobs []Blob
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".upload-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Deleted since the directory was read
+			continue
+		}
+		blobs = append(blobs, Blob{Path: filepath.Join(l.root, entry.Name()), Size: info.Size(), ModifiedAt: info.ModTime()})
+	}
+	return blobs, nil
+}
+
+// Open opens the blob at path for reading.
+func (l *Local) Open(path string) (io.ReadSeekCloser, error) {
+	return os.Open(path)
+}
+
+// Stat returns the size and modification time of the blob at path.
+func (l *Local) Stat(path string) (Blob, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Blob{}, err
+	}
+	return Blob{Path: path, Size: info.Size(), ModifiedAt: info.ModTime()}, nil
+}
+
+// Delete removes the blob at path.
+func (l *Local) Delete(path string) error {
+	return os.Remove(path)
+}
+
+// create writes a new blob, failing with ErrExists if path is taken.
+func (l *Local) create(path string, r io.Reader) (string, int64, error) {
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", 0, ErrExists
+		}
+		return "", 0, err
+	}
+
+	n, err := io.Copy(dst, r)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+	return path, n, nil
+}
+
+// replace writes the blob to a temporary file and renames it over path, so
+// readers never observe a half-written replacement.
+func (l *Local) replace(path string, r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(l.root, ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, err
+	}
+	return path, n, nil
+}
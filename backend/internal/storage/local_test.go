@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestLocal(t *testing.T) *Local {
+	t.Helper()
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func readBlob(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// stagedLeft returns the staged blobs still in the store.
+func stagedLeft(t *testing.T, l *Local) []string {
+	t.Helper()
+	entries, err := os.ReadDir(l.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var staged []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".upload-") {
+			staged = append(staged, e.Name())
+		}
+	}
+	return staged
+}
+
+// collisionCases are the outcomes of writing "new" as report.pdf when a
+// blob of that name already holds "old".
+var collisionCases = []struct {
+	name     string
+	mode     CollisionMode
+	wantErr  error
+	wantName string
+	// wantOld is what the existing blob holds afterwards
+	wantOld string
+}{
+	{name: "error", mode: CollisionError, wantErr: ErrExists, wantOld: "old"},
+	{name: "overwrite", mode: CollisionOverwrite, wantName: "report.pdf", wantOld: "new"},
+	{name: "suffix", mode: CollisionSuffix, wantName: "report-1.pdf", wantOld: "old"},
+}
+
+func TestSaveCollisionModes(t *testing.T) {
+	for _, tc := range collisionCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newTestLocal(t)
+			existing, _, err := l.Save("report.pdf", strings.NewReader("old"), CollisionError)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path, n, err := l.Save("report.pdf", strings.NewReader("new"), tc.mode)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Save error = %v, want %v", err, tc.wantErr)
+			}
+			if got := readBlob(t, existing); got != tc.wantOld {
+				t.Errorf("existing blob holds %q, want %q", got, tc.wantOld)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if filepath.Base(path) != tc.wantName || n != 3 {
+				t.Errorf("Save = %q, %d bytes; want %q, 3 bytes", filepath.Base(path), n, tc.wantName)
+			}
+			if got := readBlob(t, path); got != "new" {
+				t.Errorf("saved blob holds %q, want %q", got, "new")
+			}
+			if staged := stagedLeft(t, l); len(staged) > 0 {
+				t.Errorf("temporary blobs left behind: %v", staged)
+			}
+		})
+	}
+}
+
+func TestCommitCollisionModes(t *testing.T) {
+	for _, tc := range collisionCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newTestLocal(t)
+			existing, _, err := l.Save("report.pdf", strings.NewReader("old"), CollisionError)
+			if err != nil {
+				t.Fatal(err)
+			}
+			staged, _, err := l.Stage(strings.NewReader("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path, err := l.Commit(staged, "report.pdf", tc.mode)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Commit error = %v, want %v", err, tc.wantErr)
+			}
+			if got := readBlob(t, existing); got != tc.wantOld {
+				t.Errorf("existing blob holds %q, want %q", got, tc.wantOld)
+			}
+			if tc.wantErr != nil {
+				// A failed commit leaves the staged blob for the caller
+				if got := readBlob(t, staged); got != "new" {
+					t.Errorf("staged blob holds %q, want %q", got, "new")
+				}
+				return
+			}
+			if filepath.Base(path) != tc.wantName {
+				t.Errorf("Commit = %q, want %q", filepath.Base(path), tc.wantName)
+			}
+			if got := readBlob(t, path); got != "new" {
+				t.Errorf("committed blob holds %q, want %q", got, "new")
+			}
+			if staged := stagedLeft(t, l); len(staged) > 0 {
+				t.Errorf("staged blobs left behind: %v", staged)
+			}
+		})
+	}
+}
+
+func TestSaveRejectsInvalidNames(t *testing.T) {
+	l := newTestLocal(t)
+	for _, name := range []string{"", ".", "..", "../escape", "dir/file", "/abs"} {
+		if _, _, err := l.Save(name, strings.NewReader("x"), CollisionError); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Save(%q) error = %v, want ErrInvalidName", name, err)
+		}
+		if _, err := l.Commit("unused", name, CollisionError); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Commit(%q) error = %v, want ErrInvalidName", name, err)
+		}
+	}
+}
+
+// commitConcurrently stages n blobs and commits them all as shared.bin at
+// once, returning the committed paths and the errors of failed commits.
+func commitConcurrently(t *testing.T, l *Local, n int, mode CollisionMode) ([]string, []error) {
+	t.Helper()
+	staged := make([]string, n)
+	for i := range staged {
+		path, _, err := l.Stage(strings.NewReader(fmt.Sprintf("blob %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		staged[i] = path
+	}
+
+	var mu sync.Mutex
+	var paths []string
+	var errs []error
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for _, s := range staged {
+		wg.Add(1)
+		go func(s string) {
+			defer wg.Done()
+			<-start
+			path, err := l.Commit(s, "shared.bin", mode)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			paths = append(paths, path)
+		}(s)
+	}
+	close(start)
+	wg.Wait()
+	return paths, errs
+}
+
+func TestConcurrentCommits(t *testing.T) {
+	const n = 20
+
+	t.Run("error", func(t *testing.T) {
+		l := newTestLocal(t)
+		paths, errs := commitConcurrently(t, l, n, CollisionError)
+		if len(paths) != 1 {
+			t.Fatalf("%d commits succeeded, want exactly 1", len(paths))
+		}
+		for _, err := range errs {
+			if !errors.Is(err, ErrExists) {
+				t.Errorf("losing commit error = %v, want ErrExists", err)
+			}
+		}
+		if staged := stagedLeft(t, l); len(staged) != n-1 {
+			t.Errorf("%d staged blobs left, want the %d that lost", len(staged), n-1)
+		}
+	})
+
+	t.Run("suffix", func(t *testing.T) {
+		l := newTestLocal(t)
+		paths, errs := commitConcurrently(t, l, n, CollisionSuffix)
+		if len(errs) > 0 {
+			t.Fatalf("commits failed: %v", errs)
+		}
+		contents := make(map[string]bool)
+		for _, path := range paths {
+			contents[readBlob(t, path)] = true
+		}
+		if len(contents) != n {
+			t.Errorf("%d distinct blobs stored, want %d", len(contents), n)
+		}
+		if staged := stagedLeft(t, l); len(staged) > 0 {
+			t.Errorf("staged blobs left behind: %v", staged)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		l := newTestLocal(t)
+		paths, errs := commitConcurrently(t, l, n, CollisionOverwrite)
+		if len(errs) > 0 {
+			t.Fatalf("commits failed: %v", errs)
+		}
+		if len(paths) != n {
+			t.Fatalf("%d commits succeeded, want %d", len(paths), n)
+		}
+		// The last rename wins whole; no blob is a mix of two writers
+		if got := readBlob(t, l.Path("shared.bin")); !strings.HasPrefix(got, "blob ") {
+			t.Errorf("shared.bin holds %q", got)
+		}
+		blobs, err := l.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blobs) != 1 || len(stagedLeft(t, l)) > 0 {
+			t.Errorf("store holds %d blobs and %d staged ones, want just shared.bin", len(blobs), len(stagedLeft(t, l)))
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNameBytes leaves room for a collision suffix and variant extensions
+// within the usual 255-byte file name limit.
+const maxNameBytes = 200
+
+// reservedNames cannot be used as file names on Windows, which matters when
+// an upload directory is copied to such a system for backup inspection.
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// SanitizeName turns a client-supplied file name into a safe, human-readable
+// blob name: directory components, control and shell-hostile characters and
+// leading dots are removed, reserved device names are prefixed, and overlong
+// names are shortened while keeping the extension. The result is never empty.
+func SanitizeName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), strings.ContainsRune(`/\:*?"<>|`, r):
+			b.WriteRune('_')
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name = strings.TrimLeft(strings.TrimSpace(b.String()), ".")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		name = "file"
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if reservedNames[strings.ToLower(base)] {
+		base = "_" + base
+	}
+	if len(ext) > maxNameBytes/4 {
+		ext = ""
+	}
+	for len(base)+len(ext) > maxNameBytes {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	if base == "" {
+		base = "file"
+	}
+	return base + ext
+}
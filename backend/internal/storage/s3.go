@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PartSize is how much of a streamed upload is buffered per part. Objects
+// are limited to 10000 parts, about 156 GiB.
+const s3PartSize = 16 << 20
+
+// S3 stores blobs as objects in an S3 or S3-compatible (e.g. MinIO) bucket.
+// Blob paths are object keys.
+//
+// S3 cannot copy an object only if the destination is free, so unlike Local
+// two commits racing for the same name may both succeed, the last one
+// winning. Blobs named by UUID never collide and content-named blobs are
+// committed under their blob lock, which leaves concurrent uploads of the
+// same original name (STORAGE_NAMING=original) as the only exposure.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	// prefix is prepended to every key, "" or ending in "/"
+	prefix string
+}
+
+// NewS3FromEnv connects to the bucket S3_BUCKET at S3_ENDPOINT (default AWS)
+// and checks that it exists. Credentials come from S3_ACCESS_KEY_ID and
+// S3_SECRET_ACCESS_KEY, else from the standard AWS environment variables or
+// an instance role. S3_USE_SSL=false allows plain HTTP, e.g. for a local
+// MinIO; S3_PREFIX keeps the blobs under a common key prefix.
+func NewS3FromEnv() (*S3, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, errors.New("S3_BUCKET is required")
+	}
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	var creds *credentials.Credentials
+	if id := os.Getenv("S3_ACCESS_KEY_ID"); id != "" {
+		creds = credentials.NewStaticV4(id, os.Getenv("S3_SECRET_ACCESS_KEY"), "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: os.Getenv("S3_USE_SSL") != "false",
+		Region: os.Getenv("S3_REGION"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 configuration: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach bucket %q: %w", bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %q does not exist", bucket)
+	}
+
+	prefix := strings.Trim(os.Getenv("S3_PREFIX"), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// Save uploads r as the object name, streaming it in parts.
+func (s *S3) Save(name string, r io.Reader, mode CollisionMode) (string, int64, error) {
+	if !validName(name) {
+		return "", 0, ErrInvalidName
+	}
+	key, err := s.target(name, mode)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := s.put(key, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return key, n, nil
+}
+
+// Stage uploads r under a temporary key that List leaves out.
+func (s *S3) Stage(r io.Reader) (string, int64, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, err
+	}
+	key := s.prefix + ".upload-" + hex.EncodeToString(b)
+	n, err := s.put(key, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return key, n, nil
+}
+
+// Commit copies a staged object to name within the bucket and removes the
+// staged one. The staged object is left in place if Commit fails.
+func (s *S3) Commit(staged, name string, mode CollisionMode) (string, error) {
+	if !validName(name) {
+		return "", ErrInvalidName
+	}
+	key, err := s.target(name, mode)
+	if err != nil {
+		return "", err
+	}
+
+	// Compose handles objects beyond the 5 GiB limit of a single copy
+	_, err = s.client.ComposeObject(context.Background(),
+		minio.CopyDestOptions{Bucket: s.bucket, Object: key},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: staged},
+	)
+	if err != nil {
+		return "", err
+	}
+	s.Delete(staged)
+	return key, nil
+}
+
+// Path returns the key of a blob called name.
+func (s *S3) Path(name string) string {
+	return s.prefix + name
+}
+
+// Open reads the object at key. Reads are fetched lazily, so seeking before
+// reading only downloads the requested range.
+func (s *S3) Open(key string) (io.ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, wrapErr("open", key, err)
+	}
+	// Surfaces a missing object now rather than on the first read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, wrapErr("open", key, err)
+	}
+	return obj, nil
+}
+
+// Stat returns the size and modification time of the object at key.
+func (s *S3) Stat(key string) (Blob, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Blob{}, wrapErr("stat", key, err)
+	}
+	return Blob{Path: key, Size: info.Size, ModifiedAt: info.LastModified}, nil
+}
+
+// Delete removes the object at key. Removing a missing object succeeds.
+func (s *S3) Delete(key string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// List returns the objects directly under the prefix, leaving out staged
+// objects of uploads still in progress.
+func (s *S3) List() ([]Blob, error) {
+	var blobs []Blob
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: s.prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, "/") || strings.HasPrefix(path.Base(obj.Key), ".upload-") {
+			continue
+		}
+		blobs = append(blobs, Blob{Path: obj.Key, Size: obj.Size, ModifiedAt: obj.LastModified})
+	}
+	return blobs, nil
+}
+
+// PresignGet returns a URL the object at key can be downloaded from without
+// credentials until expiry, served with the given Content-Disposition and
+// Content-Type.
+func (s *S3) PresignGet(key string, expiry time.Duration, disposition, contentType string) (string, error) {
+	params := url.Values{}
+	params.Set("response-content-disposition", disposition)
+	params.Set("response-content-type", contentType)
+	u, err := s.client.PresignedGetObject(context.Background(), s.bucket, key, expiry, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// put streams r to key and returns the bytes written.
+func (s *S3) put(key string, r io.Reader) (int64, error) {
+	info, err := s.client.PutObject(context.Background(), s.bucket, key, r, -1, minio.PutObjectOptions{
+		PartSize: s3PartSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// target returns the key a blob called name is written to under mode.
+func (s *S3) target(name string, mode CollisionMode) (string, error) {
+	switch mode {
+	case CollisionOverwrite:
+		return s.Path(name), nil
+
+	case CollisionError:
+		key := s.Path(name)
+		if _, err := s.Stat(key); err == nil {
+			return "", ErrExists
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		return key, nil
+
+	case CollisionSuffix:
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		candidate := name
+		for i := 1; i <= maxSuffix; i++ {
+			key, err := s.target(candidate, CollisionError)
+			if !errors.Is(err, ErrExists) {
+				return key, err
+			}
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		return "", ErrExists
+
+	default:
+		return "", fmt.Errorf("storage: unknown collision mode %d", mode)
+	}
+}
+
+// wrapErr turns a missing object into an error os.IsNotExist recognizes,
+// as callers expect from the local backend.
+func wrapErr(op, key string, err error) error {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+	}
+	return err
+}
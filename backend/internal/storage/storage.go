@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// Storage keeps the blobs of files and image variants. A blob is addressed
+// by the path Save or Commit returned for it, which is what file_path
+// records; its form depends on the backend. Blobs that do not exist are
+// reported with errors os.IsNotExist recognizes.
+type Storage interface {
+	// Save writes r to a blob called name and returns the path it was
+	// stored at (which differs from name under CollisionSuffix) and the
+	// bytes written.
+	Save(name string, r io.Reader, mode CollisionMode) (string, int64, error)
+	// Stage writes r to a temporary blob that must be committed or deleted.
+	Stage(r io.Reader) (string, int64, error)
+	// Commit gives a staged blob its final name, applying mode like Save.
+	Commit(staged, name string, mode CollisionMode) (string, error)
+	// Path returns where a blob called name is stored.
+	Path(name string) string
+	// Open reads a blob. Readers can seek, so range requests are served
+	// without reading the whole blob.
+	Open(path string) (io.ReadSeekCloser, error)
+	Stat(path string) (Blob, error)
+	Delete(path string) error
+	// List returns every committed blob.
+	List() ([]Blob, error)
+}
+
+// Presigner is implemented by backends that can hand out time-limited URLs
+// for downloading a blob directly from the backend.
+type Presigner interface {
+	PresignGet(path string, expiry time.Duration, disposition, contentType string) (string, error)
+}
+
+// Blob is a stored blob.
+type Blob struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// LocalFile returns a path on the local disk holding the blob, for external
+// tools that only read files. For blobs of other backends it is a temporary
+// copy. release must be called once the file is no longer needed.
+func LocalFile(s Storage, path string) (local string, release func(), err error) {
+	if _, ok := s.(*Local); ok {
+		return path, func() {}, nil
+	}
+
+	src, err := s.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "blob-*")
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, err
+	}
+	return tmp.Name(), func() { os.Remove(tmp.Name()) }, nil
+}
@@ -0,0 +1,8 @@
+-- Idempotency keys let clients safely retry uploads: files created by a
+-- request are tagged with its key and their position in the request
+ALTER TABLE files ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255) NULL;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS upload_index INTEGER NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_files_user_idempotency_key
+    ON files(user_id, idempotency_key, upload_index)
+    WHERE idempotency_key IS NOT NULL;
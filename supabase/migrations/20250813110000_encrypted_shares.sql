@@ -0,0 +1,5 @@
+-- Zero-knowledge shares: the blob is encrypted client-side; the server keeps
+-- the (non-secret) KDF parameters and a bcrypt hash of the key verifier
+ALTER TABLE files ADD COLUMN IF NOT EXISTS is_encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS encryption_params TEXT NULL;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS key_verifier_hash VARCHAR(255) NULL;
@@ -0,0 +1,8 @@
+-- Folder uploads: files uploaded together from a directory share a
+-- folder_uuid and keep their path relative to the folder root
+ALTER TABLE files ADD COLUMN IF NOT EXISTS folder_uuid VARCHAR(255) NULL;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS relative_path TEXT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_files_folder_relative_path
+    ON files(folder_uuid, relative_path)
+    WHERE folder_uuid IS NOT NULL;
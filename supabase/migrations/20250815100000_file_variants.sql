@@ -0,0 +1,11 @@
+-- Web-friendly variants (WebP/AVIF) generated for uploaded images
+CREATE TABLE IF NOT EXISTS file_variants (
+    id SERIAL PRIMARY KEY,
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    format VARCHAR(20) NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    file_path VARCHAR(500) NOT NULL,
+    file_size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (file_id, format)
+);
@@ -0,0 +1,10 @@
+-- Cached whole-file and per-block SHA-256 digests for verifying resumed downloads
+CREATE TABLE IF NOT EXISTS file_digests (
+    file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    block_size BIGINT NOT NULL,
+    digest VARCHAR(64) NOT NULL,
+    file_size BIGINT NOT NULL,
+    blocks TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (file_id, block_size)
+);
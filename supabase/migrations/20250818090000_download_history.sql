@@ -0,0 +1,7 @@
+-- Keep download rows when their file is deleted so aggregate stats survive.
+-- Whether rows are kept (anonymized) or removed is decided by the backend
+-- according to RETAIN_DOWNLOAD_HISTORY.
+ALTER TABLE downloads DROP CONSTRAINT IF EXISTS downloads_file_id_fkey;
+ALTER TABLE downloads
+    ADD CONSTRAINT downloads_file_id_fkey
+    FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE SET NULL;
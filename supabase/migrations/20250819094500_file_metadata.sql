@@ -0,0 +1,4 @@
+-- Arbitrary integrator-supplied key/value metadata per file
+ALTER TABLE files ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+CREATE INDEX IF NOT EXISTS idx_files_metadata ON files USING GIN (metadata);
@@ -0,0 +1,3 @@
+-- Per-file download speed limit in bytes per second. NULL falls back to
+-- DOWNLOAD_RATE_LIMIT, 0 means unlimited.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS download_rate_limit BIGINT;
@@ -0,0 +1,2 @@
+-- Share page views, counted separately from downloads
+ALTER TABLE files ADD COLUMN IF NOT EXISTS view_count INTEGER NOT NULL DEFAULT 0;
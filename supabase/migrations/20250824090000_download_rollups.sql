@@ -0,0 +1,10 @@
+-- Daily per-file download totals kept after old download rows are pruned
+CREATE TABLE IF NOT EXISTS download_rollups (
+    id SERIAL PRIMARY KEY,
+    day DATE NOT NULL,
+    file_id INTEGER REFERENCES files(id) ON DELETE SET NULL,
+    count INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_rollups_day ON download_rollups(day);
+CREATE INDEX IF NOT EXISTS idx_download_rollups_file_id ON download_rollups(file_id);
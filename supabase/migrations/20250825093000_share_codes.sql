@@ -0,0 +1,4 @@
+-- Short codes reaching a share at /p/:code
+ALTER TABLE files ADD COLUMN IF NOT EXISTS share_code VARCHAR(16);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_files_share_code ON files(share_code) WHERE share_code IS NOT NULL;
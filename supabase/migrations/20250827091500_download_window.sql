@@ -0,0 +1,3 @@
+-- Shares can stop working before the file itself expires. NULL keeps
+-- downloads enabled until expires_at.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS download_enabled_until TIMESTAMP;
@@ -0,0 +1,5 @@
+-- Owners can list files on their public profile. Unlisted files stay
+-- reachable only through their share link.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS public_listed BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_files_public_listed ON files(user_id, created_at) WHERE public_listed;
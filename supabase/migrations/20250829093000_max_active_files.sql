@@ -0,0 +1,3 @@
+-- Per-user override of MAX_ACTIVE_FILES. NULL uses the default, 0 lifts
+-- the cap.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_active_files INTEGER;
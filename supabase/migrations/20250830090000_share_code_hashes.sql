@@ -0,0 +1,3 @@
+-- Share codes may be chosen by users and may be stored as hex HMAC-SHA256
+-- digests (SHARE_CODE_HASH), both longer than generated codes.
+ALTER TABLE files ALTER COLUMN share_code TYPE VARCHAR(64);
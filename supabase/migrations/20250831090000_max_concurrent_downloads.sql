@@ -0,0 +1,3 @@
+-- Optional cap on simultaneous in-progress downloads of a file. NULL means
+-- unlimited.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS max_concurrent_downloads INTEGER;
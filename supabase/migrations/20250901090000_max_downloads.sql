@@ -0,0 +1,3 @@
+-- Optional cap on the number of downloads; a file that reaches it is gone
+-- like an expired one. NULL means unlimited.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS max_downloads INTEGER;
@@ -0,0 +1,6 @@
+-- Trigram indexes let the admin file search match substrings of file names
+-- and owner emails with ILIKE without scanning every row.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_files_original_name_trgm ON files USING gin (original_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (email gin_trgm_ops);
@@ -0,0 +1,3 @@
+-- Hex SHA-256 of each file's bytes, computed while the upload is written.
+-- NULL for files uploaded before checksums were recorded.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS checksum VARCHAR(64);
@@ -0,0 +1,3 @@
+-- "Burn after reading" files: limited to one download and deleted right
+-- after it.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS one_time BOOLEAN NOT NULL DEFAULT FALSE;
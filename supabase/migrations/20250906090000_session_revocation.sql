@@ -0,0 +1,3 @@
+-- Access tokens issued before this moment are rejected, so a password change
+-- ends every other session without waiting for tokens to expire.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMPTZ;
@@ -0,0 +1,12 @@
+-- Failed logins per email and client IP, counted to lock out repeated
+-- attempts for a while. Rows are dropped once they leave the lockout window
+-- or the login succeeds.
+CREATE TABLE IF NOT EXISTS login_failures (
+    id BIGSERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+    attempted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_email_ip ON login_failures(email, ip_address, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_login_failures_attempted_at ON login_failures(attempted_at);
@@ -0,0 +1,5 @@
+-- Malware scanner verdict for each file: pending, clean or infected. Files
+-- uploaded before scanning existed count as clean; only clean files are served.
+ALTER TABLE files ADD COLUMN IF NOT EXISTS scan_status VARCHAR(16) NOT NULL DEFAULT 'clean';
+
+CREATE INDEX IF NOT EXISTS idx_files_scan_pending ON files(id) WHERE scan_status = 'pending';
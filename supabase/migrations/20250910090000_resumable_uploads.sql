@@ -0,0 +1,24 @@
+-- Resumable uploads in progress. Each received chunk is kept as a staged
+-- blob until the upload is completed into a file, aborted or abandoned.
+-- Uploads of deleted accounts lose their owner and are removed by cleanup.
+CREATE TABLE IF NOT EXISTS resumable_uploads (
+    id SERIAL PRIMARY KEY,
+    uuid VARCHAR(36) UNIQUE NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    file_name VARCHAR(500) NOT NULL,
+    total_size BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    completing BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_resumable_uploads_updated_at ON resumable_uploads(updated_at);
+
+CREATE TABLE IF NOT EXISTS resumable_upload_chunks (
+    upload_id INTEGER NOT NULL REFERENCES resumable_uploads(id) ON DELETE CASCADE,
+    chunk_offset BIGINT NOT NULL,
+    chunk_size BIGINT NOT NULL,
+    blob_path VARCHAR(500) NOT NULL,
+    PRIMARY KEY (upload_id, chunk_offset)
+);
@@ -0,0 +1,4 @@
+-- Whether a user confirmed their email address through a verification
+-- link. Accounts created before verification existed count as verified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT FALSE;
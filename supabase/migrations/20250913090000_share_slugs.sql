@@ -0,0 +1,4 @@
+-- Optional human-readable share slugs, reachable at /share/:slug
+ALTER TABLE files ADD COLUMN IF NOT EXISTS slug VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_files_slug ON files(slug) WHERE slug IS NOT NULL;
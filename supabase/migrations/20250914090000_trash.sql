@@ -0,0 +1,5 @@
+-- Deleted files stay in the owner's trash, restorable, until the cleanup job
+-- purges them TRASH_RETENTION_DAYS after deleted_at
+ALTER TABLE files ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,26 @@
+-- Roles replace the single is_admin flag: user, moderator or admin. is_admin
+-- is kept in step with role by a trigger, so setting either one works.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'moderator', 'admin'));
+
+UPDATE users SET role = 'admin' WHERE is_admin AND role <> 'admin';
+
+CREATE OR REPLACE FUNCTION sync_user_role() RETURNS TRIGGER AS $$
+BEGIN
+    -- A change of is_admin alone, e.g. an admin promoted by hand, sets the role
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.is_admin AND NEW.role = 'user' THEN
+            NEW.role := 'admin';
+        END IF;
+    ELSIF NEW.is_admin IS DISTINCT FROM OLD.is_admin AND NEW.role = OLD.role THEN
+        NEW.role := CASE WHEN NEW.is_admin THEN 'admin' ELSE 'user' END;
+    END IF;
+    NEW.is_admin := NEW.role = 'admin';
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_sync_role ON users;
+CREATE TRIGGER users_sync_role
+    BEFORE INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION sync_user_role();
@@ -0,0 +1,2 @@
+-- Owners can switch a share off and on again without touching the file
+ALTER TABLE files ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
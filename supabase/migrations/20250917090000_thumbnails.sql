@@ -0,0 +1,6 @@
+-- Thumbnails of uploaded images are generated in the background; the
+-- status is NULL for files that get none
+ALTER TABLE files ADD COLUMN IF NOT EXISTS thumbnail_status VARCHAR(16);
+
+-- Pending thumbnails are picked up again when the server restarts
+CREATE INDEX IF NOT EXISTS idx_files_thumbnail_pending ON files(id) WHERE thumbnail_status = 'pending';
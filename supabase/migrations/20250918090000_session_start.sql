@@ -0,0 +1,6 @@
+-- A refresh token remembers when the session it belongs to started, so the
+-- credentials overview can tell a session's start from its last refresh
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_started_at TIMESTAMP;
+UPDATE refresh_tokens SET session_started_at = created_at WHERE session_started_at IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN session_started_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE refresh_tokens ALTER COLUMN session_started_at SET NOT NULL;
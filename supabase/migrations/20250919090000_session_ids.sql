@@ -0,0 +1,16 @@
+-- A refresh token carries the id of the login session it belongs to. A
+-- refresh hands the id on to the token it issues, so a session keeps one id
+-- it can be listed and revoked by however often it is refreshed. Existing
+-- tokens each become a session of their own
+CREATE SEQUENCE IF NOT EXISTS refresh_token_session_seq;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_id INTEGER;
+UPDATE refresh_tokens SET session_id = id WHERE session_id IS NULL;
+SELECT setval('refresh_token_session_seq', GREATEST(
+    (SELECT MAX(id) FROM refresh_tokens),
+    (SELECT last_value FROM refresh_token_session_seq),
+    1
+));
+ALTER TABLE refresh_tokens ALTER COLUMN session_id SET DEFAULT nextval('refresh_token_session_seq');
+ALTER TABLE refresh_tokens ALTER COLUMN session_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(user_id, session_id);